@@ -0,0 +1,300 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProxyConfig defines the proxy settings used by the agent in the ramdisk.
+type ProxyConfig struct {
+	// httpProxy is the URL of the proxy for HTTP requests.
+	// +optional
+	HTTPProxy string `json:"httpProxy,omitempty"`
+
+	// httpsProxy is the URL of the proxy for HTTPS requests.
+	// +optional
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+
+	// noProxy is a comma-separated list of hostnames and CIDRs for which the
+	// proxy should not be used.
+	// +optional
+	NoProxy string `json:"noProxy,omitempty"`
+}
+
+// InspectionMode determines how the agent reports the results of hardware
+// inspection.
+// +kubebuilder:validation:Enum=Inspector;Ironic;Disabled
+type InspectionMode string
+
+const (
+	// InspectionModeInspector reports to a standalone Ironic Inspector.
+	InspectionModeInspector InspectionMode = "Inspector"
+	// InspectionModeIronic reports to the inspection built into Ironic.
+	InspectionModeIronic InspectionMode = "Ironic"
+	// InspectionModeDisabled does not configure inspection.
+	InspectionModeDisabled InspectionMode = "Disabled"
+)
+
+// HostnameSource is a source of the hostname of the ramdisk.
+// +kubebuilder:validation:Enum=DHCPv4;DHCPv6;ReverseDNS;BareMetalHost;PreprovisioningImage;Template
+type HostnameSource string
+
+const (
+	// HostnameSourceDHCPv4 is the hostname or FQDN provided by DHCPv4.
+	HostnameSourceDHCPv4 HostnameSource = "DHCPv4"
+	// HostnameSourceDHCPv6 is the FQDN provided by DHCPv6.
+	HostnameSourceDHCPv6 HostnameSource = "DHCPv6"
+	// HostnameSourceReverseDNS is the name found by a reverse DNS lookup of
+	// the host's IP address.
+	HostnameSourceReverseDNS HostnameSource = "ReverseDNS"
+	// HostnameSourceBareMetalHost is the name of the BareMetalHost that owns
+	// the PreprovisioningImage.
+	HostnameSourceBareMetalHost HostnameSource = "BareMetalHost"
+	// HostnameSourcePreprovisioningImage is the name of the
+	// PreprovisioningImage.
+	HostnameSourcePreprovisioningImage HostnameSource = "PreprovisioningImage"
+	// HostnameSourceTemplate is the result of the hostname template.
+	HostnameSourceTemplate HostnameSource = "Template"
+)
+
+// HostnameConfig determines how the hostname of the ramdisk is chosen.
+type HostnameConfig struct {
+	// sources are the sources of the hostname, in priority order. The
+	// hostname is set from the first source that provides one. Defaults to
+	// DHCPv6, PreprovisioningImage.
+	// +optional
+	Sources []HostnameSource `json:"sources,omitempty"`
+
+	// template is a Go template for the hostname used by the Template
+	// source. The fields .Name and .Namespace of the PreprovisioningImage and
+	// .BareMetalHost are available, e.g. {{.Name}}.{{.Namespace}}.example.com
+	// +optional
+	Template string `json:"template,omitempty"`
+}
+
+// RegistryMirror configures mirrors of a container registry or repository.
+type RegistryMirror struct {
+	// source is the registry or repository that is mirrored, e.g.
+	// quay.io/metal3-io. A leading wildcard matches subdomains.
+	Source string `json:"source"`
+
+	// mirrors are the locations of the mirrors, in priority order.
+	// +kubebuilder:validation:MinItems=1
+	Mirrors []string `json:"mirrors"`
+
+	// mirrorByDigestOnly restricts the mirrors to pulls by digest.
+	// +optional
+	MirrorByDigestOnly bool `json:"mirrorByDigestOnly,omitempty"`
+}
+
+// AgentMount is a path on the host that is mounted in the agent container.
+type AgentMount struct {
+	// hostPath is the path on the host.
+	// +kubebuilder:validation:Pattern=`^/[^,]*$`
+	HostPath string `json:"hostPath"`
+
+	// mountPath is the path in the container. Defaults to hostPath.
+	// +kubebuilder:validation:Pattern=`^/[^,]*$`
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+
+	// readOnly mounts the path read-only.
+	// +optional
+	ReadOnly bool `json:"readOnly,omitempty"`
+}
+
+// AgentEnvVar is an environment variable set in the agent container.
+type AgentEnvVar struct {
+	// name is the name of the variable.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*$`
+	Name string `json:"name"`
+
+	// value is the value of the variable.
+	// +optional
+	Value string `json:"value,omitempty"`
+}
+
+// AgentPullPolicy determines when the agent image is pulled.
+// +kubebuilder:validation:Enum=Always;IfNotPresent;Never
+type AgentPullPolicy string
+
+const (
+	// PullAlways pulls the image every time the agent starts.
+	PullAlways AgentPullPolicy = "Always"
+	// PullIfNotPresent pulls the image only if it is not already present,
+	// e.g. in an image built with the agent included.
+	PullIfNotPresent AgentPullPolicy = "IfNotPresent"
+	// PullNever never pulls the image.
+	PullNever AgentPullPolicy = "Never"
+)
+
+// AgentRuntimeConfig defines how the agent container is run in the ramdisk,
+// in addition to the options that are always used.
+type AgentRuntimeConfig struct {
+	// mounts are additional host paths mounted in the container.
+	// +optional
+	Mounts []AgentMount `json:"mounts,omitempty"`
+
+	// devices are additional host devices added to the container, in the
+	// format of the podman --device option.
+	// +optional
+	Devices []string `json:"devices,omitempty"`
+
+	// env are additional environment variables set in the container.
+	// +optional
+	Env []AgentEnvVar `json:"env,omitempty"`
+
+	// podmanFlags are additional flags passed to podman run.
+	// +optional
+	PodmanFlags []string `json:"podmanFlags,omitempty"`
+
+	// pullPolicy determines when the image is pulled. Defaults to Always.
+	// +optional
+	PullPolicy AgentPullPolicy `json:"pullPolicy,omitempty"`
+
+	// restart is the systemd Restart= policy of the agent service. Defaults
+	// to on-failure.
+	// +kubebuilder:validation:Enum=no;always;on-success;on-failure;on-abnormal;on-abort;on-watchdog
+	// +optional
+	Restart string `json:"restart,omitempty"`
+
+	// after are additional systemd units that the agent service is started
+	// after.
+	// +optional
+	After []string `json:"after,omitempty"`
+
+	// requires are additional systemd units that the agent service requires.
+	// +optional
+	Requires []string `json:"requires,omitempty"`
+}
+
+// ImageCustomizationConfigSpec defines the configuration used to build all
+// images. Any field that is not set falls back to the value from the
+// controller's environment.
+type ImageCustomizationConfigSpec struct {
+	// ironicBaseURL is the base URL of the Ironic API.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +optional
+	IronicBaseURL string `json:"ironicBaseURL,omitempty"`
+
+	// ironicInspectorBaseURL is the base URL of the Ironic Inspector API.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +optional
+	IronicInspectorBaseURL string `json:"ironicInspectorBaseURL,omitempty"`
+
+	// ironicAgentImage is the pullspec of the Ironic Python Agent container
+	// image.
+	// +optional
+	IronicAgentImage string `json:"ironicAgentImage,omitempty"`
+
+	// ironicRAMDiskSSHKey is a public SSH key authorized to log in to the
+	// ramdisk as the core user.
+	// +optional
+	IronicRAMDiskSSHKey string `json:"ironicRAMDiskSSHKey,omitempty"`
+
+	// inspectionMode determines where the agent sends the results of
+	// hardware inspection: a standalone Ironic Inspector, the inspection
+	// built into Ironic, or nowhere.
+	// +optional
+	InspectionMode InspectionMode `json:"inspectionMode,omitempty"`
+
+	// ipOptions are the IP options to pass to the agent, e.g. ip=dhcp6.
+	// +optional
+	IPOptions string `json:"ipOptions,omitempty"`
+
+	// proxy defines the proxy settings for the agent.
+	// +optional
+	Proxy ProxyConfig `json:"proxy,omitempty"`
+
+	// hostname determines how the hostname of the ramdisk is chosen.
+	// +optional
+	Hostname *HostnameConfig `json:"hostname,omitempty"`
+
+	// ntpServers are the NTP servers that the ramdisk synchronizes its clock
+	// with before starting the agent.
+	// +optional
+	NTPServers []string `json:"ntpServers,omitempty"`
+
+	// registryMirrors configures mirrors of the registries that the ramdisk
+	// pulls images from.
+	// +optional
+	RegistryMirrors []RegistryMirror `json:"registryMirrors,omitempty"`
+
+	// networkFallbackTimeout enables falling back to DHCP on all NICs when
+	// a host with static network configuration cannot reach Ironic within
+	// this time after boot.
+	// +optional
+	NetworkFallbackTimeout *metav1.Duration `json:"networkFallbackTimeout,omitempty"`
+
+	// agentRuntime defines additional options for running the agent
+	// container.
+	// +optional
+	AgentRuntime *AgentRuntimeConfig `json:"agentRuntime,omitempty"`
+}
+
+type ConfigConditionType string
+
+const (
+	// Valid indicates that the configuration has been accepted and is in use
+	// for building images.
+	ConditionConfigValid ConfigConditionType = "Valid"
+)
+
+// ImageCustomizationConfigStatus defines the observed state of
+// ImageCustomizationConfig
+type ImageCustomizationConfigStatus struct {
+	// observedGeneration is the generation of the configuration that was
+	// last processed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// conditions describe the state of the configuration
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=iccfg
+// +kubebuilder:printcolumn:name="Valid",type="string",JSONPath=".status.conditions[?(@.type=='Valid')].status",description="Whether the configuration is valid"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type=='Valid')].reason",description="The reason for the validity status"
+// +kubebuilder:subresource:status
+
+// ImageCustomizationConfig is the Schema for the imagecustomizationconfigs
+// API
+type ImageCustomizationConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ImageCustomizationConfigSpec   `json:"spec,omitempty"`
+	Status ImageCustomizationConfigStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ImageCustomizationConfigList contains a list of ImageCustomizationConfig
+type ImageCustomizationConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ImageCustomizationConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ImageCustomizationConfig{}, &ImageCustomizationConfigList{})
+}
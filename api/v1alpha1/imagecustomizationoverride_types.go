@@ -0,0 +1,93 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OverrideName is the name of the ImageCustomizationOverride in each
+// namespace that applies to the images in that namespace.
+const OverrideName = "default"
+
+// ImageCustomizationOverrideSpec defines configuration that applies to all
+// images in a namespace, overriding the cluster-wide configuration. Any field
+// that is not set falls back to the cluster-wide value.
+type ImageCustomizationOverrideSpec struct {
+	// ironicAgentImage is the pullspec of the Ironic Python Agent container
+	// image.
+	// +optional
+	IronicAgentImage string `json:"ironicAgentImage,omitempty"`
+
+	// ironicAgentPullSecretRef is a reference to a Secret in the same
+	// namespace, containing a .dockerconfigjson key with the credentials used
+	// to pull the Ironic Python Agent container image.
+	// +optional
+	IronicAgentPullSecretRef *corev1.LocalObjectReference `json:"ironicAgentPullSecretRef,omitempty"`
+
+	// mergePullSecret indicates that the credentials in the Secret referenced
+	// by ironicAgentPullSecretRef are added to those of the cluster-wide pull
+	// secret, rather than replacing it.
+	// +optional
+	MergePullSecret bool `json:"mergePullSecret,omitempty"`
+
+	// ironicRAMDiskSSHKey is a public SSH key authorized to log in to the
+	// ramdisk as the core user.
+	// +optional
+	IronicRAMDiskSSHKey string `json:"ironicRAMDiskSSHKey,omitempty"`
+
+	// sshAuthorizedKeysSecretRef is a reference to a Secret in the same
+	// namespace, each key of which contains public SSH keys (in
+	// authorized_keys format) that are authorized to log in to the ramdisk as
+	// the core user in addition to ironicRAMDiskSSHKey.
+	// +optional
+	SSHAuthorizedKeysSecretRef *corev1.LocalObjectReference `json:"sshAuthorizedKeysSecretRef,omitempty"`
+
+	// proxy defines the proxy settings for the agent.
+	// +optional
+	Proxy ProxyConfig `json:"proxy,omitempty"`
+
+	// agentRuntime defines additional options for running the agent
+	// container. If set, it replaces the cluster-wide agentRuntime.
+	// +optional
+	AgentRuntime *AgentRuntimeConfig `json:"agentRuntime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=icovr
+
+// ImageCustomizationOverride is the Schema for the
+// imagecustomizationoverrides API
+type ImageCustomizationOverride struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ImageCustomizationOverrideSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ImageCustomizationOverrideList contains a list of
+// ImageCustomizationOverride
+type ImageCustomizationOverrideList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ImageCustomizationOverride `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ImageCustomizationOverride{}, &ImageCustomizationOverrideList{})
+}
@@ -17,28 +17,43 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	metal3iov1alpha1 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
 	metal3iocontroller "github.com/metal3-io/baremetal-operator/controllers/metal3.io"
 	"github.com/metal3-io/baremetal-operator/pkg/secretutils"
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/config"
+	"github.com/openshift/image-customization-controller/pkg/debug"
+	"github.com/openshift/image-customization-controller/pkg/endpoints"
 	"github.com/openshift/image-customization-controller/pkg/env"
+	"github.com/openshift/image-customization-controller/pkg/hardware"
 	"github.com/openshift/image-customization-controller/pkg/imagehandler"
 	"github.com/openshift/image-customization-controller/pkg/imageprovider"
+	"github.com/openshift/image-customization-controller/pkg/logsink"
+	"github.com/openshift/image-customization-controller/pkg/progress"
+	"github.com/openshift/image-customization-controller/pkg/sshkeys"
 	"github.com/openshift/image-customization-controller/pkg/version"
 	// +kubebuilder:scaffold:imports
 )
@@ -49,16 +64,64 @@ var (
 )
 
 const (
-	infraEnvLabel string = "infraenvs.agent-install.openshift.io"
+	// defaultImageSelector excludes images belonging to InfraEnvs, which are
+	// built by the assisted installer's image provider.
+	defaultImageSelector string = "!infraenvs.agent-install.openshift.io"
 )
 
 func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
 	_ = metal3iov1alpha1.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
+// controllerOptions holds the command-line options for the controller.
+type controllerOptions struct {
+	watchNamespace          string
+	imageSelector           string
+	leaderElection          bool
+	leaderElectionNamespace string
+	leaderElectionID        string
+	configName              string
+	namespaceOverrides      bool
+	checkInterfaces         bool
+	matchInterfacesByMAC    bool
+	statelessImages         bool
+	reportBootProgress      bool
+	logSinkDir              string
+	logSinkMaxSize          int64
+	logSinkRetention        time.Duration
+	sshHostKeys             bool
+}
+
+// namespaces returns the list of namespaces to watch, or nil to watch all
+// namespaces.
+func (opts controllerOptions) namespaces() []string {
+	var namespaces []string
+	for _, ns := range strings.Split(opts.watchNamespace, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// newCache returns a function to create the manager's cache, restricted to
+// the given namespaces (if any).
+func newCache(namespaces []string, cacheOptions cache.Options) cache.NewCacheFunc {
+	if len(namespaces) <= 1 {
+		return cache.BuilderWithOptions(cacheOptions)
+	}
+
+	multiNamespaceCache := cache.MultiNamespacedCacheBuilder(namespaces)
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		opts.SelectorsByObject = cacheOptions.SelectorsByObject
+		return multiNamespaceCache(config, opts)
+	}
+}
+
 func setupChecks(mgr ctrl.Manager) error {
 	if err := mgr.AddReadyzCheck("ping", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to create ready check")
@@ -72,44 +135,231 @@ func setupChecks(mgr ctrl.Manager) error {
 	return nil
 }
 
-func runController(watchNamespace string, imageServer imagehandler.ImageHandler, envInputs *env.EnvInputs) error {
-	excludeInfraEnv, err := labels.NewRequirement(infraEnvLabel, selection.DoesNotExist, nil)
+// setupImageReconciler registers the PreprovisioningImage reconciler with the
+// manager. Images are also reconciled whenever the configuration resources
+// that apply to them change.
+func setupImageReconciler(mgr ctrl.Manager, r *metal3iocontroller.PreprovisioningImageReconciler, opts controllerOptions, services []*endpoints.ServiceRef, pullSecret *types.NamespacedName) error {
+	if opts.configName == "" && !opts.namespaceOverrides && !opts.matchInterfacesByMAC && len(services) == 0 && pullSecret == nil {
+		return r.SetupWithManager(mgr)
+	}
+
+	builder := ctrl.NewControllerManagedBy(mgr).
+		For(&metal3iov1alpha1.PreprovisioningImage{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}},
+			&handler.EnqueueRequestForOwner{OwnerType: &metal3iov1alpha1.PreprovisioningImage{}})
+	if pullSecret != nil || opts.namespaceOverrides {
+		builder = builder.Watches(&source.Kind{Type: &corev1.Secret{}},
+			config.EnqueueSecretImages(mgr.GetClient(), pullSecret, opts.namespaceOverrides, r.Log))
+	}
+	if opts.configName != "" {
+		builder = builder.Watches(&source.Kind{Type: &v1alpha1.ImageCustomizationConfig{}},
+			config.EnqueueAllImages(mgr.GetClient(), opts.configName, r.Log))
+	}
+	if opts.namespaceOverrides {
+		builder = builder.Watches(&source.Kind{Type: &v1alpha1.ImageCustomizationOverride{}},
+			config.EnqueueNamespaceImages(mgr.GetClient(), r.Log))
+	}
+	if opts.matchInterfacesByMAC {
+		builder = builder.Watches(&source.Kind{Type: &metal3iov1alpha1.BareMetalHost{}},
+			hardware.EnqueueHostImages(mgr.GetClient(), r.Log))
+	}
+	if len(services) > 0 {
+		builder = builder.Watches(&source.Kind{Type: &discoveryv1.EndpointSlice{}},
+			endpoints.EnqueueAllImages(mgr.GetClient(), r.Log, services...))
+	}
+	return builder.Complete(r)
+}
+
+func runController(opts controllerOptions, imageServer imagehandler.ImageHandler, signer *imagehandler.URLSigner, progressCallbacks *progress.Callbacks, publishURL *url.URL, envInputs *env.EnvInputs) error {
+	imageSelector, err := labels.Parse(opts.imageSelector)
 	if err != nil {
-		setupLog.Error(err, "cannot create an infraenv label filter")
+		setupLog.Error(err, "cannot parse the image label selector")
 		return err
 	}
 
 	cacheOptions := cache.Options{
 		SelectorsByObject: secretutils.AddSecretSelector(cache.SelectorsByObject{
 			&metal3iov1alpha1.PreprovisioningImage{}: cache.ObjectSelector{
-				Label: labels.NewSelector().Add(*excludeInfraEnv),
+				Label: imageSelector,
 			},
 		}),
 	}
 
+	namespaces := opts.namespaces()
+	var namespace string
+	if len(namespaces) == 1 {
+		namespace = namespaces[0]
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:    scheme,
 		Port:      0, // Add flag with default of 9443 when adding webhooks
-		Namespace: watchNamespace,
-		NewCache:  cache.BuilderWithOptions(cacheOptions),
+		Namespace: namespace,
+		NewCache:  newCache(namespaces, cacheOptions),
+
+		LeaderElection:                opts.leaderElection,
+		LeaderElectionNamespace:       opts.leaderElectionNamespace,
+		LeaderElectionID:              opts.leaderElectionID,
+		LeaderElectionReleaseOnCancel: true,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		return err
 	}
 
+	pullSecret, err := envInputs.PullSecretRef(namespace)
+	if err != nil {
+		setupLog.Error(err, "invalid IRONIC_AGENT_PULL_SECRET_NAME")
+		return err
+	}
+	secretManager := secretutils.NewSecretManager(ctrl.Log, mgr.GetClient(), mgr.GetAPIReader())
+	configLoader := config.NewLoader(mgr.GetClient(), &secretManager,
+		opts.configName, opts.namespaceOverrides, pullSecret, envInputs)
+
+	if opts.configName != "" {
+		configReconciler := config.ConfigReconciler{
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("controllers").WithName("ImageCustomizationConfig"),
+			Name:     opts.configName,
+			Defaults: envInputs,
+		}
+		if err = (&configReconciler).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ImageCustomizationConfig")
+			return err
+		}
+	}
+
+	if opts.checkInterfaces || opts.matchInterfacesByMAC {
+		if err = hardware.IndexHostImages(context.Background(), mgr.GetFieldIndexer()); err != nil {
+			setupLog.Error(err, "unable to index PreprovisioningImages by host")
+			return err
+		}
+	}
+	inventory := hardware.NewInventory(mgr.GetClient())
+	if opts.checkInterfaces {
+		interfaceReconciler := hardware.InterfaceReconciler{
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
+			Log:       ctrl.Log.WithName("controllers").WithName("InterfaceCheck"),
+			Inventory: inventory,
+		}
+		if err = (&interfaceReconciler).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "InterfaceCheck")
+			return err
+		}
+	}
+	if !opts.matchInterfacesByMAC {
+		inventory = nil
+	}
+
+	ironicService, err := endpoints.ParseServiceRef(envInputs.IronicService)
+	if err != nil {
+		setupLog.Error(err, "invalid IRONIC_SERVICE")
+		return err
+	}
+	inspectorService, err := endpoints.ParseServiceRef(envInputs.IronicInspectorService)
+	if err != nil {
+		setupLog.Error(err, "invalid IRONIC_INSPECTOR_SERVICE")
+		return err
+	}
+	var resolver endpoints.Resolver
+	var services []*endpoints.ServiceRef
+	if ironicService != nil || inspectorService != nil {
+		resolver = endpoints.NewResolver(mgr.GetClient(), ironicService, inspectorService,
+			envInputs.IronicServiceScheme)
+		services = []*endpoints.ServiceRef{ironicService, inspectorService}
+	}
+
+	eventRecorder := mgr.GetEventRecorderFor("image-customization-controller")
+	downloads := imageprovider.NewDownloadReporter(mgr.GetClient(), eventRecorder,
+		ctrl.Log.WithName("Downloads"))
+	if err = mgr.Add(downloads); err != nil {
+		setupLog.Error(err, "unable to add download reporter")
+		return err
+	}
+
+	var logSink *logsink.Sink
+	if opts.logSinkDir != "" {
+		logSink = logsink.NewSink(opts.logSinkDir, []byte(envInputs.LogSinkKey), publishURL,
+			opts.logSinkMaxSize, opts.logSinkRetention, mgr.GetClient(), ctrl.Log.WithName("LogSink"))
+		if err = mgr.Add(logSink); err != nil {
+			setupLog.Error(err, "unable to add log sink")
+			return err
+		}
+		http.Handle(logsink.PathPrefix, logSink)
+	}
+
+	var hostKeys sshkeys.HostKeys
+	if opts.sshHostKeys {
+		hostKeys = sshkeys.NewHostKeys(mgr.GetClient(), mgr.GetAPIReader())
+	}
+
+	imageProvider, err := imageprovider.NewRHCOSImageProvider(imageServer, envInputs, imageprovider.RHCOSOptions{
+		Config:         configLoader,
+		Inventory:      inventory,
+		Endpoints:      resolver,
+		Downloads:      downloads,
+		Progress:       progressCallbacks,
+		Logs:           logSink,
+		DebugPasswords: debug.NewPasswords(mgr.GetClient(), mgr.GetAPIReader()),
+		HostKeys:       hostKeys,
+		EventRecorder:  eventRecorder,
+		Elected:        mgr.Elected(),
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to configure image provider")
+		return err
+	}
+	imageFS := imageServer.FileSystem()
+	if signer != nil {
+		imageProvider, imageFS = imageprovider.NewStatelessImageProvider(imageProvider,
+			mgr.GetClient(), signer, ctrl.Log.WithName("StatelessImages"))
+	}
+	http.Handle("/", imagehandler.TrackDownloads(http.FileServer(imageFS), downloads))
+	if progressCallbacks != nil {
+		http.Handle(progress.PathPrefix, progressCallbacks.Handler(mgr.GetClient(), eventRecorder,
+			ctrl.Log.WithName("BootProgress")))
+	}
+
 	imgReconciler := metal3iocontroller.PreprovisioningImageReconciler{
 		Client:        mgr.GetClient(),
 		Log:           ctrl.Log.WithName("controllers").WithName("PreprovisioningImage"),
 		APIReader:     mgr.GetAPIReader(),
 		Scheme:        mgr.GetScheme(),
-		ImageProvider: imageprovider.NewRHCOSImageProvider(imageServer, envInputs),
+		ImageProvider: imageProvider,
 	}
-	if err = (&imgReconciler).SetupWithManager(mgr); err != nil {
+	if err = setupImageReconciler(mgr, &imgReconciler, opts, services, pullSecret); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PreprovisioningImage")
 		return err
 	}
 
+	if signer != nil {
+		// Images generated on request are discarded by every replica once
+		// they are deleted.
+		statelessReconciler := imageprovider.StatelessReconciler{
+			Client:        mgr.GetClient(),
+			ImageProvider: imageProvider,
+		}
+		if err = (&statelessReconciler).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "PreprovisioningImageStateless")
+			return err
+		}
+	}
+
+	if opts.leaderElection && signer == nil {
+		// Replicas that are not the leader also build images, so that any
+		// replica can serve them.
+		replicaReconciler := imageprovider.ReplicaReconciler{
+			Client:        mgr.GetClient(),
+			Log:           ctrl.Log.WithName("controllers").WithName("PreprovisioningImageReplica"),
+			ImageProvider: imageProvider,
+		}
+		if err = (&replicaReconciler).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "PreprovisioningImageReplica")
+			return err
+		}
+	}
+
 	// +kubebuilder:scaffold:builder
 
 	if err := setupChecks(mgr); err != nil {
@@ -121,7 +371,7 @@ func runController(watchNamespace string, imageServer imagehandler.ImageHandler,
 }
 
 func main() {
-	var watchNamespace string
+	var opts controllerOptions
 	var devLogging bool
 	var imagesBindAddr string
 	var imagesPublishAddr string
@@ -130,12 +380,40 @@ func main() {
 	// in case of a deployment that is not multi-tenant. If the deployment
 	// is for multi-tenancy, then the BMO should watch only the provided
 	// namespace.
-	flag.StringVar(&watchNamespace, "namespace", os.Getenv("WATCH_NAMESPACE"),
-		"Namespace that the controller watches to reconcile preprovisioningimage resources.")
+	flag.StringVar(&opts.watchNamespace, "namespace", os.Getenv("WATCH_NAMESPACE"),
+		"Comma-separated list of namespaces that the controller watches to reconcile preprovisioningimage resources.")
+	flag.StringVar(&opts.imageSelector, "image-selector", defaultImageSelector,
+		"Label selector for the preprovisioningimage resources that the controller reconciles.")
+	flag.BoolVar(&opts.leaderElection, "leader-elect", false,
+		"Enable leader election, so that multiple replicas of the controller can run. Every replica serves images.")
+	flag.StringVar(&opts.leaderElectionNamespace, "leader-election-namespace", "",
+		"Namespace in which to create the leader election lease. (Defaults to the namespace the controller runs in.)")
+	flag.StringVar(&opts.leaderElectionID, "leader-election-id", "image-customization-controller.openshift.io",
+		"Name of the leader election lease.")
 	flag.StringVar(&imagesBindAddr, "images-bind-addr", ":8084",
 		"The address the images endpoint binds to.")
 	flag.StringVar(&imagesPublishAddr, "images-publish-addr", "http://127.0.0.1:8084",
 		"The address clients would access the images endpoint from.")
+	flag.StringVar(&opts.configName, "config-name", "",
+		"Name of the ImageCustomizationConfig resource that overrides the environment. If not set, only the environment is used.")
+	flag.BoolVar(&opts.namespaceOverrides, "namespace-overrides", false,
+		"Apply the ImageCustomizationOverride resource in each namespace to the images in that namespace.")
+	flag.BoolVar(&opts.checkInterfaces, "check-interfaces", false,
+		"Report whether the interfaces in each image's network data match the NICs of its BareMetalHost.")
+	flag.BoolVar(&opts.matchInterfacesByMAC, "match-interfaces-by-mac", false,
+		"Match ethernet interfaces in the network data to the NICs of the BareMetalHost by MAC address once the host has been inspected.")
+	flag.BoolVar(&opts.statelessImages, "stateless-images", false,
+		"Publish images at signed URLs from which any replica can regenerate the image on demand. Requires IMAGE_URL_SIGNING_KEY.")
+	flag.BoolVar(&opts.reportBootProgress, "report-boot-progress", false,
+		"Configure the ramdisk to report its boot progress to the image server. Requires BOOT_PROGRESS_KEY.")
+	flag.StringVar(&opts.logSinkDir, "log-sink-dir", "",
+		"Directory in which to store the journals uploaded by each ramdisk. If not set, logs are not collected. Requires LOG_SINK_KEY. Cannot be used with -leader-elect.")
+	flag.Int64Var(&opts.logSinkMaxSize, "log-sink-max-size", 50*1024*1024,
+		"Maximum size in bytes of the stored journal for each host, after which it is rotated.")
+	flag.DurationVar(&opts.logSinkRetention, "log-sink-retention", 7*24*time.Hour,
+		"How long to keep the stored journal of a host after it was last written to.")
+	flag.BoolVar(&opts.sshHostKeys, "ssh-host-keys", false,
+		"Generate SSH host keys for each image and store them in a Secret, so that they can be verified when connecting to the ramdisk.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(devLogging)))
@@ -155,7 +433,32 @@ func main() {
 	}
 
 	imageServer := imagehandler.NewImageHandler(ctrl.Log.WithName("ImageHandler"), envInputs.DeployISO, envInputs.DeployInitrd, publishURL)
-	http.Handle("/", http.FileServer(imageServer.FileSystem()))
+	var signer *imagehandler.URLSigner
+	if opts.statelessImages {
+		if envInputs.ImageURLSigningKey == "" {
+			setupLog.Info("IMAGE_URL_SIGNING_KEY is required for stateless images")
+			os.Exit(1)
+		}
+		signer = imagehandler.NewURLSigner([]byte(envInputs.ImageURLSigningKey), publishURL)
+	}
+	var progressCallbacks *progress.Callbacks
+	if opts.reportBootProgress {
+		if envInputs.BootProgressKey == "" {
+			setupLog.Info("BOOT_PROGRESS_KEY is required for boot progress reporting")
+			os.Exit(1)
+		}
+		progressCallbacks = progress.NewCallbacks([]byte(envInputs.BootProgressKey), publishURL)
+	}
+	if opts.logSinkDir != "" && envInputs.LogSinkKey == "" {
+		setupLog.Info("LOG_SINK_KEY is required for log collection")
+		os.Exit(1)
+	}
+	if opts.logSinkDir != "" && opts.leaderElection {
+		// Logs are stored on the local disk of the replica that receives
+		// them, so they would be split between replicas.
+		setupLog.Info("log collection requires a single replica and cannot be used with -leader-elect")
+		os.Exit(1)
+	}
 
 	go func() {
 		server := &http.Server{
@@ -171,7 +474,7 @@ func main() {
 		}
 	}()
 
-	if err := runController(watchNamespace, imageServer, envInputs); err != nil {
+	if err := runController(opts, imageServer, signer, progressCallbacks, publishURL, envInputs); err != nil {
 		setupLog.Error(err, "problem running controller")
 		os.Exit(1)
 	}
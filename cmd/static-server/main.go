@@ -15,6 +15,8 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"io/fs"
 	"net/http"
@@ -29,6 +31,8 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/config"
 	"github.com/openshift/image-customization-controller/pkg/env"
 	"github.com/openshift/image-customization-controller/pkg/ignition"
 	"github.com/openshift/image-customization-controller/pkg/imagehandler"
@@ -40,16 +44,36 @@ var (
 	log = ctrl.Log.WithName("static-server")
 )
 
+const (
+	pullSecretPath = "run/secrets/pull-secret"
+
+	// pullSecretCheckInterval is how often the mounted pull secret is checked
+	// for changes.
+	pullSecretCheckInterval = time.Minute
+)
+
 func loadStaticNMState(fsys fs.FS, env *env.EnvInputs, nmstateDir string, imageServer imagehandler.ImageHandler) error {
 	registries, err := env.RegistriesConf()
 	if err != nil {
 		return err
 	}
+	registriesDropins, err := env.RegistriesDropins()
+	if err != nil {
+		return err
+	}
+	registryCerts, err := env.RegistryCertificates()
+	if err != nil {
+		return err
+	}
+	containerTrust, err := env.ContainerTrust()
+	if err != nil {
+		return err
+	}
 
 	// If not defined via env var, look for the mounted secret file
 	pullSecret := env.IronicAgentPullSecret
 	if env.IronicAgentPullSecret == "" {
-		pullSecretRaw, err := fs.ReadFile(fsys, "run/secrets/pull-secret")
+		pullSecretRaw, err := fs.ReadFile(fsys, pullSecretPath)
 		if err != nil {
 			return errors.Wrap(err, "unable to read secret")
 		}
@@ -86,19 +110,67 @@ func loadStaticNMState(fsys fs.FS, env *env.EnvInputs, nmstateDir string, imageS
 		if err != nil {
 			return errors.WithMessage(err, "failed to configure ignition")
 		}
-		if err, _ := igBuilder.ProcessNetworkState(); err != nil {
-			return errors.WithMessage(err, "failed to convert nmstate data")
+		igBuilder.InspectionMode(v1alpha1.InspectionMode(env.InspectionMode))
+		if err := igBuilder.RegistriesDropins(registriesDropins); err != nil {
+			return errors.WithMessage(err, "failed to configure ignition")
+		}
+		if err := igBuilder.RegistryCertificates(registryCerts); err != nil {
+			return errors.WithMessage(err, "failed to configure ignition")
+		}
+		if len(containerTrust) > 0 {
+			if err := igBuilder.ContainerTrust(containerTrust); err != nil {
+				return errors.WithMessage(err, "failed to configure ignition")
+			}
+		}
+		ntpServers, err := config.ParseNTPServers(env.NTPServers)
+		if err != nil {
+			return errors.WithMessage(err, "failed to configure ignition")
+		}
+		igBuilder.NTPServers(ntpServers)
+		igBuilder.NetworkFallback(env.NetworkFallbackTimeout)
+		hostnameSources, err := config.ParseHostnameSources(env.HostnameSources)
+		if err != nil {
+			return errors.WithMessage(err, "failed to configure ignition")
+		}
+		if hostnameSources != nil {
+			hostnames := map[v1alpha1.HostnameSource]string{v1alpha1.HostnameSourcePreprovisioningImage: hostname}
+			if config.UsesHostnameTemplate(hostnameSources) {
+				hostnames[v1alpha1.HostnameSourceTemplate], err = config.RenderHostname(env.HostnameTemplate,
+					config.HostnameTemplateData{Name: hostname})
+				if err != nil {
+					return errors.WithMessagef(err, "failed to configure hostname for %s", f.Name())
+				}
+			}
+			igBuilder.HostnameSources(hostnameSources, hostnames)
+		}
+		ctx, cancel := env.NMStateContext(context.Background())
+		err = igBuilder.ProcessNetworkState(ctx)
+		cancel()
+		if err != nil {
+			nmstateErr := &ignition.NMStateError{}
+			if errors.As(err, &nmstateErr) && nmstateErr.Detail != "" {
+				log.Info("nmstatectl output", "file", f.Name(), "output", nmstateErr.Detail)
+			}
+			return errors.WithMessagef(err, "failed to convert nmstate data %s", f.Name())
 		}
 		ign, err := igBuilder.Generate()
 		if err != nil {
 			return errors.WithMessagef(err, "problem generating ignition %s", f.Name())
 		}
+		keyfiles, err := ignition.NetworkKeyfiles(ign)
+		if err != nil {
+			return errors.WithMessagef(err, "problem generating ignition %s", f.Name())
+		}
+		network := imagehandler.FirstbootNetwork{
+			Keyfiles:   keyfiles,
+			KernelArgs: ignition.FirstbootKernelArgs(keyfiles, env.IpOptions),
+		}
 
 		for _, suffix := range []string{".iso", ".initramfs"} {
 			imageName := strings.TrimSuffix(f.Name(), ".yaml") + suffix
 
 			isInitramfs := !strings.HasSuffix(imageName, ".iso")
-			url, err := imageServer.ServeImage(imageName, ign, isInitramfs, true)
+			url, err := imageServer.ServeImage(imageName, ign, network, isInitramfs, true)
 			if err != nil {
 				return err
 			}
@@ -108,6 +180,30 @@ func loadStaticNMState(fsys fs.FS, env *env.EnvInputs, nmstateDir string, imageS
 	return nil
 }
 
+// reloadOnPullSecretChange periodically checks the mounted pull secret and
+// regenerates the images when it changes, so that rotated credentials are
+// picked up without restarting.
+func reloadOnPullSecretChange(fsys fs.FS, env *env.EnvInputs, nmstateDir string, imageServer imagehandler.ImageHandler, interval time.Duration) {
+	if env.IronicAgentPullSecret != "" {
+		return
+	}
+
+	current, _ := fs.ReadFile(fsys, pullSecretPath)
+	for range time.Tick(interval) {
+		latest, err := fs.ReadFile(fsys, pullSecretPath)
+		if err != nil || bytes.Equal(latest, current) {
+			continue
+		}
+
+		log.Info("pull secret changed; reloading images")
+		if err := loadStaticNMState(fsys, env, nmstateDir, imageServer); err != nil {
+			log.Error(err, "problem reloading static ignitions")
+			continue
+		}
+		current = latest
+	}
+}
+
 func main() {
 	var devLogging bool
 	var imagesBindAddr string
@@ -150,6 +246,7 @@ func main() {
 		log.Error(err, "problem loading static ignitions")
 		os.Exit(1)
 	}
+	go reloadOnPullSecretChange(os.DirFS("/"), env, nmstateDir, imageServer, pullSecretCheckInterval)
 
 	server := http.Server{
 		Addr:              imagesBindAddr,
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+)
+
+var (
+	envNameRegexp  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	unitNameRegexp = regexp.MustCompile(`^[A-Za-z0-9:_.\\@-]+\.(service|socket|target|device|mount|automount|swap|path|timer|slice|scope)$`)
+
+	restartPolicies = map[string]bool{
+		"": true, "no": true, "always": true, "on-success": true, "on-failure": true,
+		"on-abnormal": true, "on-abort": true, "on-watchdog": true,
+	}
+)
+
+// validateAgentRuntime checks that the agent runtime options can be rendered
+// safely into the agent's systemd unit.
+func validateAgentRuntime(runtime *v1alpha1.AgentRuntimeConfig) error {
+	if runtime == nil {
+		return nil
+	}
+
+	for _, mount := range runtime.Mounts {
+		if err := validateMountPath(mount.HostPath); err != nil {
+			return err
+		}
+		if mount.MountPath != "" {
+			if err := validateMountPath(mount.MountPath); err != nil {
+				return err
+			}
+		}
+	}
+	for _, device := range runtime.Devices {
+		if !strings.HasPrefix(device, "/dev/") || strings.ContainsAny(device, " \t") || hasControl(device) {
+			return fmt.Errorf("agent device %q must be a path in /dev", device)
+		}
+	}
+	for _, env := range runtime.Env {
+		if !envNameRegexp.MatchString(env.Name) {
+			return fmt.Errorf("agent environment variable name %q is invalid", env.Name)
+		}
+		if hasControl(env.Value) {
+			return fmt.Errorf("agent environment variable %s contains control characters", env.Name)
+		}
+	}
+	for _, flag := range runtime.PodmanFlags {
+		if !strings.HasPrefix(flag, "-") || hasControl(flag) {
+			return fmt.Errorf("podman flag %q must start with -", flag)
+		}
+	}
+	switch runtime.PullPolicy {
+	case "", v1alpha1.PullAlways, v1alpha1.PullIfNotPresent, v1alpha1.PullNever:
+	default:
+		return fmt.Errorf("agent pull policy %q is invalid", runtime.PullPolicy)
+	}
+	if !restartPolicies[runtime.Restart] {
+		return fmt.Errorf("agent restart policy %q is invalid", runtime.Restart)
+	}
+	for _, unit := range append(append([]string{}, runtime.After...), runtime.Requires...) {
+		if !unitNameRegexp.MatchString(unit) {
+			return fmt.Errorf("%q is not a valid systemd unit name", unit)
+		}
+	}
+	return nil
+}
+
+// validateMountPath checks that a path can be used in a podman --mount
+// option, in which commas separate the fields.
+func validateMountPath(p string) error {
+	if !strings.HasPrefix(p, "/") || strings.Contains(p, ",") || hasControl(p) {
+		return fmt.Errorf("agent mount path %q must be absolute and must not contain commas", p)
+	}
+	return nil
+}
+
+func hasControl(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) >= 0
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+)
+
+func TestValidateAgentRuntime(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		runtime v1alpha1.AgentRuntimeConfig
+		valid   bool
+	}{
+		{
+			name: "valid",
+			runtime: v1alpha1.AgentRuntimeConfig{
+				Mounts:      []v1alpha1.AgentMount{{HostPath: "/opt/vendor", MountPath: "/vendor"}},
+				Devices:     []string{"/dev/ipmi0:/dev/ipmi0:rw"},
+				Env:         []v1alpha1.AgentEnvVar{{Name: "VENDOR_OPTS", Value: "--all"}},
+				PodmanFlags: []string{"--cap-add=SYS_RAWIO"},
+				PullPolicy:  v1alpha1.PullIfNotPresent,
+				Restart:     "always",
+				After:       []string{"vendor-setup.service", "dev-sda.device"},
+				Requires:    []string{"vendor-setup@foo.service"},
+			},
+			valid: true,
+		},
+		{
+			name:    "relative mount",
+			runtime: v1alpha1.AgentRuntimeConfig{Mounts: []v1alpha1.AgentMount{{HostPath: "opt"}}},
+		},
+		{
+			name:    "mount with comma",
+			runtime: v1alpha1.AgentRuntimeConfig{Mounts: []v1alpha1.AgentMount{{HostPath: "/opt", MountPath: "/opt,ro=false"}}},
+		},
+		{
+			name:    "device outside /dev",
+			runtime: v1alpha1.AgentRuntimeConfig{Devices: []string{"/etc/passwd"}},
+		},
+		{
+			name:    "invalid env name",
+			runtime: v1alpha1.AgentRuntimeConfig{Env: []v1alpha1.AgentEnvVar{{Name: "A B"}}},
+		},
+		{
+			name:    "env value with newline",
+			runtime: v1alpha1.AgentRuntimeConfig{Env: []v1alpha1.AgentEnvVar{{Name: "A", Value: "x\nExecStart=/bin/sh"}}},
+		},
+		{
+			name:    "flag without dash",
+			runtime: v1alpha1.AgentRuntimeConfig{PodmanFlags: []string{"privileged"}},
+		},
+		{
+			name:    "invalid pull policy",
+			runtime: v1alpha1.AgentRuntimeConfig{PullPolicy: "Sometimes"},
+		},
+		{
+			name:    "invalid restart policy",
+			runtime: v1alpha1.AgentRuntimeConfig{Restart: "sometimes"},
+		},
+		{
+			name:    "invalid unit",
+			runtime: v1alpha1.AgentRuntimeConfig{After: []string{"network-online"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateAgentRuntime(&tc.runtime)
+			if tc.valid && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/env"
+)
+
+const (
+	reasonConfigValid   = "ConfigValid"
+	reasonConfigInvalid = "ConfigInvalid"
+)
+
+// ConfigReconciler reports the validity of the ImageCustomizationConfig in
+// its status.
+type ConfigReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Name     string
+	Defaults *env.EnvInputs
+}
+
+// +kubebuilder:rbac:groups=imagecustomization.openshift.io,resources=imagecustomizationconfigs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=imagecustomization.openshift.io,resources=imagecustomizationconfigs/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=imagecustomization.openshift.io,resources=imagecustomizationoverrides,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;update
+
+func (r *ConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("imagecustomizationconfig", req.Name)
+
+	config := &v1alpha1.ImageCustomizationConfig{}
+	if err := r.Get(ctx, req.NamespacedName, config); err != nil {
+		if k8serrors.IsNotFound(err) {
+			log.Info("ImageCustomizationConfig not found; using defaults")
+			err = nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	condition := metav1.Condition{
+		Type:               string(v1alpha1.ConditionConfigValid),
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: config.Generation,
+		Reason:             reasonConfigValid,
+	}
+	defaults, err := NewConfig(r.Defaults)
+	if err == nil {
+		err = Validate(Merge(defaults, &config.Spec))
+	}
+	if err != nil {
+		log.Info("configuration is invalid", "error", err.Error())
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonConfigInvalid
+		condition.Message = err.Error()
+	}
+
+	newStatus := config.Status.DeepCopy()
+	newStatus.ObservedGeneration = config.Generation
+	meta.SetStatusCondition(&newStatus.Conditions, condition)
+	if apiequality.Semantic.DeepEqual(&config.Status, newStatus) {
+		return ctrl.Result{}, nil
+	}
+
+	log.Info("updating status")
+	config.Status = *newStatus
+	return ctrl.Result{}, r.Status().Update(ctx, config)
+}
+
+func (r *ConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.ImageCustomizationConfig{}).
+		WithEventFilter(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetName() == r.Name
+		})).
+		Complete(r)
+}
+
+// EnqueueAllImages returns a handler that requests reconciliation of every
+// PreprovisioningImage when the ImageCustomizationConfig with the given name
+// changes, so that all images are rebuilt with the new configuration.
+func EnqueueAllImages(reader client.Reader, name string, log logr.Logger) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		if obj.GetName() != name {
+			return nil
+		}
+		return ImageRequests(reader, log)
+	})
+}
+
+// EnqueueNamespaceImages returns a handler that requests reconciliation of
+// every PreprovisioningImage in a namespace when the
+// ImageCustomizationOverride in that namespace changes.
+func EnqueueNamespaceImages(reader client.Reader, log logr.Logger) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		if obj.GetName() != v1alpha1.OverrideName {
+			return nil
+		}
+		return ImageRequests(reader, log, client.InNamespace(obj.GetNamespace()))
+	})
+}
+
+// EnqueueSecretImages returns a handler that requests reconciliation of the
+// PreprovisioningImages that use a Secret when it changes, so that the images
+// are rebuilt with the new credentials. A change to the global pull secret
+// affects all images; a change to the pull secret or SSH authorized keys
+// Secret referenced by the ImageCustomizationOverride in a namespace (if
+// overrides is true) affects the images in that namespace.
+func EnqueueSecretImages(reader client.Reader, pullSecret *types.NamespacedName, overrides bool, log logr.Logger) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		if pullSecret != nil && client.ObjectKeyFromObject(obj) == *pullSecret {
+			return ImageRequests(reader, log)
+		}
+		if !overrides {
+			return nil
+		}
+
+		override := &v1alpha1.ImageCustomizationOverride{}
+		key := client.ObjectKey{Namespace: obj.GetNamespace(), Name: v1alpha1.OverrideName}
+		if err := reader.Get(context.Background(), key, override); err != nil {
+			return nil
+		}
+		for _, ref := range []*corev1.LocalObjectReference{
+			override.Spec.IronicAgentPullSecretRef,
+			override.Spec.SSHAuthorizedKeysSecretRef,
+		} {
+			if ref != nil && ref.Name == obj.GetName() {
+				return ImageRequests(reader, log, client.InNamespace(obj.GetNamespace()))
+			}
+		}
+		return nil
+	})
+}
+
+// ImageRequests returns reconcile requests for all of the
+// PreprovisioningImages matching the list options.
+func ImageRequests(reader client.Reader, log logr.Logger, opts ...client.ListOption) []reconcile.Request {
+	images := &metal3.PreprovisioningImageList{}
+	if err := reader.List(context.Background(), images, opts...); err != nil {
+		log.Error(err, "unable to list PreprovisioningImages")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(images.Items))
+	for _, img := range images.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Namespace: img.Namespace,
+				Name:      img.Name,
+			},
+		})
+	}
+	return requests
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+)
+
+var hostnameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+var hostnameSources = map[v1alpha1.HostnameSource]bool{
+	v1alpha1.HostnameSourceDHCPv4:               true,
+	v1alpha1.HostnameSourceDHCPv6:               true,
+	v1alpha1.HostnameSourceReverseDNS:           true,
+	v1alpha1.HostnameSourceBareMetalHost:        true,
+	v1alpha1.HostnameSourcePreprovisioningImage: true,
+	v1alpha1.HostnameSourceTemplate:             true,
+}
+
+// HostnameTemplateData holds the fields available to the hostname template.
+type HostnameTemplateData struct {
+	Name          string
+	Namespace     string
+	BareMetalHost string
+}
+
+// ParseHostnameSources returns the hostname sources in a comma-separated
+// list, or nil if the list is empty so that the default sources are used.
+func ParseHostnameSources(value string) ([]v1alpha1.HostnameSource, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	sources := []v1alpha1.HostnameSource{}
+	for _, name := range strings.Split(value, ",") {
+		source := v1alpha1.HostnameSource(strings.TrimSpace(name))
+		if !hostnameSources[source] {
+			return nil, fmt.Errorf("unknown hostname source %q", source)
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// UsesHostnameTemplate returns whether the hostname template is one of the
+// given hostname sources.
+func UsesHostnameTemplate(sources []v1alpha1.HostnameSource) bool {
+	for _, source := range sources {
+		if source == v1alpha1.HostnameSourceTemplate {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderHostname returns the hostname produced by a hostname template.
+func RenderHostname(hostnameTemplate string, data HostnameTemplateData) (string, error) {
+	tmpl, err := template.New("hostname").Option("missingkey=error").Parse(hostnameTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid hostname template: %w", err)
+	}
+	hostname := &strings.Builder{}
+	if err := tmpl.Execute(hostname, data); err != nil {
+		return "", fmt.Errorf("invalid hostname template: %w", err)
+	}
+	if len(hostname.String()) > 253 || !hostnameRegexp.MatchString(hostname.String()) {
+		return "", fmt.Errorf("hostname template produced invalid hostname %q", hostname.String())
+	}
+	return hostname.String(), nil
+}
+
+// validateHostname checks that the hostname sources are known, and that the
+// template can be used if it is one of them.
+func validateHostname(config *Config) error {
+	for _, source := range config.HostnameSources {
+		if !hostnameSources[source] {
+			return fmt.Errorf("unknown hostname source %q", source)
+		}
+	}
+	if UsesHostnameTemplate(config.HostnameSources) && config.HostnameTemplate == "" {
+		return fmt.Errorf("the Template hostname source requires a hostname template")
+	}
+	if config.HostnameTemplate != "" {
+		_, err := RenderHostname(config.HostnameTemplate, HostnameTemplateData{
+			Name: "host", Namespace: "namespace", BareMetalHost: "host",
+		})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+)
+
+func TestParseHostnameSources(t *testing.T) {
+	sources, err := ParseHostnameSources("DHCPv4, ReverseDNS,Template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []v1alpha1.HostnameSource{
+		v1alpha1.HostnameSourceDHCPv4, v1alpha1.HostnameSourceReverseDNS, v1alpha1.HostnameSourceTemplate,
+	}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("expected %v, got %v", expected, sources)
+	}
+
+	if sources, err := ParseHostnameSources(""); sources != nil || err != nil {
+		t.Errorf("expected default sources, got %v, %v", sources, err)
+	}
+	if _, err := ParseHostnameSources("DHCPv4,LLDP"); err == nil {
+		t.Error("expected unknown source to be rejected")
+	}
+}
+
+func TestRenderHostname(t *testing.T) {
+	data := HostnameTemplateData{Name: "host-0", Namespace: "site-1", BareMetalHost: "bmh-0"}
+	for _, tc := range []struct {
+		template string
+		expected string
+		valid    bool
+	}{
+		{template: "{{.Name}}.{{.Namespace}}.example.com", expected: "host-0.site-1.example.com", valid: true},
+		{template: "{{.BareMetalHost}}", expected: "bmh-0", valid: true},
+		{template: "{{.Name", valid: false},
+		{template: "{{.Rack}}", valid: false},
+		{template: "{{.Name}}_{{.Namespace}}", valid: false},
+		{template: "{{.Name}}; reboot", valid: false},
+	} {
+		t.Run(tc.template, func(t *testing.T) {
+			hostname, err := RenderHostname(tc.template, data)
+			if tc.valid && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatalf("expected error, got %q", hostname)
+			}
+			if hostname != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, hostname)
+			}
+		})
+	}
+}
+
+func TestValidateHostname(t *testing.T) {
+	sources := []v1alpha1.HostnameSource{v1alpha1.HostnameSourceDHCPv4, v1alpha1.HostnameSourceTemplate}
+	if err := validateHostname(&Config{HostnameSources: sources}); err == nil {
+		t.Error("expected Template source without a template to be rejected")
+	}
+	if err := validateHostname(&Config{HostnameSources: sources, HostnameTemplate: "{{.Name}}.example.com"}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := validateHostname(&Config{HostnameSources: []v1alpha1.HostnameSource{"LLDP"}}); err == nil {
+		t.Error("expected unknown hostname source to be rejected")
+	}
+}
@@ -0,0 +1,443 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/env"
+	"github.com/openshift/image-customization-controller/pkg/pullsecret"
+	"github.com/openshift/image-customization-controller/pkg/registries"
+)
+
+const (
+	// Annotations on a PreprovisioningImage that override the configuration
+	// for that image only.
+	AgentImageAnnotation = "imagecustomization.openshift.io/ironic-agent-image"
+	IPOptionsAnnotation  = "imagecustomization.openshift.io/ip-options"
+
+	sourceEnvironment = "environment"
+	sourceAnnotations = "annotations"
+)
+
+// Config is the configuration in effect for building a particular image.
+type Config struct {
+	Inputs *env.EnvInputs
+	// AgentRuntime holds the options for running the agent container, which
+	// can be set only in the configuration resources.
+	AgentRuntime *v1alpha1.AgentRuntimeConfig
+	// RegistryMirrors are the mirrors from which the agent image is pulled,
+	// which can be set only in the configuration resources.
+	RegistryMirrors []v1alpha1.RegistryMirror
+	// InspectionMode is where the agent sends the results of inspection.
+	InspectionMode v1alpha1.InspectionMode
+	// NTPServers are the NTP servers to synchronize the clock with, unless
+	// the network data of a host lists its own.
+	NTPServers []string
+	// NetworkFallbackTimeout is how long a host with static network
+	// configuration may fail to reach Ironic before falling back to DHCP on
+	// all NICs. Zero disables the fallback.
+	NetworkFallbackTimeout time.Duration
+	// HostnameSources are the sources of the hostname in priority order, or
+	// nil to use the default hostname.
+	HostnameSources []v1alpha1.HostnameSource
+	// HostnameTemplate produces the hostname for the Template source.
+	HostnameTemplate string
+	// Sources lists where the configuration came from, in increasing order
+	// of precedence.
+	Sources []string
+}
+
+// NewConfig returns the configuration from the environment alone. An error
+// is returned if any of the inputs cannot be parsed.
+func NewConfig(inputs *env.EnvInputs) (*Config, error) {
+	ntpServers, err := ParseNTPServers(inputs.NTPServers)
+	if err != nil {
+		return nil, err
+	}
+	hostnameSources, err := ParseHostnameSources(inputs.HostnameSources)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		Inputs:                 inputs,
+		InspectionMode:         v1alpha1.InspectionMode(inputs.InspectionMode),
+		NTPServers:             ntpServers,
+		NetworkFallbackTimeout: inputs.NetworkFallbackTimeout,
+		HostnameSources:        hostnameSources,
+		HostnameTemplate:       inputs.HostnameTemplate,
+		Sources:                []string{sourceEnvironment},
+	}, nil
+}
+
+// SecretReader fetches Secrets, ensuring that subsequent changes to them are
+// watched.
+type SecretReader interface {
+	ObtainSecret(key types.NamespacedName) (*corev1.Secret, error)
+}
+
+// Loader returns the configuration in effect for building an image.
+type Loader interface {
+	Load(ctx context.Context, image *metav1.ObjectMeta) (*Config, error)
+}
+
+type loader struct {
+	reader     client.Reader
+	secrets    SecretReader
+	name       string
+	overrides  bool
+	pullSecret *types.NamespacedName
+	defaults   *env.EnvInputs
+}
+
+// NewStaticLoader returns a Loader that uses the given inputs, overridden
+// only by the annotations on each image.
+func NewStaticLoader(inputs *env.EnvInputs) Loader {
+	return &loader{defaults: inputs}
+}
+
+// NewLoader returns a Loader that layers configuration on top of the
+// defaults from the environment, in increasing order of precedence:
+//
+//   - the pull secret in the Secret referenced by pullSecret (if not nil)
+//   - the ImageCustomizationConfig with the given name (if name is not empty)
+//   - the ImageCustomizationOverride in the image's namespace (if overrides
+//     is true)
+//   - the annotations on the image
+//
+// Any of these that do not exist are skipped. Secrets are read using the
+// SecretReader, which ensures that they are added to the cache.
+func NewLoader(reader client.Reader, secrets SecretReader, name string, overrides bool, pullSecret *types.NamespacedName, defaults *env.EnvInputs) Loader {
+	return &loader{
+		reader:     reader,
+		secrets:    secrets,
+		name:       name,
+		overrides:  overrides,
+		pullSecret: pullSecret,
+		defaults:   defaults,
+	}
+}
+
+func (l *loader) Load(ctx context.Context, image *metav1.ObjectMeta) (*Config, error) {
+	config, err := NewConfig(l.defaults)
+	if err != nil {
+		return nil, InvalidConfigError{err: err}
+	}
+
+	if l.pullSecret != nil {
+		authFile, err := l.readPullSecret(*l.pullSecret)
+		if err != nil {
+			return nil, err
+		}
+		inputs := *config.Inputs
+		if inputs.IronicAgentPullSecret, err = authFile.Encode(); err != nil {
+			return nil, err
+		}
+		config.Inputs = &inputs
+		config.Sources = append(config.Sources,
+			fmt.Sprintf("Secret %s", l.pullSecret))
+	}
+
+	if l.name != "" {
+		clusterConfig := &v1alpha1.ImageCustomizationConfig{}
+		err := l.reader.Get(ctx, client.ObjectKey{Name: l.name}, clusterConfig)
+		switch {
+		case err == nil:
+			config = Merge(config, &clusterConfig.Spec)
+			config.Sources = append(config.Sources,
+				fmt.Sprintf("ImageCustomizationConfig %s", l.name))
+		case !k8serrors.IsNotFound(err):
+			return nil, err
+		}
+	}
+
+	if l.overrides && image != nil {
+		override := &v1alpha1.ImageCustomizationOverride{}
+		key := client.ObjectKey{Namespace: image.Namespace, Name: v1alpha1.OverrideName}
+		err := l.reader.Get(ctx, key, override)
+		switch {
+		case err == nil:
+			config, err = l.mergeOverride(ctx, config, override)
+			if err != nil {
+				return nil, err
+			}
+			config.Sources = append(config.Sources,
+				fmt.Sprintf("ImageCustomizationOverride %s", key))
+		case !k8serrors.IsNotFound(err):
+			return nil, err
+		}
+	}
+
+	if image != nil {
+		if inputs := mergeAnnotations(config.Inputs, image.Annotations); inputs != config.Inputs {
+			config.Inputs = inputs
+			config.Sources = append(config.Sources, sourceAnnotations)
+		}
+	}
+
+	if err := Validate(config); err != nil {
+		return nil, InvalidConfigError{err: err}
+	}
+	return config, nil
+}
+
+// InvalidConfigError indicates that the configuration cannot be used to
+// build images.
+type InvalidConfigError struct {
+	err error
+}
+
+func (ice InvalidConfigError) Error() string {
+	return fmt.Sprintf("invalid image customization config: %s", ice.err.Error())
+}
+
+func (ice InvalidConfigError) Unwrap() error {
+	return ice.err
+}
+
+func override(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+// Merge returns a copy of the defaults with any fields set in the spec
+// overriding them.
+func Merge(defaults *Config, spec *v1alpha1.ImageCustomizationConfigSpec) *Config {
+	config := *defaults
+	inputs := *defaults.Inputs
+	config.Inputs = &inputs
+
+	override(&inputs.IronicBaseURL, spec.IronicBaseURL)
+	override(&inputs.IronicInspectorBaseURL, spec.IronicInspectorBaseURL)
+	override(&inputs.IronicAgentImage, spec.IronicAgentImage)
+	override(&inputs.IronicRAMDiskSSHKey, spec.IronicRAMDiskSSHKey)
+	override(&inputs.IpOptions, spec.IPOptions)
+	if len(spec.NTPServers) > 0 {
+		config.NTPServers = spec.NTPServers
+	}
+	if spec.Hostname != nil {
+		if len(spec.Hostname.Sources) > 0 {
+			config.HostnameSources = spec.Hostname.Sources
+		}
+		override(&config.HostnameTemplate, spec.Hostname.Template)
+	}
+	if spec.NetworkFallbackTimeout != nil {
+		config.NetworkFallbackTimeout = spec.NetworkFallbackTimeout.Duration
+	}
+	if len(spec.RegistryMirrors) > 0 {
+		config.RegistryMirrors = spec.RegistryMirrors
+	}
+	if spec.InspectionMode != "" {
+		config.InspectionMode = spec.InspectionMode
+	}
+	override(&inputs.HttpProxy, spec.Proxy.HTTPProxy)
+	override(&inputs.HttpsProxy, spec.Proxy.HTTPSProxy)
+	override(&inputs.NoProxy, spec.Proxy.NoProxy)
+	if spec.AgentRuntime != nil {
+		config.AgentRuntime = spec.AgentRuntime
+	}
+
+	return &config
+}
+
+func (l *loader) mergeOverride(ctx context.Context, defaults *Config, ovr *v1alpha1.ImageCustomizationOverride) (*Config, error) {
+	config := *defaults
+	inputs := *defaults.Inputs
+	config.Inputs = &inputs
+
+	override(&inputs.IronicAgentImage, ovr.Spec.IronicAgentImage)
+	override(&inputs.IronicRAMDiskSSHKey, ovr.Spec.IronicRAMDiskSSHKey)
+	override(&inputs.HttpProxy, ovr.Spec.Proxy.HTTPProxy)
+	override(&inputs.HttpsProxy, ovr.Spec.Proxy.HTTPSProxy)
+	override(&inputs.NoProxy, ovr.Spec.Proxy.NoProxy)
+	if ovr.Spec.AgentRuntime != nil {
+		config.AgentRuntime = ovr.Spec.AgentRuntime
+	}
+
+	if ref := ovr.Spec.IronicAgentPullSecretRef; ref != nil && ref.Name != "" {
+		authFile, err := l.readPullSecret(types.NamespacedName{Namespace: ovr.Namespace, Name: ref.Name})
+		if err != nil {
+			return nil, err
+		}
+		if ovr.Spec.MergePullSecret && inputs.IronicAgentPullSecret != "" {
+			base, err := pullsecret.Parse([]byte(inputs.IronicAgentPullSecret))
+			if err != nil {
+				return nil, InvalidConfigError{err: err}
+			}
+			authFile = pullsecret.Merge(base, authFile)
+		}
+		if inputs.IronicAgentPullSecret, err = authFile.Encode(); err != nil {
+			return nil, err
+		}
+	}
+
+	if ref := ovr.Spec.SSHAuthorizedKeysSecretRef; ref != nil && ref.Name != "" {
+		keys, err := l.readAuthorizedKeys(types.NamespacedName{Namespace: ovr.Namespace, Name: ref.Name})
+		if err != nil {
+			return nil, err
+		}
+		inputs.IronicRAMDiskSSHKey = strings.TrimSpace(inputs.IronicRAMDiskSSHKey + "\n" + keys)
+	}
+
+	return &config, nil
+}
+
+// readAuthorizedKeys returns the public SSH keys in all of the keys of a
+// Secret, in the order of the Secret's keys.
+func (l *loader) readAuthorizedKeys(key types.NamespacedName) (string, error) {
+	secret, err := l.secrets.ObtainSecret(key)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", InvalidConfigError{err: fmt.Errorf("SSH authorized keys Secret %s not found", key)}
+		}
+		return "", err
+	}
+
+	names := make([]string, 0, len(secret.Data))
+	for name := range secret.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, strings.TrimSpace(string(secret.Data[name])))
+	}
+	return strings.Join(keys, "\n"), nil
+}
+
+// readPullSecret returns the credentials in a kubernetes.io/dockerconfigjson
+// or kubernetes.io/dockercfg Secret.
+func (l *loader) readPullSecret(key types.NamespacedName) (*pullsecret.AuthFile, error) {
+	secret, err := l.secrets.ObtainSecret(key)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, InvalidConfigError{err: fmt.Errorf("pull secret %s not found", key)}
+		}
+		return nil, err
+	}
+
+	data, ok := secret.Data[corev1.DockerConfigJsonKey]
+	if !ok {
+		data, ok = secret.Data[corev1.DockerConfigKey]
+	}
+	if !ok {
+		return nil, InvalidConfigError{
+			err: fmt.Errorf("pull secret %s has no %s key", key, corev1.DockerConfigJsonKey),
+		}
+	}
+
+	authFile, err := pullsecret.Parse(data)
+	if err != nil {
+		return nil, InvalidConfigError{err: fmt.Errorf("pull secret %s: %w", key, err)}
+	}
+	return authFile, nil
+}
+
+// mergeAnnotations returns the inputs overridden by any annotations on the
+// image. If there are no relevant annotations, the defaults are returned
+// unchanged.
+func mergeAnnotations(defaults *env.EnvInputs, annotations map[string]string) *env.EnvInputs {
+	agentImage, hasAgentImage := annotations[AgentImageAnnotation]
+	ipOptions, hasIPOptions := annotations[IPOptionsAnnotation]
+	if !hasAgentImage && !hasIPOptions {
+		return defaults
+	}
+
+	inputs := *defaults
+	override(&inputs.IronicAgentImage, agentImage)
+	override(&inputs.IpOptions, ipOptions)
+	return &inputs
+}
+
+// Validate checks that the configuration is sufficient to build images.
+func Validate(config *Config) error {
+	inputs := config.Inputs
+	if inputs.IronicAgentImage == "" {
+		return fmt.Errorf("no Ironic agent image specified")
+	}
+	if err := validateURL("Ironic base URL", inputs.IronicBaseURL); err != nil {
+		return err
+	}
+	if err := validateURL("Ironic inspector base URL", inputs.IronicInspectorBaseURL); err != nil {
+		return err
+	}
+	if err := validateNTPServers(config.NTPServers); err != nil {
+		return err
+	}
+	if err := validateHostname(config); err != nil {
+		return err
+	}
+	if config.NetworkFallbackTimeout < 0 {
+		return fmt.Errorf("network fallback timeout must not be negative")
+	}
+	if err := registries.ValidateMirrors(config.RegistryMirrors); err != nil {
+		return err
+	}
+	switch config.InspectionMode {
+	case "", v1alpha1.InspectionModeInspector, v1alpha1.InspectionModeIronic, v1alpha1.InspectionModeDisabled:
+	default:
+		return fmt.Errorf("unknown inspection mode %q", config.InspectionMode)
+	}
+	return validateAgentRuntime(config.AgentRuntime)
+}
+
+// UsesInspector returns whether the agent reports inspection results to a
+// standalone Ironic Inspector.
+func UsesInspector(config *Config) bool {
+	return config.InspectionMode == "" || config.InspectionMode == v1alpha1.InspectionModeInspector
+}
+
+// NTPServersNetworkDataKey is the key in the network data Secret listing the
+// NTP servers for that host only, in place of the global ones.
+const NTPServersNetworkDataKey = "ntpServers"
+
+var ntpServerRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.:-]*$`)
+
+// ParseNTPServers returns the NTP servers in a list of hostnames or IP
+// addresses separated by commas or whitespace.
+func ParseNTPServers(value string) ([]string, error) {
+	servers := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if err := validateNTPServers(servers); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+func validateNTPServers(servers []string) error {
+	for _, server := range servers {
+		if !ntpServerRegexp.MatchString(server) {
+			return fmt.Errorf("invalid NTP server %q", server)
+		}
+	}
+	return nil
+}
+
+func validateURL(name, value string) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid URL: %w", name, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%s %q must be an http or https URL", name, value)
+	}
+	return nil
+}
@@ -0,0 +1,410 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/env"
+)
+
+type fakeReader struct {
+	config   *v1alpha1.ImageCustomizationConfig
+	override *v1alpha1.ImageCustomizationOverride
+	secrets  []*corev1.Secret
+}
+
+func (f *fakeReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	notFound := k8serrors.NewNotFound(schema.GroupResource{}, key.Name)
+	switch o := obj.(type) {
+	case *v1alpha1.ImageCustomizationConfig:
+		if f.config == nil || key.Name != f.config.Name {
+			return notFound
+		}
+		f.config.DeepCopyInto(o)
+	case *v1alpha1.ImageCustomizationOverride:
+		if f.override == nil || key != client.ObjectKeyFromObject(f.override) {
+			return notFound
+		}
+		f.override.DeepCopyInto(o)
+	case *corev1.Secret:
+		for _, secret := range f.secrets {
+			if key == client.ObjectKeyFromObject(secret) {
+				secret.DeepCopyInto(o)
+				return nil
+			}
+		}
+		return notFound
+	default:
+		return notFound
+	}
+	return nil
+}
+
+func (f *fakeReader) ObtainSecret(key types.NamespacedName) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	return secret, f.Get(context.TODO(), key, secret)
+}
+
+func (f *fakeReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return nil
+}
+
+func testDefaults() *env.EnvInputs {
+	return &env.EnvInputs{
+		IronicBaseURL:    "http://ironic.example.com",
+		IronicAgentImage: "quay.io/openshift-release-dev/ironic-ipa-image",
+		HttpProxy:        "http://proxy.example.com",
+	}
+}
+
+func testImage(annotations map[string]string) *metav1.ObjectMeta {
+	return &metav1.ObjectMeta{
+		Name:        "host-0",
+		Namespace:   "tenant",
+		Annotations: annotations,
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	defaults := testDefaults()
+	loader := NewLoader(&fakeReader{}, &fakeReader{}, "default", true, nil, defaults)
+
+	cfg, err := loader.Load(context.TODO(), testImage(nil))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cfg.Inputs != defaults {
+		t.Errorf("defaults not used when config does not exist")
+	}
+	if !reflect.DeepEqual(cfg.Sources, []string{"environment"}) {
+		t.Errorf("unexpected sources %v", cfg.Sources)
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	defaults := testDefaults()
+	reader := &fakeReader{config: &v1alpha1.ImageCustomizationConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: v1alpha1.ImageCustomizationConfigSpec{
+			IronicBaseURL: "https://ironic.test",
+			IPOptions:     "ip=dhcp6",
+			Proxy: v1alpha1.ProxyConfig{
+				NoProxy: "example.com",
+			},
+			RegistryMirrors: []v1alpha1.RegistryMirror{
+				{Source: "quay.io/metal3-io", Mirrors: []string{"mirror.example.com/metal3-io"}},
+			},
+			InspectionMode:         v1alpha1.InspectionModeIronic,
+			NTPServers:             []string{"ntp1.example.com", "192.0.2.1"},
+			NetworkFallbackTimeout: &metav1.Duration{Duration: 5 * time.Minute},
+			Hostname: &v1alpha1.HostnameConfig{
+				Sources:  []v1alpha1.HostnameSource{v1alpha1.HostnameSourceDHCPv4, v1alpha1.HostnameSourceTemplate},
+				Template: "{{.Name}}.example.com",
+			},
+		},
+	}}
+	loader := NewLoader(reader, reader, "default", false, nil, defaults)
+
+	cfg, err := loader.Load(context.TODO(), testImage(nil))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	inputs := cfg.Inputs
+	if inputs.IronicBaseURL != "https://ironic.test" {
+		t.Errorf("unexpected IronicBaseURL %s", inputs.IronicBaseURL)
+	}
+	if inputs.IpOptions != "ip=dhcp6" {
+		t.Errorf("unexpected IpOptions %s", inputs.IpOptions)
+	}
+	if inputs.NoProxy != "example.com" {
+		t.Errorf("unexpected NoProxy %s", inputs.NoProxy)
+	}
+	if inputs.HttpProxy != defaults.HttpProxy {
+		t.Errorf("default HttpProxy not used: %s", inputs.HttpProxy)
+	}
+	if inputs.IronicAgentImage != defaults.IronicAgentImage {
+		t.Errorf("default IronicAgentImage not used: %s", inputs.IronicAgentImage)
+	}
+	if len(cfg.RegistryMirrors) != 1 || cfg.RegistryMirrors[0].Source != "quay.io/metal3-io" {
+		t.Errorf("unexpected RegistryMirrors %v", cfg.RegistryMirrors)
+	}
+	if cfg.InspectionMode != v1alpha1.InspectionModeIronic {
+		t.Errorf("unexpected InspectionMode %s", cfg.InspectionMode)
+	}
+	if inputs.InspectionMode != defaults.InspectionMode {
+		t.Errorf("InspectionMode written to the environment inputs: %s", inputs.InspectionMode)
+	}
+	if !reflect.DeepEqual(cfg.NTPServers, []string{"ntp1.example.com", "192.0.2.1"}) {
+		t.Errorf("unexpected NTPServers %v", cfg.NTPServers)
+	}
+	if inputs.NTPServers != defaults.NTPServers {
+		t.Errorf("NTPServers written to the environment inputs: %s", inputs.NTPServers)
+	}
+	if cfg.NetworkFallbackTimeout != 5*time.Minute {
+		t.Errorf("unexpected NetworkFallbackTimeout %s", cfg.NetworkFallbackTimeout)
+	}
+	if inputs.NetworkFallbackTimeout != defaults.NetworkFallbackTimeout {
+		t.Errorf("NetworkFallbackTimeout written to the environment inputs: %s", inputs.NetworkFallbackTimeout)
+	}
+	if !reflect.DeepEqual(cfg.HostnameSources, []v1alpha1.HostnameSource{v1alpha1.HostnameSourceDHCPv4, v1alpha1.HostnameSourceTemplate}) {
+		t.Errorf("unexpected HostnameSources %v", cfg.HostnameSources)
+	}
+	if cfg.HostnameTemplate != "{{.Name}}.example.com" {
+		t.Errorf("unexpected HostnameTemplate %s", cfg.HostnameTemplate)
+	}
+	if inputs.HostnameSources != defaults.HostnameSources || inputs.HostnameTemplate != defaults.HostnameTemplate {
+		t.Errorf("hostname written to the environment inputs: %s %s", inputs.HostnameSources, inputs.HostnameTemplate)
+	}
+	if defaults.IronicBaseURL != "http://ironic.example.com" {
+		t.Errorf("defaults modified by override")
+	}
+	if !reflect.DeepEqual(cfg.Sources, []string{"environment", "ImageCustomizationConfig default"}) {
+		t.Errorf("unexpected sources %v", cfg.Sources)
+	}
+}
+
+func TestLoadNamespaceOverride(t *testing.T) {
+	reader := &fakeReader{
+		config: &v1alpha1.ImageCustomizationConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: v1alpha1.ImageCustomizationConfigSpec{
+				IronicAgentImage:    "quay.io/cluster/agent",
+				IronicRAMDiskSSHKey: "cluster key",
+				AgentRuntime:        &v1alpha1.AgentRuntimeConfig{PullPolicy: v1alpha1.PullNever},
+			},
+		},
+		override: &v1alpha1.ImageCustomizationOverride{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.OverrideName, Namespace: "tenant"},
+			Spec: v1alpha1.ImageCustomizationOverrideSpec{
+				IronicAgentImage:         "quay.io/tenant/agent",
+				IronicAgentPullSecretRef: &corev1.LocalObjectReference{Name: "pull"},
+			},
+		},
+		secrets: []*corev1.Secret{{
+			ObjectMeta: metav1.ObjectMeta{Name: "pull", Namespace: "tenant"},
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+		}},
+	}
+	loader := NewLoader(reader, reader, "default", true, nil, testDefaults())
+
+	cfg, err := loader.Load(context.TODO(), testImage(map[string]string{
+		IPOptionsAnnotation: "ip=dhcp",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	inputs := cfg.Inputs
+	if inputs.IronicAgentImage != "quay.io/tenant/agent" {
+		t.Errorf("unexpected IronicAgentImage %s", inputs.IronicAgentImage)
+	}
+	if inputs.IronicRAMDiskSSHKey != "cluster key" {
+		t.Errorf("unexpected IronicRAMDiskSSHKey %s", inputs.IronicRAMDiskSSHKey)
+	}
+	if inputs.IronicAgentPullSecret != base64.StdEncoding.EncodeToString([]byte(`{"auths":{}}`)) {
+		t.Errorf("unexpected IronicAgentPullSecret %s", inputs.IronicAgentPullSecret)
+	}
+	if inputs.IpOptions != "ip=dhcp" {
+		t.Errorf("unexpected IpOptions %s", inputs.IpOptions)
+	}
+	if cfg.AgentRuntime == nil || cfg.AgentRuntime.PullPolicy != v1alpha1.PullNever {
+		t.Errorf("unexpected AgentRuntime %v", cfg.AgentRuntime)
+	}
+	expectedSources := []string{
+		"environment",
+		"ImageCustomizationConfig default",
+		"ImageCustomizationOverride tenant/default",
+		"annotations",
+	}
+	if !reflect.DeepEqual(cfg.Sources, expectedSources) {
+		t.Errorf("unexpected sources %v", cfg.Sources)
+	}
+
+	// Annotations take precedence over the namespace override
+	cfg, err = loader.Load(context.TODO(), testImage(map[string]string{
+		AgentImageAnnotation: "quay.io/image/agent",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cfg.Inputs.IronicAgentImage != "quay.io/image/agent" {
+		t.Errorf("unexpected IronicAgentImage %s", cfg.Inputs.IronicAgentImage)
+	}
+
+	// Overrides from other namespaces do not apply
+	otherImage := testImage(nil)
+	otherImage.Namespace = "other"
+	cfg, err = loader.Load(context.TODO(), otherImage)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cfg.Inputs.IronicAgentImage != "quay.io/cluster/agent" {
+		t.Errorf("unexpected IronicAgentImage %s", cfg.Inputs.IronicAgentImage)
+	}
+}
+
+func TestLoadMissingPullSecret(t *testing.T) {
+	reader := &fakeReader{
+		override: &v1alpha1.ImageCustomizationOverride{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.OverrideName, Namespace: "tenant"},
+			Spec: v1alpha1.ImageCustomizationOverrideSpec{
+				IronicAgentPullSecretRef: &corev1.LocalObjectReference{Name: "pull"},
+			},
+		},
+	}
+	loader := NewLoader(reader, reader, "", true, nil, testDefaults())
+
+	_, err := loader.Load(context.TODO(), testImage(nil))
+	if !errors.As(err, &InvalidConfigError{}) {
+		t.Errorf("expected InvalidConfigError, got %v", err)
+	}
+}
+
+func TestLoadPullSecret(t *testing.T) {
+	reader := &fakeReader{
+		override: &v1alpha1.ImageCustomizationOverride{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.OverrideName, Namespace: "tenant"},
+			Spec: v1alpha1.ImageCustomizationOverrideSpec{
+				IronicAgentPullSecretRef: &corev1.LocalObjectReference{Name: "pull"},
+				MergePullSecret:          true,
+			},
+		},
+		secrets: []*corev1.Secret{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "global-pull", Namespace: "metal3"},
+				Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(
+					`{"auths":{"quay.io":{"auth":"Z2xvYmFs"},"registry.example.com":{"auth":"Z2xvYmFs"}}}`)},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "pull", Namespace: "tenant"},
+				Data: map[string][]byte{corev1.DockerConfigKey: []byte(
+					`{"registry.example.com":{"auth":"dGVuYW50"}}`)},
+			},
+		},
+	}
+	pullSecret := &types.NamespacedName{Namespace: "metal3", Name: "global-pull"}
+	loader := NewLoader(reader, reader, "", false, pullSecret, testDefaults())
+
+	cfg, err := loader.Load(context.TODO(), testImage(nil))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := base64.StdEncoding.EncodeToString([]byte(
+		`{"auths":{"quay.io":{"auth":"Z2xvYmFs"},"registry.example.com":{"auth":"Z2xvYmFs"}}}`))
+	if cfg.Inputs.IronicAgentPullSecret != expected {
+		t.Errorf("unexpected IronicAgentPullSecret %s", cfg.Inputs.IronicAgentPullSecret)
+	}
+	if !reflect.DeepEqual(cfg.Sources, []string{"environment", "Secret metal3/global-pull"}) {
+		t.Errorf("unexpected sources %v", cfg.Sources)
+	}
+
+	// The namespace pull secret is merged with the global one
+	loader = NewLoader(reader, reader, "", true, pullSecret, testDefaults())
+	cfg, err = loader.Load(context.TODO(), testImage(nil))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected = base64.StdEncoding.EncodeToString([]byte(
+		`{"auths":{"quay.io":{"auth":"Z2xvYmFs"},"registry.example.com":{"auth":"dGVuYW50"}}}`))
+	if cfg.Inputs.IronicAgentPullSecret != expected {
+		t.Errorf("unexpected IronicAgentPullSecret %s", cfg.Inputs.IronicAgentPullSecret)
+	}
+}
+
+func TestLoadAuthorizedKeys(t *testing.T) {
+	reader := &fakeReader{
+		override: &v1alpha1.ImageCustomizationOverride{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.OverrideName, Namespace: "tenant"},
+			Spec: v1alpha1.ImageCustomizationOverrideSpec{
+				SSHAuthorizedKeysSecretRef: &corev1.LocalObjectReference{Name: "ssh-keys"},
+			},
+		},
+		secrets: []*corev1.Secret{{
+			ObjectMeta: metav1.ObjectMeta{Name: "ssh-keys", Namespace: "tenant"},
+			Data: map[string][]byte{
+				"bob":   []byte("ssh-ed25519 BBBB bob\n"),
+				"alice": []byte("ssh-ed25519 AAAA alice\nssh-rsa AAAB alice\n"),
+			},
+		}},
+	}
+	defaults := testDefaults()
+	defaults.IronicRAMDiskSSHKey = "ssh-rsa GLOBAL"
+	loader := NewLoader(reader, reader, "", true, nil, defaults)
+
+	cfg, err := loader.Load(context.TODO(), testImage(nil))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := "ssh-rsa GLOBAL\nssh-ed25519 AAAA alice\nssh-rsa AAAB alice\nssh-ed25519 BBBB bob"
+	if cfg.Inputs.IronicRAMDiskSSHKey != expected {
+		t.Errorf("unexpected IronicRAMDiskSSHKey %q", cfg.Inputs.IronicRAMDiskSSHKey)
+	}
+
+	// A missing Secret makes the configuration invalid
+	reader.secrets = nil
+	_, err = loader.Load(context.TODO(), testImage(nil))
+	if !errors.As(err, &InvalidConfigError{}) {
+		t.Errorf("expected InvalidConfigError, got %v", err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	reader := &fakeReader{config: &v1alpha1.ImageCustomizationConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: v1alpha1.ImageCustomizationConfigSpec{
+			IronicInspectorBaseURL: "ftp://inspector.test",
+		},
+	}}
+	loader := NewLoader(reader, reader, "default", false, nil, testDefaults())
+
+	_, err := loader.Load(context.TODO(), testImage(nil))
+	if !errors.As(err, &InvalidConfigError{}) {
+		t.Errorf("expected InvalidConfigError, got %v", err)
+	}
+}
+
+func TestLoadInvalidNTPServers(t *testing.T) {
+	defaults := testDefaults()
+	defaults.NTPServers = "ntp.example.com;reboot"
+	loader := NewLoader(&fakeReader{}, &fakeReader{}, "default", false, nil, defaults)
+	if _, err := loader.Load(context.TODO(), testImage(nil)); !errors.As(err, &InvalidConfigError{}) {
+		t.Errorf("expected InvalidConfigError for environment, got %v", err)
+	}
+
+	reader := &fakeReader{config: &v1alpha1.ImageCustomizationConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: v1alpha1.ImageCustomizationConfigSpec{
+			NTPServers: []string{"ntp.example.com;reboot"},
+		},
+	}}
+	loader = NewLoader(reader, reader, "default", false, nil, testDefaults())
+	if _, err := loader.Load(context.TODO(), testImage(nil)); !errors.As(err, &InvalidConfigError{}) {
+		t.Errorf("expected InvalidConfigError for config, got %v", err)
+	}
+}
+
+func TestParseNTPServers(t *testing.T) {
+	servers, err := ParseNTPServers("ntp1.example.com, 192.0.2.1\n2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ntp1.example.com", "192.0.2.1", "2001:db8::1"}
+	if !reflect.DeepEqual(servers, expected) {
+		t.Errorf("expected %v, got %v", expected, servers)
+	}
+
+	if _, err := ParseNTPServers("ntp.example.com;reboot"); err == nil {
+		t.Error("expected invalid server to be rejected")
+	}
+}
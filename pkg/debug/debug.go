@@ -0,0 +1,104 @@
+package debug
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift/image-customization-controller/pkg/imagesecret"
+)
+
+const (
+	// Annotation on a PreprovisioningImage that enables debugging in its
+	// image when set to "true".
+	Annotation = "imagecustomization.openshift.io/debug"
+
+	// NetworkDataKey is the key in the network data Secret that enables
+	// debugging in the image when set to "true".
+	NetworkDataKey = "debug"
+
+	// PasswordKey is the key in the console password Secret that holds the
+	// password of the core user.
+	PasswordKey = "password"
+
+	// passwordBytes is the number of random bytes in a generated password.
+	passwordBytes = 18
+)
+
+// Enabled returns whether debugging is enabled for an image, either by its
+// annotation or by its network data.
+func Enabled(image *metav1.ObjectMeta, networkData map[string][]byte) bool {
+	if enabled, _ := strconv.ParseBool(image.Annotations[Annotation]); enabled {
+		return true
+	}
+	enabled, _ := strconv.ParseBool(strings.TrimSpace(string(networkData[NetworkDataKey])))
+	return enabled
+}
+
+// SecretName returns the name of the Secret holding the console password for
+// an image.
+func SecretName(imageName string) string {
+	return imageName + "-debug-console"
+}
+
+// Passwords provides the console password for each debug image.
+type Passwords interface {
+	Password(ctx context.Context, image *metav1.ObjectMeta) (string, error)
+}
+
+type secretPasswords struct {
+	client    client.Client
+	apiReader client.Reader
+}
+
+// NewPasswords returns Passwords that stores a randomly generated password
+// for each image in a Secret in the image's namespace, owned by the
+// PreprovisioningImage so that it is deleted along with it.
+func NewPasswords(c client.Client, apiReader client.Reader) Passwords {
+	return &secretPasswords{client: c, apiReader: apiReader}
+}
+
+func (p *secretPasswords) Password(ctx context.Context, image *metav1.ObjectMeta) (string, error) {
+	secret, err := imagesecret.GetOrCreate(ctx, p.client, p.apiReader, image, SecretName(image.Name), generatePassword)
+	if err != nil {
+		return "", err
+	}
+
+	password, exists := secret.Data[PasswordKey]
+	if !exists || len(password) == 0 {
+		return "", fmt.Errorf("Secret %s/%s has no %s key", secret.Namespace, secret.Name, PasswordKey)
+	}
+	return string(password), nil
+}
+
+func generatePassword() (*corev1.Secret, error) {
+	random := make([]byte, passwordBytes)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	return &corev1.Secret{
+		Data: map[string][]byte{
+			PasswordKey: []byte(base64.RawURLEncoding.EncodeToString(random)),
+		},
+	}, nil
+}
+
+// PasswordHash returns the hash of the password to use in the image. The
+// salt is derived from the image's identity, so that the same image content
+// is generated every time.
+func PasswordHash(password string, image *metav1.ObjectMeta) string {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s", image.Namespace, image.Name, image.UID)))
+	salt := make([]byte, maxSaltLength)
+	for i := range salt {
+		salt[i] = cryptAlphabet[digest[i]&0x3f]
+	}
+	return sha512Crypt(password, string(salt))
+}
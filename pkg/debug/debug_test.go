@@ -0,0 +1,125 @@
+package debug
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type secretStore struct {
+	client.Client
+	secrets map[client.ObjectKey]*corev1.Secret
+}
+
+func (s *secretStore) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	secret, exists := s.secrets[key]
+	if !exists {
+		return k8serrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, key.Name)
+	}
+	secret.DeepCopyInto(obj.(*corev1.Secret))
+	return nil
+}
+
+func (s *secretStore) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	key := client.ObjectKeyFromObject(obj)
+	if _, exists := s.secrets[key]; exists {
+		return k8serrors.NewAlreadyExists(schema.GroupResource{Resource: "secrets"}, key.Name)
+	}
+	s.secrets[key] = obj.(*corev1.Secret).DeepCopy()
+	return nil
+}
+
+func testImage() *metav1.ObjectMeta {
+	return &metav1.ObjectMeta{Namespace: "test", Name: "host-0", UID: "0123"}
+}
+
+func TestEnabled(t *testing.T) {
+	image := testImage()
+	if Enabled(image, nil) {
+		t.Error("debugging enabled by default")
+	}
+	if !Enabled(image, map[string][]byte{NetworkDataKey: []byte("true\n")}) {
+		t.Error("debugging not enabled by network data")
+	}
+	image.Annotations = map[string]string{Annotation: "true"}
+	if !Enabled(image, map[string][]byte{NetworkDataKey: []byte("false")}) {
+		t.Error("debugging not enabled by annotation")
+	}
+}
+
+func TestPassword(t *testing.T) {
+	store := &secretStore{secrets: map[client.ObjectKey]*corev1.Secret{}}
+	passwords := NewPasswords(store, store)
+	image := testImage()
+
+	password, err := passwords.Password(context.Background(), image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(password) < 16 {
+		t.Errorf("password %q is too short", password)
+	}
+
+	secret := store.secrets[client.ObjectKey{Namespace: "test", Name: "host-0-debug-console"}]
+	if secret == nil {
+		t.Fatal("Secret not created")
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].UID != image.UID {
+		t.Errorf("unexpected owner references %v", secret.OwnerReferences)
+	}
+	if secret.Labels["environment.metal3.io"] != "baremetal" {
+		t.Error("Secret is not labelled to be cached")
+	}
+
+	again, err := passwords.Password(context.Background(), image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != password {
+		t.Error("password changed")
+	}
+}
+
+func TestPasswordHash(t *testing.T) {
+	image := testImage()
+	hash := PasswordHash("secret", image)
+	if !strings.HasPrefix(hash, "$6$") {
+		t.Errorf("unexpected hash %q", hash)
+	}
+	if PasswordHash("secret", image) != hash {
+		t.Error("hash is not deterministic")
+	}
+	image.UID = "4567"
+	if PasswordHash("secret", image) == hash {
+		t.Error("hash does not depend on the image")
+	}
+}
+
+func TestPasswordNotOwned(t *testing.T) {
+	store := &secretStore{secrets: map[client.ObjectKey]*corev1.Secret{}}
+	passwords := NewPasswords(store, store)
+	image := testImage()
+
+	// Left over from a previous image with the same name
+	key := client.ObjectKey{Namespace: "test", Name: "host-0-debug-console"}
+	store.secrets[key] = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: key.Namespace,
+			Name:      key.Name,
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind: "PreprovisioningImage",
+				Name: "host-0",
+				UID:  "4567",
+			}},
+		},
+	}
+	if _, err := passwords.Password(context.Background(), image); err == nil {
+		t.Error("expected error for Secret owned by another image")
+	}
+}
@@ -0,0 +1,111 @@
+package debug
+
+import (
+	"crypto/sha512"
+)
+
+const (
+	cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	cryptRounds   = 5000
+	maxSaltLength = 16
+)
+
+// cryptOrder is the order in which the bytes of the final digest are
+// encoded, in groups of three.
+var cryptOrder = [21][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+	{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+	{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+	{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+	{62, 20, 41},
+}
+
+// repeat returns data repeated to fill length bytes.
+func repeat(data []byte, length int) []byte {
+	result := make([]byte, 0, length)
+	for len(result) < length {
+		n := length - len(result)
+		if n > len(data) {
+			n = len(data)
+		}
+		result = append(result, data[:n]...)
+	}
+	return result
+}
+
+// sha512Crypt returns the SHA-512 crypt(3) hash of a password, in the
+// format accepted by /etc/shadow, using the default number of rounds.
+func sha512Crypt(password, salt string) string {
+	if len(salt) > maxSaltLength {
+		salt = salt[:maxSaltLength]
+	}
+	key, s := []byte(password), []byte(salt)
+
+	b := sha512.New()
+	b.Write(key)
+	b.Write(s)
+	b.Write(key)
+	digestB := b.Sum(nil)
+
+	a := sha512.New()
+	a.Write(key)
+	a.Write(s)
+	a.Write(repeat(digestB, len(key)))
+	for i := len(key); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(digestB)
+		} else {
+			a.Write(key)
+		}
+	}
+	digestA := a.Sum(nil)
+
+	dp := sha512.New()
+	for i := 0; i < len(key); i++ {
+		dp.Write(key)
+	}
+	p := repeat(dp.Sum(nil), len(key))
+
+	ds := sha512.New()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		ds.Write(s)
+	}
+	sBytes := repeat(ds.Sum(nil), len(s))
+
+	c := digestA
+	for i := 0; i < cryptRounds; i++ {
+		h := sha512.New()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(sBytes)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	encoded := make([]byte, 0, 86)
+	encode := func(b2, b1, b0 byte, n int) {
+		w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+		for ; n > 0; n-- {
+			encoded = append(encoded, cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	for _, group := range cryptOrder {
+		encode(c[group[0]], c[group[1]], c[group[2]], 4)
+	}
+	encode(0, 0, c[63], 2)
+
+	return "$6$" + salt + "$" + string(encoded)
+}
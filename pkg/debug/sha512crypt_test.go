@@ -0,0 +1,32 @@
+package debug
+
+import "testing"
+
+func TestSHA512Crypt(t *testing.T) {
+	// Expected values generated with openssl passwd -6
+	for _, tc := range []struct {
+		password string
+		salt     string
+		expected string
+	}{
+		{
+			password: "Hello world!",
+			salt:     "saltstring",
+			expected: "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+		},
+		{
+			password: "we have a short salt string but not a short password",
+			salt:     "short",
+			expected: "$6$short$qmfj2meTBr5G2EAGIJ4vjX7RpefsD4JzpEyTAeEUJdzdxlBS6pe8gdMHm5zFftaFSj/2p2bjBwyVS9ZhWpLZt.",
+		},
+		{
+			password: "a very long password that is much longer than sixty-four bytes in total length",
+			salt:     "abcdefghijklmnopqrstuvwxyz",
+			expected: "$6$abcdefghijklmnop$dH2phYWKGfKMK6EeYN8vfIyBxTX9fUk7dsgawnnf4mDyoSJZk5BwO1Vw/0IblCFRI9mXgA0sgWj2YD8lQ9U9o.",
+		},
+	} {
+		if hash := sha512Crypt(tc.password, tc.salt); hash != tc.expected {
+			t.Errorf("unexpected hash %s, expected %s", hash, tc.expected)
+		}
+	}
+}
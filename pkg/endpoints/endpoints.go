@@ -0,0 +1,202 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+
+	"github.com/openshift/image-customization-controller/pkg/config"
+)
+
+// ServiceRef identifies a Service by namespace and name.
+type ServiceRef struct {
+	Namespace string
+	Name      string
+}
+
+func (ref ServiceRef) String() string {
+	return ref.Namespace + "/" + ref.Name
+}
+
+// ParseServiceRef parses a Service reference of the form namespace/name. An
+// empty string results in a nil reference.
+func ParseServiceRef(value string) (*ServiceRef, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid Service reference %q: must be namespace/name", value)
+	}
+	return &ServiceRef{Namespace: parts[0], Name: parts[1]}, nil
+}
+
+// NoEndpointsError is returned when a Service has no ready endpoints.
+type NoEndpointsError struct {
+	Service ServiceRef
+}
+
+func (e NoEndpointsError) Error() string {
+	return fmt.Sprintf("no ready endpoints for Service %s", e.Service)
+}
+
+// Resolver fills in the Ironic URLs that are not configured explicitly from
+// the endpoints of Services.
+type Resolver interface {
+	Resolve(ctx context.Context, cfg *config.Config, nmstateData []byte) (*config.Config, error)
+}
+
+type serviceResolver struct {
+	reader    client.Reader
+	ironic    *ServiceRef
+	inspector *ServiceRef
+	scheme    string
+}
+
+// NewResolver returns a Resolver that looks up the EndpointSlices of the
+// given Ironic and Ironic inspector Services. Either reference may be nil.
+func NewResolver(reader client.Reader, ironic, inspector *ServiceRef, scheme string) Resolver {
+	return &serviceResolver{
+		reader:    reader,
+		ironic:    ironic,
+		inspector: inspector,
+		scheme:    scheme,
+	}
+}
+
+// Resolve returns a copy of the configuration with the Ironic URLs that are
+// not set filled in from the Service endpoints. An address from the IP family
+// used by the host's network data is chosen where available.
+func (r *serviceResolver) Resolve(ctx context.Context, cfg *config.Config, nmstateData []byte) (*config.Config, error) {
+	resolved := *cfg.Inputs
+	family := AddressFamily(nmstateData)
+
+	if resolved.IronicBaseURL == "" && r.ironic != nil {
+		url, err := r.serviceURL(ctx, *r.ironic, family)
+		if err != nil {
+			return nil, err
+		}
+		resolved.IronicBaseURL = url
+	}
+	if resolved.IronicInspectorBaseURL == "" && r.inspector != nil && config.UsesInspector(cfg) {
+		url, err := r.serviceURL(ctx, *r.inspector, family)
+		if err != nil {
+			return nil, err
+		}
+		resolved.IronicInspectorBaseURL = url
+	}
+
+	resolvedConfig := *cfg
+	resolvedConfig.Inputs = &resolved
+	return &resolvedConfig, nil
+}
+
+func (r *serviceResolver) serviceURL(ctx context.Context, ref ServiceRef, family discoveryv1.AddressType) (string, error) {
+	slices := &discoveryv1.EndpointSliceList{}
+	if err := r.reader.List(ctx, slices,
+		client.InNamespace(ref.Namespace),
+		client.MatchingLabels{discoveryv1.LabelServiceName: ref.Name}); err != nil {
+		return "", err
+	}
+
+	addresses := readyAddresses(slices.Items)
+	candidates := addresses[family]
+	if len(candidates) == 0 {
+		for _, addressType := range []discoveryv1.AddressType{discoveryv1.AddressTypeIPv4, discoveryv1.AddressTypeIPv6} {
+			if len(addresses[addressType]) > 0 {
+				candidates = addresses[addressType]
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", NoEndpointsError{Service: ref}
+	}
+	return fmt.Sprintf("%s://%s", r.scheme, candidates[0]), nil
+}
+
+// readyAddresses returns the host:port of every ready endpoint in the
+// EndpointSlices, grouped by address type and sorted so that the choice of
+// endpoint is stable.
+func readyAddresses(slices []discoveryv1.EndpointSlice) map[discoveryv1.AddressType][]string {
+	addresses := map[discoveryv1.AddressType][]string{}
+	for _, slice := range slices {
+		if len(slice.Ports) == 0 || slice.Ports[0].Port == nil {
+			continue
+		}
+		port := fmt.Sprintf("%d", *slice.Ports[0].Port)
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			for _, address := range endpoint.Addresses {
+				addresses[slice.AddressType] = append(addresses[slice.AddressType],
+					net.JoinHostPort(address, port))
+			}
+		}
+	}
+	for _, list := range addresses {
+		sort.Strings(list)
+	}
+	return addresses
+}
+
+type nmstateIPConfig struct {
+	Interfaces []struct {
+		IPv4 *struct {
+			Enabled bool `json:"enabled"`
+		} `json:"ipv4"`
+		IPv6 *struct {
+			Enabled bool `json:"enabled"`
+		} `json:"ipv6"`
+	} `json:"interfaces"`
+}
+
+// AddressFamily returns the IP family that the host uses according to its
+// NMState network data. IPv4 is assumed unless only IPv6 is enabled.
+func AddressFamily(nmstateData []byte) discoveryv1.AddressType {
+	ipConfig := &nmstateIPConfig{}
+	if err := yaml.Unmarshal(nmstateData, ipConfig); err != nil {
+		return discoveryv1.AddressTypeIPv4
+	}
+
+	ipv6 := false
+	for _, iface := range ipConfig.Interfaces {
+		if iface.IPv4 != nil && iface.IPv4.Enabled {
+			return discoveryv1.AddressTypeIPv4
+		}
+		if iface.IPv6 != nil && iface.IPv6.Enabled {
+			ipv6 = true
+		}
+	}
+	if ipv6 {
+		return discoveryv1.AddressTypeIPv6
+	}
+	return discoveryv1.AddressTypeIPv4
+}
+
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+
+// EnqueueAllImages returns a handler that requests reconciliation of every
+// PreprovisioningImage when the EndpointSlices of any of the given Services
+// change, so that the images are rebuilt with the new endpoints.
+func EnqueueAllImages(reader client.Reader, log logr.Logger, services ...*ServiceRef) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		for _, ref := range services {
+			if ref != nil && obj.GetNamespace() == ref.Namespace &&
+				obj.GetLabels()[discoveryv1.LabelServiceName] == ref.Name {
+				return config.ImageRequests(reader, log)
+			}
+		}
+		return nil
+	})
+}
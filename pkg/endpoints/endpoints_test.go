@@ -0,0 +1,156 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/config"
+	"github.com/openshift/image-customization-controller/pkg/env"
+)
+
+type fakeReader struct {
+	slices []discoveryv1.EndpointSlice
+}
+
+func (f *fakeReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return nil
+}
+
+func (f *fakeReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	sliceList := list.(*discoveryv1.EndpointSliceList)
+	for _, slice := range f.slices {
+		if slice.Namespace != listOpts.Namespace ||
+			!listOpts.LabelSelector.Matches(labels.Set(slice.Labels)) {
+			continue
+		}
+		sliceList.Items = append(sliceList.Items, slice)
+	}
+	return nil
+}
+
+func testSlice(service string, addressType discoveryv1.AddressType, port int32, addresses ...string) discoveryv1.EndpointSlice {
+	slice := discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "metal3",
+			Name:      service + "-" + string(addressType),
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: addressType,
+		Ports:       []discoveryv1.EndpointPort{{Port: pointer.Int32(port)}},
+	}
+	for _, address := range addresses {
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{address},
+			Conditions: discoveryv1.EndpointConditions{Ready: pointer.Bool(true)},
+		})
+	}
+	return slice
+}
+
+const ipv6NMState = `interfaces:
+- name: eth0
+  type: ethernet
+  ipv4:
+    enabled: false
+  ipv6:
+    enabled: true
+    dhcp: true
+`
+
+func TestResolve(t *testing.T) {
+	reader := &fakeReader{slices: []discoveryv1.EndpointSlice{
+		testSlice("ironic", discoveryv1.AddressTypeIPv4, 6385, "192.0.2.2", "192.0.2.1"),
+		testSlice("ironic", discoveryv1.AddressTypeIPv6, 6385, "2001:db8::1"),
+		testSlice("inspector", discoveryv1.AddressTypeIPv4, 5050, "192.0.2.3"),
+	}}
+	resolver := NewResolver(reader,
+		&ServiceRef{Namespace: "metal3", Name: "ironic"},
+		&ServiceRef{Namespace: "metal3", Name: "inspector"},
+		"https")
+
+	tests := []struct {
+		name           string
+		inputs         env.EnvInputs
+		inspectionMode v1alpha1.InspectionMode
+		nmstate        string
+		wantIronic     string
+		wantInspector  string
+	}{
+		{
+			name:          "IPv4",
+			wantIronic:    "https://192.0.2.1:6385",
+			wantInspector: "https://192.0.2.3:5050",
+		},
+		{
+			name:          "IPv6",
+			nmstate:       ipv6NMState,
+			wantIronic:    "https://[2001:db8::1]:6385",
+			wantInspector: "https://192.0.2.3:5050",
+		},
+		{
+			name:          "explicit URL",
+			inputs:        env.EnvInputs{IronicBaseURL: "http://ironic.example.com"},
+			wantIronic:    "http://ironic.example.com",
+			wantInspector: "https://192.0.2.3:5050",
+		},
+		{
+			name:           "built-in inspection",
+			inspectionMode: v1alpha1.InspectionModeIronic,
+			wantIronic:     "https://192.0.2.1:6385",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Inputs: &tt.inputs, InspectionMode: tt.inspectionMode}
+			resolvedConfig, err := resolver.Resolve(context.TODO(), cfg, []byte(tt.nmstate))
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			resolved := resolvedConfig.Inputs
+			if resolved.IronicBaseURL != tt.wantIronic {
+				t.Errorf("IronicBaseURL = %s, want %s", resolved.IronicBaseURL, tt.wantIronic)
+			}
+			if resolved.IronicInspectorBaseURL != tt.wantInspector {
+				t.Errorf("IronicInspectorBaseURL = %s, want %s", resolved.IronicInspectorBaseURL, tt.wantInspector)
+			}
+		})
+	}
+}
+
+func TestResolveNoEndpoints(t *testing.T) {
+	notReady := testSlice("ironic", discoveryv1.AddressTypeIPv4, 6385, "192.0.2.1")
+	notReady.Endpoints[0].Conditions.Ready = pointer.Bool(false)
+	resolver := NewResolver(&fakeReader{slices: []discoveryv1.EndpointSlice{notReady}},
+		&ServiceRef{Namespace: "metal3", Name: "ironic"}, nil, "https")
+
+	_, err := resolver.Resolve(context.TODO(), &config.Config{Inputs: &env.EnvInputs{}}, nil)
+	if !errors.As(err, &NoEndpointsError{}) {
+		t.Errorf("expected NoEndpointsError, got %v", err)
+	}
+}
+
+func TestParseServiceRef(t *testing.T) {
+	ref, err := ParseServiceRef("metal3/ironic")
+	if err != nil || *ref != (ServiceRef{Namespace: "metal3", Name: "ironic"}) {
+		t.Errorf("unexpected result %v, %v", ref, err)
+	}
+	if ref, err := ParseServiceRef(""); ref != nil || err != nil {
+		t.Errorf("unexpected result %v, %v", ref, err)
+	}
+	for _, value := range []string{"ironic", "metal3/", "a/b/c"} {
+		if _, err := ParseServiceRef(value); err == nil {
+			t.Errorf("expected error for %q", value)
+		}
+	}
+}
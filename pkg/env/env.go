@@ -1,25 +1,52 @@
 package env
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/openshift/image-customization-controller/pkg/registries"
 )
 
 type EnvInputs struct {
-	DeployISO              string `envconfig:"DEPLOY_ISO" required:"true"`
-	DeployInitrd           string `envconfig:"DEPLOY_INITRD" required:"true"`
-	IronicBaseURL          string `envconfig:"IRONIC_BASE_URL"`
-	IronicInspectorBaseURL string `envconfig:"IRONIC_INSPECTOR_BASE_URL"`
-	IronicAgentImage       string `envconfig:"IRONIC_AGENT_IMAGE" required:"true"`
-	IronicAgentPullSecret  string `envconfig:"IRONIC_AGENT_PULL_SECRET"`
-	IronicRAMDiskSSHKey    string `envconfig:"IRONIC_RAMDISK_SSH_KEY"`
-	RegistriesConfPath     string `envconfig:"REGISTRIES_CONF_PATH"`
-	IpOptions              string `envconfig:"IP_OPTIONS"`
-	HttpProxy              string `envconfig:"HTTP_PROXY"`
-	HttpsProxy             string `envconfig:"HTTPS_PROXY"`
-	NoProxy                string `envconfig:"NO_PROXY"`
+	DeployISO              string        `envconfig:"DEPLOY_ISO" required:"true"`
+	DeployInitrd           string        `envconfig:"DEPLOY_INITRD" required:"true"`
+	IronicBaseURL          string        `envconfig:"IRONIC_BASE_URL"`
+	IronicInspectorBaseURL string        `envconfig:"IRONIC_INSPECTOR_BASE_URL"`
+	IronicService          string        `envconfig:"IRONIC_SERVICE"`
+	IronicInspectorService string        `envconfig:"IRONIC_INSPECTOR_SERVICE"`
+	InspectionMode         string        `envconfig:"IRONIC_INSPECTION_MODE" default:"Inspector"`
+	IronicServiceScheme    string        `envconfig:"IRONIC_SERVICE_SCHEME" default:"https"`
+	IronicAgentImage       string        `envconfig:"IRONIC_AGENT_IMAGE" required:"true"`
+	IronicAgentPullSecret  string        `envconfig:"IRONIC_AGENT_PULL_SECRET"`
+	PullSecretName         string        `envconfig:"IRONIC_AGENT_PULL_SECRET_NAME"`
+	IronicRAMDiskSSHKey    string        `envconfig:"IRONIC_RAMDISK_SSH_KEY"`
+	RegistriesConfPath     string        `envconfig:"REGISTRIES_CONF_PATH"`
+	RegistriesConfDDir     string        `envconfig:"REGISTRIES_CONF_D_DIR"`
+	RegistryCertsDir       string        `envconfig:"REGISTRY_CERTS_DIR"`
+	ContainerTrustDir      string        `envconfig:"CONTAINER_TRUST_DIR"`
+	IpOptions              string        `envconfig:"IP_OPTIONS"`
+	HttpProxy              string        `envconfig:"HTTP_PROXY"`
+	HttpsProxy             string        `envconfig:"HTTPS_PROXY"`
+	NoProxy                string        `envconfig:"NO_PROXY"`
+	NTPServers             string        `envconfig:"NTP_SERVERS"`
+	HostnameSources        string        `envconfig:"HOSTNAME_SOURCES"`
+	HostnameTemplate       string        `envconfig:"HOSTNAME_TEMPLATE"`
+	NetworkFallbackTimeout time.Duration `envconfig:"NETWORK_FALLBACK_TIMEOUT"`
+	NMStateTimeout         time.Duration `envconfig:"NMSTATECTL_TIMEOUT" default:"30s"`
+	NMStateMaxConcurrency  int           `envconfig:"NMSTATECTL_MAX_CONCURRENCY" default:"4"`
+	ImageBuildWorkers      int           `envconfig:"IMAGE_BUILD_WORKERS" default:"4"`
+	ImageURLSigningKey     string        `envconfig:"IMAGE_URL_SIGNING_KEY"`
+	BootProgressKey        string        `envconfig:"BOOT_PROGRESS_KEY"`
+	LogSinkKey             string        `envconfig:"LOG_SINK_KEY"`
 }
 
 func New() (*EnvInputs, error) {
@@ -28,6 +55,8 @@ func New() (*EnvInputs, error) {
 	return env, err
 }
 
+// RegistriesConf returns the contents of the configured registries.conf
+// file, after checking that it is valid.
 func (env *EnvInputs) RegistriesConf() (data []byte, err error) {
 	if env.RegistriesConfPath == "" {
 		return
@@ -37,6 +66,134 @@ func (env *EnvInputs) RegistriesConf() (data []byte, err error) {
 	if err != nil {
 		err = errors.Wrapf(err, "failed to read registries.conf file %s",
 			env.RegistriesConfPath)
+		return
+	}
+	if _, err = registries.Parse(data); err != nil {
+		err = errors.Wrapf(err, "invalid registries.conf file %s",
+			env.RegistriesConfPath)
 	}
 	return
 }
+
+// RegistriesDropins returns the registries.conf.d drop-ins in the configured
+// directory, indexed by file name, after checking that they are valid.
+func (env *EnvInputs) RegistriesDropins() (map[string][]byte, error) {
+	if env.RegistriesConfDDir == "" {
+		return nil, nil
+	}
+
+	files, err := readDir(env.RegistriesConfDDir, "registries.conf.d")
+	if err != nil {
+		return nil, err
+	}
+	if err := registries.ValidateDropins(files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// RegistryCertificates returns the files for /etc/containers/certs.d in the
+// configured directory, indexed by their path relative to it. The directory
+// contains a subdirectory for each registry, so that a ConfigMap can be
+// mounted for each.
+func (env *EnvInputs) RegistryCertificates() (map[string][]byte, error) {
+	if env.RegistryCertsDir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(env.RegistryCertsDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read registry certificates directory %s", env.RegistryCertsDir)
+	}
+	certs := map[string][]byte{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		dirPath := filepath.Join(env.RegistryCertsDir, entry.Name())
+		info, err := os.Stat(dirPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read registry certificates directory %s", dirPath)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("registry certificate %s must be in a directory named after the registry", dirPath)
+		}
+		files, err := readDir(dirPath, "registry certificates")
+		if err != nil {
+			return nil, err
+		}
+		for name, data := range files {
+			certs[path.Join(entry.Name(), name)] = data
+		}
+	}
+	if err := registries.ValidateCerts(certs); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// ContainerTrust returns the contents of the container trust policy files
+// (policy.json, registries.d configuration and public keys) in the
+// configured directory, indexed by file name.
+func (env *EnvInputs) ContainerTrust() (map[string][]byte, error) {
+	if env.ContainerTrustDir == "" {
+		return nil, nil
+	}
+
+	return readDir(env.ContainerTrustDir, "container trust")
+}
+
+// readDir returns the contents of the regular files in a directory, indexed
+// by file name. Hidden files, such as those created when a ConfigMap is
+// mounted, and subdirectories are ignored.
+func readDir(dir, description string) (map[string][]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s directory %s", description, dir)
+	}
+	files := map[string][]byte{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		filePath := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s file %s", description, filePath)
+		}
+		if info.IsDir() {
+			continue
+		}
+		if files[entry.Name()], err = os.ReadFile(filePath); err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s file %s", description, filePath)
+		}
+	}
+	return files, nil
+}
+
+// PullSecretRef returns the location of the Secret containing the pull
+// secret, if one is configured. The name may be given as namespace/name, or
+// as just a name in the default namespace.
+func (env *EnvInputs) PullSecretRef(defaultNamespace string) (*types.NamespacedName, error) {
+	if env.PullSecretName == "" {
+		return nil, nil
+	}
+	parts := strings.Split(env.PullSecretName, "/")
+	switch {
+	case len(parts) == 1 && defaultNamespace != "":
+		return &types.NamespacedName{Namespace: defaultNamespace, Name: parts[0]}, nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return &types.NamespacedName{Namespace: parts[0], Name: parts[1]}, nil
+	default:
+		return nil, fmt.Errorf("invalid pull secret name %q: must be namespace/name", env.PullSecretName)
+	}
+}
+
+// NMStateContext returns a context derived from parent that limits the time
+// nmstatectl is allowed to run for, if a timeout is configured.
+func (env *EnvInputs) NMStateContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if env.NMStateTimeout > 0 {
+		return context.WithTimeout(parent, env.NMStateTimeout)
+	}
+	return context.WithCancel(parent)
+}
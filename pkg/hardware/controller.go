@@ -0,0 +1,179 @@
+package hardware
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+)
+
+// ConditionInterfacesMatched is the PreprovisioningImage condition reporting
+// whether the interfaces in the network data match the NICs on the host.
+const ConditionInterfacesMatched = "InterfacesMatched"
+
+const (
+	reasonInterfacesMatched    = "InterfacesMatched"
+	reasonInterfacesMismatched = "InterfacesMismatched"
+	reasonHardwareUnknown      = "HardwareUnknown"
+	reasonInvalidNetworkData   = "InvalidNetworkData"
+)
+
+// InterfaceReconciler reports in the status of each PreprovisioningImage
+// whether the interfaces in its network data match the NICs discovered on
+// the BareMetalHost.
+type InterfaceReconciler struct {
+	client.Client
+	APIReader client.Reader
+	Log       logr.Logger
+	Inventory Inventory
+}
+
+// +kubebuilder:rbac:groups=metal3.io,resources=baremetalhosts,verbs=get;list;watch
+// +kubebuilder:rbac:groups=metal3.io,resources=preprovisioningimages/status,verbs=get;update;patch
+
+func (r *InterfaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("preprovisioningimage", req.NamespacedName)
+
+	img := &metal3.PreprovisioningImage{}
+	if err := r.Get(ctx, req.NamespacedName, img); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	condition, err := r.interfaceCondition(ctx, img)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	newStatus := img.Status.DeepCopy()
+	meta.SetStatusCondition(&newStatus.Conditions, condition)
+	if apiequality.Semantic.DeepEqual(&img.Status, newStatus) {
+		return ctrl.Result{}, nil
+	}
+
+	log.Info("updating interface status", "matched", condition.Status)
+	img.Status = *newStatus
+	err = r.Status().Update(ctx, img)
+	if k8serrors.IsConflict(err) {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	return ctrl.Result{}, err
+}
+
+func (r *InterfaceReconciler) interfaceCondition(ctx context.Context, img *metal3.PreprovisioningImage) (metav1.Condition, error) {
+	condition := metav1.Condition{
+		Type:               ConditionInterfacesMatched,
+		Status:             metav1.ConditionUnknown,
+		ObservedGeneration: img.Generation,
+		Reason:             reasonHardwareUnknown,
+	}
+
+	nics, err := r.Inventory.NICs(ctx, img)
+	if err != nil {
+		return condition, err
+	}
+	if len(nics) == 0 {
+		condition.Message = "host has not been inspected"
+		return condition, nil
+	}
+
+	nmstateData, err := r.networkData(ctx, img)
+	if err != nil {
+		return condition, err
+	}
+
+	check, err := CheckInterfaces(nmstateData, nics)
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonInvalidNetworkData
+		condition.Message = err.Error()
+		return condition, nil
+	}
+	if len(check.Mismatches) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonInterfacesMismatched
+		condition.Message = strings.Join(check.Mismatches, "; ")
+		return condition, nil
+	}
+	condition.Status = metav1.ConditionTrue
+	condition.Reason = reasonInterfacesMatched
+	return condition, nil
+}
+
+// networkData returns the NMState data for the image. The network data
+// Secret is read directly from the API, as only labelled Secrets are cached.
+func (r *InterfaceReconciler) networkData(ctx context.Context, img *metal3.PreprovisioningImage) ([]byte, error) {
+	if img.Spec.NetworkDataName == "" {
+		return nil, nil
+	}
+
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: img.Namespace, Name: img.Spec.NetworkDataName}
+	if err := r.APIReader.Get(ctx, key, secret); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return secret.Data["nmstate"], nil
+}
+
+func (r *InterfaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("interfacecheck").
+		For(&metal3.PreprovisioningImage{}).
+		Watches(&source.Kind{Type: &metal3.BareMetalHost{}}, EnqueueHostImages(r.Client, r.Log)).
+		Complete(r)
+}
+
+// hostOwnerIndex is the field index of PreprovisioningImages by the name of
+// the BareMetalHost that owns them.
+const hostOwnerIndex = "metadata.ownerReferences.bareMetalHost"
+
+// IndexHostImages adds the index of PreprovisioningImages by their owning
+// BareMetalHost that EnqueueHostImages requires. It must be called only once
+// for a manager.
+func IndexHostImages(ctx context.Context, indexer client.FieldIndexer) error {
+	return indexer.IndexField(ctx, &metal3.PreprovisioningImage{}, hostOwnerIndex, func(obj client.Object) []string {
+		if name := ownerHostName(obj); name != "" {
+			return []string{name}
+		}
+		return nil
+	})
+}
+
+// EnqueueHostImages returns a handler that requests reconciliation of the
+// PreprovisioningImages owned by a BareMetalHost when the host changes, e.g.
+// when its hardware details become available after inspection. The images
+// are found using the index added by IndexHostImages.
+func EnqueueHostImages(reader client.Reader, log logr.Logger) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		images := &metal3.PreprovisioningImageList{}
+		err := reader.List(context.Background(), images, client.InNamespace(obj.GetNamespace()),
+			client.MatchingFields{hostOwnerIndex: obj.GetName()})
+		if err != nil {
+			log.Error(err, "unable to list PreprovisioningImages", "baremetalhost", client.ObjectKeyFromObject(obj))
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(images.Items))
+		for _, img := range images.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: img.Namespace,
+					Name:      img.Name,
+				},
+			})
+		}
+		return requests
+	})
+}
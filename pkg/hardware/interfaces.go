@@ -0,0 +1,116 @@
+package hardware
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+)
+
+type nmstateInterfaces struct {
+	Interfaces []struct {
+		Name       string `json:"name"`
+		Type       string `json:"type"`
+		MACAddress string `json:"mac-address"`
+	} `json:"interfaces"`
+}
+
+// InterfaceCheck is the result of comparing the interfaces in NMState data
+// with the NICs discovered on a host.
+type InterfaceCheck struct {
+	// MACs maps the names of ethernet interfaces in the NMState data to the
+	// MAC address of the matching NIC on the host.
+	MACs map[string]string
+	// Mismatches describes each ethernet interface in the NMState data that
+	// does not match a NIC on the host by name.
+	Mismatches []string
+}
+
+// CheckInterfaces compares the ethernet interfaces in the NMState data with
+// the NICs on the host. An interface matches a NIC if it has the same name;
+// otherwise, if the interface specifies a MAC address, it matches the NIC with
+// that MAC address but is reported as a mismatch.
+func CheckInterfaces(nmstateData []byte, nics []metal3.NIC) (*InterfaceCheck, error) {
+	ifaces := &nmstateInterfaces{}
+	if err := yaml.Unmarshal(nmstateData, ifaces); err != nil {
+		return nil, err
+	}
+
+	byName := map[string]string{}
+	byMAC := map[string]string{}
+	for _, nic := range nics {
+		byName[nic.Name] = nic.MAC
+		byMAC[strings.ToLower(nic.MAC)] = nic.Name
+	}
+
+	check := &InterfaceCheck{MACs: map[string]string{}}
+	for _, iface := range ifaces.Interfaces {
+		if iface.Type != "ethernet" {
+			continue
+		}
+		if mac, found := byName[iface.Name]; found {
+			check.MACs[iface.Name] = mac
+			continue
+		}
+		if nicName, found := byMAC[strings.ToLower(iface.MACAddress)]; found && iface.MACAddress != "" {
+			check.MACs[iface.Name] = byName[nicName]
+			check.Mismatches = append(check.Mismatches,
+				fmt.Sprintf("interface %s matches NIC %s by MAC address %s", iface.Name, nicName, iface.MACAddress))
+			continue
+		}
+		check.Mismatches = append(check.Mismatches,
+			fmt.Sprintf("interface %s not found on host", iface.Name))
+	}
+	return check, nil
+}
+
+// Inventory looks up the NICs discovered on the host for which an image is
+// built.
+type Inventory interface {
+	NICs(ctx context.Context, image metav1.Object) ([]metal3.NIC, error)
+}
+
+type bmhInventory struct {
+	reader client.Reader
+}
+
+// NewInventory returns an Inventory that reads the hardware details from the
+// BareMetalHost that owns each PreprovisioningImage.
+func NewInventory(reader client.Reader) Inventory {
+	return &bmhInventory{reader: reader}
+}
+
+// NICs returns the NICs of the BareMetalHost that owns the image. If there is
+// no owning host, or it has not been inspected, no NICs are returned.
+func (bi *bmhInventory) NICs(ctx context.Context, image metav1.Object) ([]metal3.NIC, error) {
+	hostName := ownerHostName(image)
+	if hostName == "" {
+		return nil, nil
+	}
+
+	host := &metal3.BareMetalHost{}
+	key := client.ObjectKey{Namespace: image.GetNamespace(), Name: hostName}
+	if err := bi.reader.Get(ctx, key, host); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	if host.Status.HardwareDetails == nil {
+		return nil, nil
+	}
+	return host.Status.HardwareDetails.NIC, nil
+}
+
+// ownerHostName returns the name of the BareMetalHost that owns an image, or
+// an empty string if there is none.
+func ownerHostName(image metav1.Object) string {
+	for _, owner := range image.GetOwnerReferences() {
+		if owner.Kind == "BareMetalHost" && owner.APIVersion == metal3.GroupVersion.String() {
+			return owner.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,169 @@
+package hardware
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+)
+
+var testNICs = []metal3.NIC{
+	{Name: "eno1", MAC: "00:11:22:33:44:55"},
+	{Name: "eno2", MAC: "00:11:22:33:44:66"},
+}
+
+func TestCheckInterfaces(t *testing.T) {
+	tests := []struct {
+		name           string
+		nmstate        string
+		wantMACs       map[string]string
+		wantMismatches []string
+	}{
+		{
+			name: "matched by name",
+			nmstate: `interfaces:
+- name: eno1
+  type: ethernet
+- name: bond0
+  type: bond
+`,
+			wantMACs: map[string]string{"eno1": "00:11:22:33:44:55"},
+		},
+		{
+			name: "matched by MAC",
+			nmstate: `interfaces:
+- name: eth1
+  type: ethernet
+  mac-address: 00:11:22:33:44:66
+`,
+			wantMACs:       map[string]string{"eth1": "00:11:22:33:44:66"},
+			wantMismatches: []string{"interface eth1 matches NIC eno2 by MAC address 00:11:22:33:44:66"},
+		},
+		{
+			name: "not found",
+			nmstate: `interfaces:
+- name: eth0
+  type: ethernet
+`,
+			wantMACs:       map[string]string{},
+			wantMismatches: []string{"interface eth0 not found on host"},
+		},
+		{
+			name:     "no network data",
+			wantMACs: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check, err := CheckInterfaces([]byte(tt.nmstate), testNICs)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(check.MACs, tt.wantMACs) {
+				t.Errorf("MACs = %v, want %v", check.MACs, tt.wantMACs)
+			}
+			if !reflect.DeepEqual(check.Mismatches, tt.wantMismatches) {
+				t.Errorf("Mismatches = %v, want %v", check.Mismatches, tt.wantMismatches)
+			}
+		})
+	}
+}
+
+type fakeReader struct {
+	host *metal3.BareMetalHost
+}
+
+func (f *fakeReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	host, ok := obj.(*metal3.BareMetalHost)
+	if !ok || f.host == nil || key != client.ObjectKeyFromObject(f.host) {
+		return k8serrors.NewNotFound(schema.GroupResource{}, key.Name)
+	}
+	f.host.DeepCopyInto(host)
+	return nil
+}
+
+func (f *fakeReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return nil
+}
+
+func TestInventoryNICs(t *testing.T) {
+	host := &metal3.BareMetalHost{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "host-0"},
+		Status: metal3.BareMetalHostStatus{
+			HardwareDetails: &metal3.HardwareDetails{NIC: testNICs},
+		},
+	}
+	image := &metav1.ObjectMeta{
+		Namespace: "test",
+		Name:      "host-0",
+		OwnerReferences: []metav1.OwnerReference{{
+			APIVersion: metal3.GroupVersion.String(),
+			Kind:       "BareMetalHost",
+			Name:       "host-0",
+		}},
+	}
+
+	nics, err := NewInventory(&fakeReader{host: host}).NICs(context.TODO(), image)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(nics, testNICs) {
+		t.Errorf("NICs = %v, want %v", nics, testNICs)
+	}
+
+	nics, err = NewInventory(&fakeReader{}).NICs(context.TODO(), image)
+	if err != nil || nics != nil {
+		t.Errorf("expected no NICs for missing host, got %v, %v", nics, err)
+	}
+
+	nics, err = NewInventory(&fakeReader{host: host}).NICs(context.TODO(), &metav1.ObjectMeta{Namespace: "test", Name: "host-0"})
+	if err != nil || nics != nil {
+		t.Errorf("expected no NICs for unowned image, got %v, %v", nics, err)
+	}
+}
+
+type fakeIndexer struct {
+	field   string
+	extract client.IndexerFunc
+}
+
+func (f *fakeIndexer) IndexField(ctx context.Context, obj client.Object, field string, extract client.IndexerFunc) error {
+	f.field, f.extract = field, extract
+	return nil
+}
+
+func TestIndexHostImages(t *testing.T) {
+	indexer := &fakeIndexer{}
+	if err := IndexHostImages(context.TODO(), indexer); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if indexer.field != hostOwnerIndex {
+		t.Errorf("field = %q, want %q", indexer.field, hostOwnerIndex)
+	}
+
+	image := &metal3.PreprovisioningImage{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test",
+			Name:      "image-0",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: metal3.GroupVersion.String(),
+				Kind:       "BareMetalHost",
+				Name:       "host-0",
+			}},
+		},
+	}
+	if values := indexer.extract(image); !reflect.DeepEqual(values, []string{"host-0"}) {
+		t.Errorf("index values = %v, want [host-0]", values)
+	}
+
+	unowned := &metal3.PreprovisioningImage{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "host-0"}}
+	if values := indexer.extract(unowned); values != nil {
+		t.Errorf("expected no index values for unowned image, got %v", values)
+	}
+}
@@ -0,0 +1,103 @@
+package ignition
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+)
+
+const defaultAgentRestart = "on-failure"
+
+// AgentRuntime sets additional options for running the agent container. The
+// options must have been validated.
+func (b *ignitionBuilder) AgentRuntime(runtime *v1alpha1.AgentRuntimeConfig) {
+	b.agentRuntime = runtime
+}
+
+// systemdArg quotes an argument for an Exec line of a systemd unit, so that
+// it is passed to the command unchanged.
+func systemdArg(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// agentRuntimeArgs returns the additional arguments for podman run.
+func (b *ignitionBuilder) agentRuntimeArgs() string {
+	runtime := b.agentRuntime
+	if runtime == nil {
+		return ""
+	}
+
+	args := []string{}
+	for _, mount := range runtime.Mounts {
+		dst := mount.MountPath
+		if dst == "" {
+			dst = mount.HostPath
+		}
+		option := fmt.Sprintf("type=bind,src=%s,dst=%s", mount.HostPath, dst)
+		if mount.ReadOnly {
+			option += ",ro=true"
+		}
+		args = append(args, "--mount", systemdArg(option))
+	}
+	for _, device := range runtime.Devices {
+		args = append(args, "--device", systemdArg(device))
+	}
+	for _, env := range runtime.Env {
+		args = append(args, "--env", systemdArg(env.Name+"="+env.Value))
+	}
+	switch runtime.PullPolicy {
+	case v1alpha1.PullIfNotPresent:
+		// The image is pulled by podman run, so it needs the same flags
+		// as an explicit pull.
+		args = append(args, "--pull=missing")
+		args = append(args, b.pullFlags()...)
+	case v1alpha1.PullNever:
+		args = append(args, "--pull=never")
+	}
+	for _, flag := range runtime.PodmanFlags {
+		args = append(args, systemdArg(flag))
+	}
+
+	if len(args) == 0 {
+		return ""
+	}
+	return strings.Join(args, " ") + " "
+}
+
+// agentPullsImage returns whether the image is pulled explicitly before the
+// agent container is run.
+func (b *ignitionBuilder) agentPullsImage() bool {
+	return b.agentRuntime == nil || b.agentRuntime.PullPolicy == "" ||
+		b.agentRuntime.PullPolicy == v1alpha1.PullAlways
+}
+
+func (b *ignitionBuilder) agentRestart() string {
+	if b.agentRuntime == nil || b.agentRuntime.Restart == "" {
+		return defaultAgentRestart
+	}
+	return b.agentRuntime.Restart
+}
+
+// agentUnitDependencies returns the additional dependencies of the agent
+// service.
+func (b *ignitionBuilder) agentUnitDependencies() string {
+	deps := ""
+	if len(b.ntpServers) > 0 {
+		deps += fmt.Sprintf("After=%[1]s\nWants=%[1]s\n", ntpWaitUnit)
+	}
+	if b.agentRuntime == nil {
+		return deps
+	}
+	if len(b.agentRuntime.After) > 0 {
+		deps += fmt.Sprintf("After=%s\n", strings.Join(b.agentRuntime.After, " "))
+	}
+	if len(b.agentRuntime.Requires) > 0 {
+		deps += fmt.Sprintf("Requires=%s\n", strings.Join(b.agentRuntime.Requires, " "))
+	}
+	return deps
+}
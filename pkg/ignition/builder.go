@@ -1,14 +1,20 @@
 package ignition
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os/exec"
 	"strings"
+	"time"
 
 	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
 	vpath "github.com/coreos/vcontext/path"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/pullsecret"
+	"github.com/openshift/image-customization-controller/pkg/registries"
 )
 
 const (
@@ -26,13 +32,30 @@ type ignitionBuilder struct {
 	ironicInspectorBaseURL string
 	ironicAgentImage       string
 	ironicAgentPullSecret  string
+	authFileContents       []byte
 	ironicRAMDiskSSHKey    string
 	networkKeyFiles        []byte
+	interfaceMACs          map[string]string
 	ipOptions              string
 	httpProxy              string
 	httpsProxy             string
 	noProxy                string
 	hostname               string
+	progressURL            string
+	progressToken          string
+	journalUploadURL       string
+	debugPasswordHash      string
+	sshHostKeys            map[string][]byte
+	agentRuntime           *v1alpha1.AgentRuntimeConfig
+	inspectionMode         v1alpha1.InspectionMode
+	ntpServers             []string
+	networkFallbackTimeout time.Duration
+	networkFiles           map[string][]byte
+	hostnameSources        []v1alpha1.HostnameSource
+	hostnames              map[v1alpha1.HostnameSource]string
+	containerTrust         map[string][]byte
+	registriesDropins      map[string][]byte
+	registryCerts          map[string][]byte
 }
 
 func New(nmStateData, registriesConf []byte, ironicBaseURL, ironicInspectorBaseURL, ironicAgentImage, ironicAgentPullSecret, ironicRAMDiskSSHKey, ipOptions string, httpProxy, httpsProxy, noProxy string, hostname string) (*ignitionBuilder, error) {
@@ -45,6 +68,21 @@ func New(nmStateData, registriesConf []byte, ironicBaseURL, ironicInspectorBaseU
 	if ironicAgentImage == "" {
 		return nil, errors.New("ironicAgentImage is required")
 	}
+	if len(registriesConf) > 0 {
+		if _, err := registries.Parse(registriesConf); err != nil {
+			return nil, fmt.Errorf("invalid registries.conf: %w", err)
+		}
+	}
+	var authFileContents []byte
+	if ironicAgentPullSecret != "" {
+		authFile, err := pullsecret.Parse([]byte(ironicAgentPullSecret))
+		if err != nil {
+			return nil, err
+		}
+		if authFileContents, err = authFile.JSON(); err != nil {
+			return nil, err
+		}
+	}
 
 	return &ignitionBuilder{
 		nmStateData:            nmStateData,
@@ -53,45 +91,58 @@ func New(nmStateData, registriesConf []byte, ironicBaseURL, ironicInspectorBaseU
 		ironicInspectorBaseURL: ironicInspectorBaseURL,
 		ironicAgentImage:       ironicAgentImage,
 		ironicAgentPullSecret:  ironicAgentPullSecret,
+		authFileContents:       authFileContents,
 		ironicRAMDiskSSHKey:    ironicRAMDiskSSHKey,
 		ipOptions:              ipOptions,
 		httpProxy:              httpProxy,
 		httpsProxy:             httpsProxy,
 		noProxy:                noProxy,
 		hostname:               hostname,
+		registriesDropins:      map[string][]byte{},
 	}, nil
 }
 
-func (b *ignitionBuilder) ProcessNetworkState() (error, string) {
+// MatchInterfacesByMAC causes the network configuration for the given
+// ethernet interfaces to match devices by MAC address instead of by name.
+func (b *ignitionBuilder) MatchInterfacesByMAC(macs map[string]string) {
+	b.interfaceMACs = macs
+}
+
+// ProcessNetworkState converts the NMState data to NetworkManager keyfiles.
+// If the data is invalid, the returned error is an *NMStateError. The
+// nmstatectl process is killed if the context expires before it completes.
+func (b *ignitionBuilder) ProcessNetworkState(ctx context.Context) error {
 	if len(b.nmStateData) > 0 {
-		nmstatectl := exec.Command("nmstatectl", "gc", "/dev/stdin")
+		nmstatectl := exec.CommandContext(ctx, "nmstatectl", "gc", "/dev/stdin")
 		nmstatectl.Stdin = strings.NewReader(string(b.nmStateData))
 		out, err := nmstatectl.Output()
 		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("nmstatectl did not complete: %w", ctx.Err())
+			}
 			if ee, ok := err.(*exec.ExitError); ok {
-				return err, string(ee.Stderr)
+				return parseNMStateError(string(ee.Stderr), b.nmStateData)
 			}
-			return err, ""
+			return err
 		}
 		if string(out) == "--- {}\n" {
-			return nil, "no network configuration"
+			return &NMStateError{Message: "no network configuration"}
 		}
 		b.networkKeyFiles = out
 	}
-	return nil, ""
+	return nil
 }
 
 func (b *ignitionBuilder) GenerateConfig() (config ignition_config_types_32.Config, err error) {
 	netFiles := []ignition_config_types_32.File{}
 	if len(b.nmStateData) > 0 {
-		nmstatectl := exec.Command("nmstatectl", "gc", "/dev/stdin")
-		nmstatectl.Stdin = strings.NewReader(string(b.nmStateData))
-		out, err := nmstatectl.Output()
-		if err != nil {
-			return config, err
+		if b.networkKeyFiles == nil {
+			if err := b.ProcessNetworkState(context.Background()); err != nil {
+				return config, err
+			}
 		}
 
-		netFiles, err = nmstateOutputToFiles(out)
+		netFiles, err = nmstateOutputToFiles(b.networkKeyFiles, b.interfaceMACs)
 		if err != nil {
 			return config, err
 		}
@@ -100,19 +151,48 @@ func (b *ignitionBuilder) GenerateConfig() (config ignition_config_types_32.Conf
 	config.Ignition.Version = "3.2.0"
 	config.Storage.Files = []ignition_config_types_32.File{b.IronicAgentConf()}
 	config.Storage.Files = append(config.Storage.Files, netFiles...)
+	config.Storage.Files = append(config.Storage.Files, b.networkFileEntries()...)
 	config.Systemd.Units = []ignition_config_types_32.Unit{b.IronicAgentService(len(netFiles) > 0)}
 
+	if b.networkFallbackEnabled(len(netFiles) > 0) {
+		config.Storage.Files = append(config.Storage.Files, ignitionFileEmbed(networkFallbackScriptPath,
+			0755, false, []byte(networkFallbackScript)))
+		config.Systemd.Units = append(config.Systemd.Units, b.networkFallbackService())
+	}
+
+	if b.progressURL != "" {
+		config.Storage.Files = append(config.Storage.Files, b.progressFiles()...)
+		config.Systemd.Units = append(config.Systemd.Units, b.progressUnits()...)
+	}
+
+	if b.journalUploadURL != "" {
+		config.Systemd.Units = append(config.Systemd.Units, b.journalUploadService())
+	}
+
+	if len(b.ntpServers) > 0 {
+		config.Storage.Files = append(config.Storage.Files, b.chronyConf())
+		config.Systemd.Units = append(config.Systemd.Units, ntpWaitService())
+	}
+
+	if b.debugPasswordHash != "" {
+		config.Systemd.Units = append(config.Systemd.Units, b.debugUnits()...)
+	}
+
 	if b.ironicAgentPullSecret != "" {
 		config.Storage.Files = append(config.Storage.Files, b.authFile())
 	}
 
-	if b.ironicRAMDiskSSHKey != "" {
-		config.Passwd.Users = append(config.Passwd.Users, ignition_config_types_32.PasswdUser{
-			Name: "core",
-			SSHAuthorizedKeys: []ignition_config_types_32.SSHAuthorizedKey{
-				ignition_config_types_32.SSHAuthorizedKey(strings.TrimSpace(b.ironicRAMDiskSSHKey)),
-			},
-		})
+	if len(b.sshHostKeys) > 0 {
+		config.Storage.Files = append(config.Storage.Files, b.sshHostKeyFiles()...)
+	}
+
+	if b.ironicRAMDiskSSHKey != "" || b.debugPasswordHash != "" {
+		core := ignition_config_types_32.PasswdUser{Name: "core"}
+		core.SSHAuthorizedKeys = authorizedKeys(b.ironicRAMDiskSSHKey)
+		if b.debugPasswordHash != "" {
+			core.PasswordHash = &b.debugPasswordHash
+		}
+		config.Passwd.Users = append(config.Passwd.Users, core)
 	}
 
 	config.Storage.Files = append(config.Storage.Files, ignitionFileEmbed(
@@ -120,25 +200,16 @@ func (b *ignitionBuilder) GenerateConfig() (config ignition_config_types_32.Conf
 		0644, false,
 		[]byte("[connection]\nipv6.dhcp-duid=ll\nipv6.dhcp-iaid=mac")))
 
-	if b.hostname != "" {
-		update_hostname := fmt.Sprintf(`
-	    [[ "$DHCP6_FQDN_FQDN" =~ "." ]] && hostnamectl set-hostname --static --transient $DHCP6_FQDN_FQDN 
-	    [[ "$(< /proc/sys/kernel/hostname)" =~ (localhost|localhost.localdomain) ]] && hostnamectl set-hostname --transient %s`, b.hostname)
-
-		config.Storage.Files = append(config.Storage.Files, ignitionFileEmbed(
-			"/etc/NetworkManager/dispatcher.d/01-hostname",
-			0744, false,
-			[]byte(update_hostname)))
+	if hostnameFile := b.hostnameFile(); hostnameFile != nil {
+		config.Storage.Files = append(config.Storage.Files, *hostnameFile)
 	}
 
-	if len(b.registriesConf) > 0 {
-		registriesFile := ignitionFileEmbed("/etc/containers/registries.conf",
-			0644, true,
-			b.registriesConf)
-
-		config.Storage.Files = append(config.Storage.Files, registriesFile)
+	if len(b.containerTrust) > 0 {
+		config.Storage.Files = append(config.Storage.Files, b.containerTrustFiles()...)
 	}
 
+	config.Storage.Files = append(config.Storage.Files, b.registriesFiles()...)
+
 	report := config.Storage.Validate(vpath.ContextPath{})
 	if report.IsFatal() {
 		return config, errors.New(report.String())
@@ -147,6 +218,20 @@ func (b *ignitionBuilder) GenerateConfig() (config ignition_config_types_32.Conf
 	return config, nil
 }
 
+// authorizedKeys returns each of the public keys in data, which is in
+// authorized_keys format.
+func authorizedKeys(data string) []ignition_config_types_32.SSHAuthorizedKey {
+	var keys []ignition_config_types_32.SSHAuthorizedKey
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, ignition_config_types_32.SSHAuthorizedKey(line))
+	}
+	return keys
+}
+
 func (b *ignitionBuilder) Generate() ([]byte, error) {
 	config, err := b.GenerateConfig()
 	if err != nil {
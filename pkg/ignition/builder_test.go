@@ -3,8 +3,11 @@ package ignition
 import (
 	"strings"
 	"testing"
+	"time"
 
+	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/vincent-petithory/dataurl"
 )
 
 func TestGenerateStructure(t *testing.T) {
@@ -30,10 +33,10 @@ func TestGenerateStructure(t *testing.T) {
 }
 
 func TestGenerateWithMoreFields(t *testing.T) {
-	builder, err := New(nil, []byte("I am registry"),
+	builder, err := New(nil, []byte("unqualified-search-registries = [\"quay.io\"]"),
 		"http://ironic.example.com", "http://inspector.example.com",
 		"quay.io/openshift-release-dev/ironic-ipa-image",
-		"pull secret", "SSH key", "ip=dhcp42",
+		`{"auths":{"quay.io":{"auth":"Zm9vOmJhcg=="}}}`, "SSH key", "ip=dhcp42",
 		"proxy me", "", "don't proxy me", "my-host")
 	assert.NoError(t, err)
 
@@ -85,3 +88,167 @@ func TestGenerateRegistries(t *testing.T) {
 		t.Fatalf("Registries data not found in ignition:\n%s", string(ignition))
 	}
 }
+
+func TestGenerateWithProgress(t *testing.T) {
+	builder, err := New(nil, nil,
+		"http://ironic.example.com", "",
+		"quay.io/openshift-release-dev/ironic-ipa-image",
+		"", "", "", "", "", "", "")
+	assert.NoError(t, err)
+	builder.ReportProgress("http://images.example.com/progress/test/host-0", "token")
+
+	ignition, err := builder.GenerateConfig()
+	assert.NoError(t, err)
+
+	assert.Len(t, ignition.Systemd.Units, 3)
+	assert.Contains(t, *ignition.Systemd.Units[0].Contents, "After=boot-progress-network.service")
+	assert.Contains(t, *ignition.Systemd.Units[0].Contents, "ExecStopPost=-/usr/local/bin/report-boot-progress agent-exited")
+	assert.Equal(t, "boot-progress-initramfs.service", ignition.Systemd.Units[1].Name)
+	assert.Equal(t, "boot-progress-network.service", ignition.Systemd.Units[2].Name)
+	assert.Contains(t, *ignition.Systemd.Units[2].Contents, "TimeoutStartSec=20")
+	assert.Equal(t, "/etc/boot-progress.env", ignition.Storage.Files[1].Path)
+	assert.Contains(t, *ignition.Storage.Files[1].Contents.Source, "PROGRESS_TOKEN%3Dtoken")
+	assert.Equal(t, 0600, *ignition.Storage.Files[1].Mode)
+	assert.Equal(t, "/usr/local/bin/report-boot-progress", ignition.Storage.Files[2].Path)
+	assert.Contains(t, progressScript, "--connect-timeout 5 -m 10")
+}
+
+func TestGenerateWithJournalUpload(t *testing.T) {
+	builder, err := New(nil, nil,
+		"http://ironic.example.com", "",
+		"quay.io/openshift-release-dev/ironic-ipa-image",
+		"", "", "", "", "", "", "")
+	assert.NoError(t, err)
+	builder.UploadJournal("http://images.example.com/logs/test/host-0/token")
+
+	ignition, err := builder.GenerateConfig()
+	assert.NoError(t, err)
+
+	assert.Len(t, ignition.Systemd.Units, 2)
+	assert.Equal(t, "ramdisk-journal-upload.service", ignition.Systemd.Units[1].Name)
+	assert.Contains(t, *ignition.Systemd.Units[1].Contents, `Environment="UPLOAD_URL=http://images.example.com/logs/test/host-0/token"`)
+	assert.Contains(t, *ignition.Systemd.Units[1].Contents, "journalctl -b -o export")
+}
+
+func TestGenerateWithDebug(t *testing.T) {
+	builder, err := New(nil, nil,
+		"http://ironic.example.com", "",
+		"quay.io/openshift-release-dev/ironic-ipa-image",
+		"", "ssh-rsa AAAA", "", "", "", "", "")
+	assert.NoError(t, err)
+	builder.Debug("$6$salt$hash")
+
+	ignition, err := builder.GenerateConfig()
+	assert.NoError(t, err)
+
+	assert.Contains(t, *ignition.Storage.Files[0].Contents.Source, "debug%20%3D%20True")
+	assert.Len(t, ignition.Systemd.Units, 4)
+	assert.Equal(t, "getty@.service", ignition.Systemd.Units[1].Name)
+	assert.Contains(t, *ignition.Systemd.Units[1].Dropins[0].Contents, "--autologin core")
+	assert.Equal(t, "serial-getty@.service", ignition.Systemd.Units[2].Name)
+	assert.Equal(t, "ramdisk-diagnostics.service", ignition.Systemd.Units[3].Name)
+	assert.Contains(t, *ignition.Systemd.Units[3].Contents, `"IRONIC_URL=http://ironic.example.com:6385"`)
+	assert.Contains(t, *ignition.Systemd.Units[3].Contents, "%%{http_code}")
+
+	assert.Len(t, ignition.Passwd.Users, 1)
+	assert.Equal(t, "core", ignition.Passwd.Users[0].Name)
+	assert.Equal(t, "$6$salt$hash", *ignition.Passwd.Users[0].PasswordHash)
+	assert.Len(t, ignition.Passwd.Users[0].SSHAuthorizedKeys, 1)
+}
+
+func TestGenerateWithSSHKeys(t *testing.T) {
+	builder, err := New(nil, nil,
+		"http://ironic.example.com", "",
+		"quay.io/openshift-release-dev/ironic-ipa-image",
+		"", "ssh-rsa AAAA global\n\n# tenant\nssh-ed25519 BBBB tenant\n", "", "", "", "", "")
+	assert.NoError(t, err)
+	builder.SSHHostKeys(map[string][]byte{
+		"ssh_host_ed25519_key.pub": []byte("ssh-ed25519 CCCC"),
+		"ssh_host_ed25519_key":     []byte("private"),
+	})
+
+	ignition, err := builder.GenerateConfig()
+	assert.NoError(t, err)
+
+	assert.Len(t, ignition.Passwd.Users, 1)
+	assert.Equal(t, []ignition_config_types_32.SSHAuthorizedKey{"ssh-rsa AAAA global", "ssh-ed25519 BBBB tenant"},
+		ignition.Passwd.Users[0].SSHAuthorizedKeys)
+
+	assert.Equal(t, "/etc/ssh/ssh_host_ed25519_key", ignition.Storage.Files[1].Path)
+	assert.Equal(t, 0600, *ignition.Storage.Files[1].Mode)
+	assert.Equal(t, "/etc/ssh/ssh_host_ed25519_key.pub", ignition.Storage.Files[2].Path)
+	assert.Equal(t, 0644, *ignition.Storage.Files[2].Mode)
+}
+
+func TestGenerateWithNTPServers(t *testing.T) {
+	builder, err := New(nil, nil,
+		"http://ironic.example.com", "",
+		"quay.io/openshift-release-dev/ironic-ipa-image",
+		"", "", "", "", "", "", "")
+	assert.NoError(t, err)
+	builder.NTPServers([]string{"ntp1.example.com", "192.0.2.1"})
+
+	ignition, err := builder.GenerateConfig()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/etc/chrony.conf", ignition.Storage.Files[1].Path)
+	assert.Contains(t, *ignition.Storage.Files[1].Contents.Source,
+		"server%20ntp1.example.com%20iburst%0Aserver%20192.0.2.1%20iburst%0A")
+	assert.True(t, *ignition.Storage.Files[1].Overwrite)
+
+	assert.Len(t, ignition.Systemd.Units, 2)
+	assert.Contains(t, *ignition.Systemd.Units[0].Contents, "After=chrony-wait.service\nWants=chrony-wait.service\n")
+	assert.Equal(t, "chrony-wait.service", ignition.Systemd.Units[1].Name)
+	assert.Contains(t, *ignition.Systemd.Units[1].Contents, "ExecStart=-/usr/bin/chronyc waitsync 60 0 0 2\n")
+}
+
+func TestGenerateWithNetworkFallback(t *testing.T) {
+	builder, err := New([]byte("interfaces: []"), nil,
+		"http://ironic.example.com", "",
+		"quay.io/openshift-release-dev/ironic-ipa-image",
+		"", "", "", "", "", "", "")
+	assert.NoError(t, err)
+	builder.networkKeyFiles = []byte(`---
+NetworkManager:
+- - eth0.nmconnection
+  - "[connection]\nid=eth0\n"
+`)
+	builder.NetworkFallback(10 * time.Minute)
+
+	ignition, err := builder.GenerateConfig()
+	assert.NoError(t, err)
+
+	agent := *ignition.Systemd.Units[0].Contents
+	assert.Contains(t, agent, "EnvironmentFile=-/run/network-fallback.env\n")
+	assert.Contains(t, agent, "--env IPA_NETWORK_FALLBACK --name ironic-agent")
+	assert.Len(t, ignition.Systemd.Units, 2)
+	assert.Equal(t, "network-fallback.service", ignition.Systemd.Units[1].Name)
+	assert.Contains(t, *ignition.Systemd.Units[1].Contents,
+		`Environment="IRONIC_URL=http://ironic.example.com:6385" "FALLBACK_TIMEOUT=600"`)
+	assert.Equal(t, "/usr/local/bin/network-fallback", ignition.Storage.Files[2].Path)
+
+	// The fallback connections exist only in memory
+	script, err := dataurl.DecodeString(*ignition.Storage.Files[2].Contents.Source)
+	assert.NoError(t, err)
+	for _, line := range strings.Split(string(script.Data), "\n") {
+		if strings.Contains(line, "nmcli connection add") {
+			assert.Contains(t, line, " save no ")
+		}
+	}
+	assert.Contains(t, string(script.Data), "nmcli connection add")
+}
+
+func TestGenerateWithNetworkFallbackNoStaticNetwork(t *testing.T) {
+	builder, err := New(nil, nil,
+		"http://ironic.example.com", "",
+		"quay.io/openshift-release-dev/ironic-ipa-image",
+		"", "", "", "", "", "", "")
+	assert.NoError(t, err)
+	builder.NetworkFallback(10 * time.Minute)
+
+	ignition, err := builder.GenerateConfig()
+	assert.NoError(t, err)
+
+	assert.Len(t, ignition.Systemd.Units, 1)
+	assert.NotContains(t, *ignition.Systemd.Units[0].Contents, "IPA_NETWORK_FALLBACK")
+}
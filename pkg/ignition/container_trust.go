@@ -0,0 +1,131 @@
+package ignition
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
+)
+
+const (
+	containerPolicyFile = "policy.json"
+	containerPolicyDir  = "/etc/containers"
+	registriesDDir      = "/etc/containers/registries.d"
+	containerKeysDir    = "/etc/pki/containers"
+)
+
+// containerPolicy is the part of a containers-policy.json(5) file that
+// determines whether images must be signed.
+type containerPolicy struct {
+	Default    []policyRequirement                       `json:"default"`
+	Transports map[string]map[string][]policyRequirement `json:"transports"`
+}
+
+type policyRequirement struct {
+	Type string `json:"type"`
+}
+
+// ContainerTrust causes the ramdisk to verify the agent image according to a
+// container trust policy. The files are indexed by name: policy.json is
+// installed as /etc/containers/policy.json, YAML files configuring signature
+// storage in /etc/containers/registries.d, and all others (the public keys
+// referenced by the policy) in /etc/pki/containers. TLS verification is
+// enabled when pulling the agent image. An error is returned if the policy
+// cannot be parsed, rejects the agent image, or requires it to be signed
+// while it is not referenced by digest.
+func (b *ignitionBuilder) ContainerTrust(files map[string][]byte) error {
+	if data, exists := files[containerPolicyFile]; exists {
+		policy := &containerPolicy{}
+		if err := json.Unmarshal(data, policy); err != nil {
+			return fmt.Errorf("invalid container policy: %w", err)
+		}
+		if err := checkAgentImagePolicy(policy, b.ironicAgentImage); err != nil {
+			return err
+		}
+	}
+	b.containerTrust = files
+	return nil
+}
+
+// checkAgentImagePolicy checks that the agent image can be pulled under a
+// container policy.
+func checkAgentImagePolicy(policy *containerPolicy, image string) error {
+	for _, requirement := range policyRequirements(policy, image) {
+		switch requirement.Type {
+		case "reject":
+			return fmt.Errorf("container policy rejects agent image %s", image)
+		case "signedBy", "sigstoreSigned":
+			if !strings.Contains(image, "@") {
+				return fmt.Errorf("container policy requires agent image %s to be signed, so it must be referenced by digest", image)
+			}
+		}
+	}
+	return nil
+}
+
+// policyRequirements returns the requirements of a container policy that
+// apply to an image, which are those of the most specific matching scope for
+// the docker transport.
+func policyRequirements(policy *containerPolicy, image string) []policyRequirement {
+	scopes := policy.Transports["docker"]
+	for _, scope := range imageScopes(image) {
+		if requirements, exists := scopes[scope]; exists {
+			return requirements
+		}
+	}
+	return policy.Default
+}
+
+// imageScopes returns the scopes that an image reference can match in a
+// container policy, from the most to the least specific.
+func imageScopes(image string) []string {
+	image = strings.TrimPrefix(image, "docker://")
+	scopes := []string{image}
+
+	repository := image
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository = repository[:i]
+	}
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+	for scope := repository; ; scope = path.Dir(scope) {
+		if scope != image {
+			scopes = append(scopes, scope)
+		}
+		if !strings.Contains(scope, "/") {
+			break
+		}
+	}
+
+	// Wildcard scopes match subdomains of the registry
+	host := strings.Split(strings.SplitN(repository, "/", 2)[0], ":")[0]
+	for labels := strings.Split(host, "."); len(labels) > 1; labels = labels[1:] {
+		scopes = append(scopes, "*."+strings.Join(labels[1:], "."))
+	}
+	return append(scopes, "")
+}
+
+func (b *ignitionBuilder) containerTrustFiles() []ignition_config_types_32.File {
+	names := make([]string, 0, len(b.containerTrust))
+	for name := range b.containerTrust {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := make([]ignition_config_types_32.File, 0, len(names))
+	for _, name := range names {
+		dir := containerKeysDir
+		switch {
+		case name == containerPolicyFile:
+			dir = containerPolicyDir
+		case strings.HasSuffix(name, ".yaml"):
+			dir = registriesDDir
+		}
+		files = append(files, ignitionFileEmbed(path.Join(dir, name), 0644, true, b.containerTrust[name]))
+	}
+	return files
+}
@@ -0,0 +1,87 @@
+package ignition
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const signedPolicy = `{
+    "default": [{"type": "insecureAcceptAnything"}],
+    "transports": {
+        "docker": {
+            "quay.io/openshift-release-dev": [
+                {"type": "sigstoreSigned", "keyPath": "/etc/pki/containers/release.pub"}
+            ],
+            "registry.example.com/blocked": [{"type": "reject"}]
+        }
+    }
+}`
+
+func TestImageScopes(t *testing.T) {
+	assert.Equal(t, []string{
+		"quay.io/openshift-release-dev/ironic-ipa-image:latest",
+		"quay.io/openshift-release-dev/ironic-ipa-image",
+		"quay.io/openshift-release-dev",
+		"quay.io",
+		"*.io",
+		"",
+	}, imageScopes("quay.io/openshift-release-dev/ironic-ipa-image:latest"))
+
+	assert.Equal(t, []string{
+		"registry.example.com:5000/ipa@sha256:0123",
+		"registry.example.com:5000/ipa",
+		"registry.example.com:5000",
+		"*.example.com",
+		"*.com",
+		"",
+	}, imageScopes("registry.example.com:5000/ipa@sha256:0123"))
+}
+
+func TestContainerTrust(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		image string
+		valid bool
+	}{
+		{name: "signed by digest", image: "quay.io/openshift-release-dev/ironic-ipa-image@sha256:0123", valid: true},
+		{name: "signed by tag", image: "quay.io/openshift-release-dev/ironic-ipa-image:latest", valid: false},
+		{name: "unsigned", image: "registry.example.com/ipa:latest", valid: true},
+		{name: "rejected", image: "registry.example.com/blocked/ipa@sha256:0123", valid: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &ignitionBuilder{ironicAgentImage: tc.image}
+			err := b.ContainerTrust(map[string][]byte{"policy.json": []byte(signedPolicy)})
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	b := &ignitionBuilder{ironicAgentImage: "quay.io/ipa"}
+	assert.Error(t, b.ContainerTrust(map[string][]byte{"policy.json": []byte("{")}))
+}
+
+func TestGenerateWithContainerTrust(t *testing.T) {
+	builder, err := New(nil, nil,
+		"http://ironic.example.com", "",
+		"quay.io/openshift-release-dev/ironic-ipa-image@sha256:0123",
+		"", "", "", "", "", "", "")
+	assert.NoError(t, err)
+	assert.NoError(t, builder.ContainerTrust(map[string][]byte{
+		"policy.json":  []byte(signedPolicy),
+		"quay.io.yaml": []byte("docker:\n  quay.io:\n    use-sigstore-attachments: true\n"),
+		"release.pub":  []byte("KEY"),
+	}))
+
+	ignition, err := builder.GenerateConfig()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/etc/containers/policy.json", ignition.Storage.Files[2].Path)
+	assert.Equal(t, "/etc/containers/registries.d/quay.io.yaml", ignition.Storage.Files[3].Path)
+	assert.Equal(t, "/etc/pki/containers/release.pub", ignition.Storage.Files[4].Path)
+	assert.Contains(t, *ignition.Systemd.Units[0].Contents,
+		"ExecStartPre=/bin/podman pull quay.io/openshift-release-dev/ironic-ipa-image@sha256:0123\n")
+}
@@ -0,0 +1,81 @@
+package ignition
+
+import (
+	"fmt"
+
+	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
+	"k8s.io/utils/pointer"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+)
+
+const debugDiagnosticsUnit = "ramdisk-diagnostics.service"
+
+// Debug configures the ramdisk for interactive debugging: the core user is
+// logged in automatically on the consoles and can log in elsewhere with the
+// password matching the given crypt(3) hash, the agent logs verbosely, and
+// the state of the network is printed to the console once it is online.
+func (b *ignitionBuilder) Debug(passwordHash string) {
+	b.debugPasswordHash = passwordHash
+}
+
+// autologinDropin replaces the command line of a getty service so that the
+// core user is logged in without a password.
+func autologinDropin(unit, agettyArgs string) ignition_config_types_32.Unit {
+	contents := fmt.Sprintf(`[Service]
+ExecStart=
+ExecStart=-/sbin/agetty --autologin core %s
+`, agettyArgs)
+
+	return ignition_config_types_32.Unit{
+		Name: unit,
+		Dropins: []ignition_config_types_32.Dropin{{
+			Name:     "autologin-core.conf",
+			Contents: &contents,
+		}},
+	}
+}
+
+// diagnosticsService prints the addresses, routes and DNS configuration of
+// the ramdisk, and whether the Ironic APIs can be reached, to the console
+// once the network is online.
+func (b *ignitionBuilder) diagnosticsService() ignition_config_types_32.Unit {
+	inspectorURL := ""
+	if b.inspectionMode == "" || b.inspectionMode == v1alpha1.InspectionModeInspector {
+		inspectorURL = b.ironicInspectorURL()
+	}
+	contents := fmt.Sprintf(`[Unit]
+Description=Print ramdisk network diagnostics
+After=network-online.target
+Wants=network-online.target
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+StandardOutput=journal+console
+StandardError=journal+console
+Environment="IRONIC_URL=%s" "INSPECTOR_URL=%s"
+ExecStart=/bin/bash -c 'echo "=== Addresses ==="; ip -br addr; \
+    echo "=== Routes ==="; ip route; ip -6 route; \
+    echo "=== DNS ==="; cat /etc/resolv.conf; \
+    for url in $$IRONIC_URL $$INSPECTOR_URL; do \
+        echo "=== $$url ==="; \
+        curl -sk -o /dev/null -m 10 -w "HTTP %%%%{http_code} in %%%%{time_total}s\\n" "$$url" || echo "unreachable"; \
+    done'
+[Install]
+WantedBy=multi-user.target
+`, b.ironicAPIURL(), inspectorURL)
+
+	return ignition_config_types_32.Unit{
+		Name:     debugDiagnosticsUnit,
+		Enabled:  pointer.BoolPtr(true),
+		Contents: &contents,
+	}
+}
+
+func (b *ignitionBuilder) debugUnits() []ignition_config_types_32.Unit {
+	return []ignition_config_types_32.Unit{
+		autologinDropin("getty@.service", "--noclear %I $TERM"),
+		autologinDropin("serial-getty@.service", "--keep-baud 115200,57600,38400,9600 %I $TERM"),
+		b.diagnosticsService(),
+	}
+}
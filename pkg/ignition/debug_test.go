@@ -0,0 +1,22 @@
+package ignition
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateWithDebugResolvedURLs(t *testing.T) {
+	builder, err := New(nil, nil,
+		"https://192.0.2.1:6385", "https://192.0.2.2:5050",
+		"quay.io/openshift-release-dev/ironic-ipa-image",
+		"", "", "", "", "", "", "")
+	assert.NoError(t, err)
+	builder.Debug("$6$salt$hash")
+
+	ignition, err := builder.GenerateConfig()
+	assert.NoError(t, err)
+
+	assert.Contains(t, *ignition.Systemd.Units[3].Contents,
+		`Environment="IRONIC_URL=https://192.0.2.1:6385" "INSPECTOR_URL=https://192.0.2.2:5050"`)
+}
@@ -0,0 +1,54 @@
+package ignition
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+
+	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/vincent-petithory/dataurl"
+)
+
+const networkKeyfileDir = "/etc/NetworkManager/system-connections/"
+
+// FirstbootKernelArgs returns the kernel arguments that configure the network
+// in the initramfs. When there are keyfiles from NetworkKeyfiles, the network
+// is brought up using them. No ip= argument is added then, since CoreOS
+// replaces any connections generated from it with the keyfiles. Otherwise the
+// IP options passed to the agent are applied, so that the initramfs uses the
+// same addressing as the installed host.
+func FirstbootKernelArgs(keyfiles map[string][]byte, ipOptions string) string {
+	if len(keyfiles) > 0 {
+		return "rd.neednet=1"
+	}
+	return ipOptions
+}
+
+// NetworkKeyfiles returns the NetworkManager keyfiles generated from the
+// nmstate network data in an ignition config, indexed by file name. They are
+// needed in the initramfs, which Ignition does not write to, for the host to
+// have a static IP before switching to the real root.
+func NetworkKeyfiles(ignitionConfig []byte) (map[string][]byte, error) {
+	config := ignition_config_types_32.Config{}
+	if err := json.Unmarshal(ignitionConfig, &config); err != nil {
+		return nil, err
+	}
+
+	keyfiles := map[string][]byte{}
+	for _, file := range config.Storage.Files {
+		if !strings.HasPrefix(file.Path, networkKeyfileDir) || file.Contents.Source == nil {
+			continue
+		}
+		data, err := dataurl.DecodeString(*file.Contents.Source)
+		if err != nil {
+			return nil, err
+		}
+		// The certificates referenced by 802.1X connections are written only
+		// by Ignition, so those connections cannot be used in the initramfs
+		if keyfileHasSection(string(data.Data), "802-1x") {
+			continue
+		}
+		keyfiles[path.Base(file.Path)] = data.Data
+	}
+	return keyfiles, nil
+}
@@ -0,0 +1,51 @@
+package ignition
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkKeyfiles(t *testing.T) {
+	builder, err := New([]byte("interfaces: []"), nil,
+		"http://ironic.example.com", "",
+		"quay.io/openshift-release-dev/ironic-ipa-image",
+		"", "", "", "", "", "", "")
+	assert.NoError(t, err)
+	builder.networkKeyFiles = []byte(`---
+NetworkManager:
+- - eth0.nmconnection
+  - "[connection]\nid=eth0\n"
+- - eth1.nmconnection
+  - "[connection]\nid=eth1\n\n[802-1x]\nca-cert=/etc/pki/802-1x/ca.pem\n"
+`)
+
+	ignition, err := builder.Generate()
+	assert.NoError(t, err)
+
+	keyfiles, err := NetworkKeyfiles(ignition)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"eth0.nmconnection": []byte("[connection]\nid=eth0\n")}, keyfiles)
+}
+
+func TestNetworkKeyfilesNone(t *testing.T) {
+	builder, err := New(nil, nil,
+		"http://ironic.example.com", "",
+		"quay.io/openshift-release-dev/ironic-ipa-image",
+		"", "", "", "", "", "", "")
+	assert.NoError(t, err)
+
+	ignition, err := builder.Generate()
+	assert.NoError(t, err)
+
+	keyfiles, err := NetworkKeyfiles(ignition)
+	assert.NoError(t, err)
+	assert.Empty(t, keyfiles)
+}
+
+func TestFirstbootKernelArgs(t *testing.T) {
+	keyfiles := map[string][]byte{"eth0.nmconnection": []byte("[connection]\nid=eth0\n")}
+	assert.Equal(t, "rd.neednet=1", FirstbootKernelArgs(keyfiles, "ip=dhcp6"))
+	assert.Equal(t, "ip=dhcp6", FirstbootKernelArgs(nil, "ip=dhcp6"))
+	assert.Equal(t, "", FirstbootKernelArgs(nil, ""))
+}
@@ -0,0 +1,117 @@
+package ignition
+
+import (
+	"fmt"
+	"strings"
+
+	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+)
+
+const (
+	hostnameDispatcherPath = "/etc/NetworkManager/dispatcher.d/01-hostname"
+	hostnameEnvPath        = "/run/ironic-agent-hostname.env"
+)
+
+// hostnameScriptHeader defines the functions used to set the hostname. A
+// name from the network replaces any existing hostname, while a default name
+// is used only if no other hostname has been set. The chosen name is saved
+// for the agent's IPA_DEFAULT_HOSTNAME.
+const hostnameScriptHeader = `#!/bin/bash
+# Sets the hostname from the first source that provides one
+
+set_hostname() {
+    hostnamectl set-hostname --static --transient "$1"
+    echo "IPA_DEFAULT_HOSTNAME=$1" > ` + hostnameEnvPath + `
+    exit 0
+}
+
+set_default_hostname() {
+    if [[ "$(< /proc/sys/kernel/hostname)" =~ ^(localhost|localhost\.localdomain)$ ]]; then
+        hostnamectl set-hostname --transient "$1"
+        echo "IPA_DEFAULT_HOSTNAME=$1" > ` + hostnameEnvPath + `
+    fi
+    exit 0
+}
+`
+
+// hostnameSourceScripts are the parts of the script that set the hostname
+// from each of the sources on the network, using the variables that
+// NetworkManager passes to dispatcher scripts.
+var hostnameSourceScripts = map[v1alpha1.HostnameSource]string{
+	v1alpha1.HostnameSourceDHCPv4: `
+name="${DHCP4_FQDN_FQDN:-$DHCP4_HOST_NAME}"
+[ -n "$name" ] && set_hostname "$name"
+`,
+	v1alpha1.HostnameSourceDHCPv6: `
+[[ "$DHCP6_FQDN_FQDN" =~ "." ]] && set_hostname "$DHCP6_FQDN_FQDN"
+`,
+	v1alpha1.HostnameSourceReverseDNS: `
+for address in "${IP4_ADDRESS_0%%/*}" "${IP6_ADDRESS_0%%/*}"; do
+    [ -n "$address" ] || continue
+    name="$(getent hosts "$address" | awk '{ print $2; exit }')"
+    [[ "$name" =~ "." ]] && set_hostname "$name"
+done
+`,
+}
+
+// HostnameSources sets the sources of the hostname, in priority order. The
+// names give the hostname for each of the sources that are known when the
+// image is built (BareMetalHost, PreprovisioningImage and Template); those
+// without a name are skipped. When the sources are not set, the DHCPv6 FQDN
+// is used if there is one, and the hostname passed to New otherwise.
+func (b *ignitionBuilder) HostnameSources(sources []v1alpha1.HostnameSource, names map[v1alpha1.HostnameSource]string) {
+	b.hostnameSources = sources
+	b.hostnames = names
+}
+
+// hostnameScript returns the dispatcher script that sets the hostname, or
+// an empty string if there are no sources.
+func (b *ignitionBuilder) hostnameScript() string {
+	sources, names := b.hostnameSources, b.hostnames
+	if sources == nil {
+		if b.hostname == "" {
+			return ""
+		}
+		sources = []v1alpha1.HostnameSource{v1alpha1.HostnameSourceDHCPv6, v1alpha1.HostnameSourcePreprovisioningImage}
+		names = map[v1alpha1.HostnameSource]string{v1alpha1.HostnameSourcePreprovisioningImage: b.hostname}
+	}
+
+	parts := []string{}
+	for _, source := range sources {
+		if script, dynamic := hostnameSourceScripts[source]; dynamic {
+			parts = append(parts, script)
+			continue
+		}
+		if name := names[source]; name != "" {
+			parts = append(parts, fmt.Sprintf("\nset_default_hostname '%s'\n", name))
+			// No later source can be used
+			break
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return hostnameScriptHeader + strings.Join(parts, "")
+}
+
+func (b *ignitionBuilder) hostnameFile() *ignition_config_types_32.File {
+	script := b.hostnameScript()
+	if script == "" {
+		return nil
+	}
+	file := ignitionFileEmbed(hostnameDispatcherPath, 0744, false, []byte(script))
+	return &file
+}
+
+// agentHostnameConfig returns the lines of the agent service and the value
+// of IPA_DEFAULT_HOSTNAME that pass the hostname chosen by the dispatcher
+// script to the agent, falling back to the hostname passed to New.
+func (b *ignitionBuilder) agentHostnameConfig() (serviceLines, hostname string) {
+	if b.hostnameSources == nil {
+		return "", b.hostname
+	}
+	return fmt.Sprintf("Environment=\"IPA_DEFAULT_HOSTNAME=%s\"\nEnvironmentFile=-%s\n", b.hostname, hostnameEnvPath),
+		"${IPA_DEFAULT_HOSTNAME}"
+}
@@ -0,0 +1,56 @@
+package ignition
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+)
+
+func TestHostnameScriptDefault(t *testing.T) {
+	b := &ignitionBuilder{hostname: "host-0"}
+
+	script := b.hostnameScript()
+	assert.Contains(t, script, `[[ "$DHCP6_FQDN_FQDN" =~ "." ]] && set_hostname "$DHCP6_FQDN_FQDN"`)
+	assert.Contains(t, script, "set_default_hostname 'host-0'")
+	assert.NotContains(t, script, "DHCP4")
+
+	serviceLines, hostname := b.agentHostnameConfig()
+	assert.Empty(t, serviceLines)
+	assert.Equal(t, "host-0", hostname)
+
+	b.hostname = ""
+	assert.Empty(t, b.hostnameScript())
+}
+
+func TestHostnameScriptSources(t *testing.T) {
+	b := &ignitionBuilder{hostname: "host-0.example.com"}
+	b.HostnameSources([]v1alpha1.HostnameSource{
+		v1alpha1.HostnameSourceDHCPv4,
+		v1alpha1.HostnameSourceBareMetalHost,
+		v1alpha1.HostnameSourceReverseDNS,
+		v1alpha1.HostnameSourceTemplate,
+	}, map[v1alpha1.HostnameSource]string{
+		v1alpha1.HostnameSourceTemplate: "host-0.example.com",
+	})
+
+	script := b.hostnameScript()
+	assert.Contains(t, script, `name="${DHCP4_FQDN_FQDN:-$DHCP4_HOST_NAME}"`)
+	assert.Contains(t, script, `getent hosts "$address"`)
+	assert.Contains(t, script, "set_default_hostname 'host-0.example.com'")
+	assert.Less(t, strings.Index(script, "DHCP4_HOST_NAME"), strings.Index(script, "getent"))
+
+	serviceLines, hostname := b.agentHostnameConfig()
+	assert.Equal(t, "Environment=\"IPA_DEFAULT_HOSTNAME=host-0.example.com\"\nEnvironmentFile=-/run/ironic-agent-hostname.env\n", serviceLines)
+	assert.Equal(t, "${IPA_DEFAULT_HOSTNAME}", hostname)
+	assert.Contains(t, *b.IronicAgentService(false).Contents, `--env "IPA_DEFAULT_HOSTNAME=${IPA_DEFAULT_HOSTNAME}"`)
+}
+
+func TestHostnameScriptNoSources(t *testing.T) {
+	b := &ignitionBuilder{hostname: "host-0"}
+	b.HostnameSources([]v1alpha1.HostnameSource{v1alpha1.HostnameSourceBareMetalHost}, map[v1alpha1.HostnameSource]string{})
+
+	assert.Empty(t, b.hostnameScript())
+}
@@ -0,0 +1,39 @@
+package ignition
+
+import (
+	"fmt"
+
+	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
+	"k8s.io/utils/pointer"
+)
+
+// UploadJournal causes the ramdisk to stream its journal, including kernel
+// messages, to the given URL for as long as it runs.
+func (b *ignitionBuilder) UploadJournal(url string) {
+	b.journalUploadURL = url
+}
+
+// journalUploadService streams the journal in the export format to the log
+// sink. The cursor of the last entry sent is saved when the upload stops, so
+// that entries are not sent again when it restarts.
+func (b *ignitionBuilder) journalUploadService() ignition_config_types_32.Unit {
+	contents := fmt.Sprintf(`[Unit]
+Description=Upload the ramdisk journal
+After=network-online.target
+Wants=network-online.target
+[Service]
+Environment="UPLOAD_URL=%s"
+StateDirectory=ramdisk-journal-upload
+Restart=always
+RestartSec=10
+ExecStart=/bin/bash -c 'journalctl -b -o export --follow --no-tail --cursor-file=/var/lib/ramdisk-journal-upload/cursor | curl -sf -X POST -H "Content-Type: application/vnd.fdo.journal" -T - "$$UPLOAD_URL"'
+[Install]
+WantedBy=multi-user.target
+`, b.journalUploadURL)
+
+	return ignition_config_types_32.Unit{
+		Name:     "ramdisk-journal-upload.service",
+		Enabled:  pointer.BoolPtr(true),
+		Contents: &contents,
+	}
+}
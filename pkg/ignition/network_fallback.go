@@ -0,0 +1,86 @@
+package ignition
+
+import (
+	"fmt"
+	"time"
+
+	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	networkFallbackScriptPath = "/usr/local/bin/network-fallback"
+	networkFallbackEnvPath    = "/run/network-fallback.env"
+	networkFallbackUnit       = "network-fallback.service"
+
+	// NetworkFallbackEnv is the variable set in the agent's environment when
+	// the ramdisk has fallen back to DHCP.
+	NetworkFallbackEnv = "IPA_NETWORK_FALLBACK"
+)
+
+// networkFallbackScript waits until Ironic can be reached, and if it cannot
+// be by the time the given number of seconds have passed since boot,
+// activates DHCP on all ethernet devices and restarts the agent with
+// IPA_NETWORK_FALLBACK set, so that a host with a broken static network
+// configuration can still be reached. The DHCP connections are not saved to
+// disk, so that they are not copied to the installed host along with the
+// static configuration.
+const networkFallbackScript = `#!/bin/bash
+# Usage: IRONIC_URL=<url> FALLBACK_TIMEOUT=<seconds> network-fallback
+while [ "$(cut -d. -f1 /proc/uptime)" -lt "${FALLBACK_TIMEOUT}" ]; do
+    curl -sk -o /dev/null -m 10 "${IRONIC_URL}" && exit 0
+    sleep 10
+done
+
+echo "Ironic is unreachable at ${IRONIC_URL} with the static network configuration, falling back to DHCP" >&2
+for device in $(nmcli -g DEVICE,TYPE device status | awk -F: '$2 == "ethernet" { print $1 }'); do
+    nmcli connection add save no type ethernet ifname "$device" con-name "fallback-dhcp-$device" \
+        ipv4.method auto ipv6.method auto connection.autoconnect-priority 100 &&
+        nmcli connection up "fallback-dhcp-$device" ||
+        echo "unable to activate DHCP on $device" >&2
+done
+
+echo "IPA_NETWORK_FALLBACK=dhcp" > /run/network-fallback.env
+systemctl try-restart ironic-agent.service
+`
+
+// NetworkFallback causes the ramdisk to fall back to DHCP on all NICs if
+// Ironic cannot be reached within the given time after boot using the static
+// network configuration. It has no effect when there is no static network
+// configuration.
+func (b *ignitionBuilder) NetworkFallback(timeout time.Duration) {
+	b.networkFallbackTimeout = timeout
+}
+
+func (b *ignitionBuilder) networkFallbackEnabled(staticNetwork bool) bool {
+	return staticNetwork && b.networkFallbackTimeout > 0
+}
+
+func (b *ignitionBuilder) networkFallbackService() ignition_config_types_32.Unit {
+	contents := fmt.Sprintf(`[Unit]
+Description=Fall back to DHCP if Ironic is unreachable
+After=NetworkManager.service
+Wants=NetworkManager.service
+[Service]
+Type=simple
+Environment="IRONIC_URL=%s" "FALLBACK_TIMEOUT=%d"
+ExecStart=%s
+[Install]
+WantedBy=multi-user.target
+`, b.ironicAPIURL(), int(b.networkFallbackTimeout.Seconds()), networkFallbackScriptPath)
+
+	return ignition_config_types_32.Unit{
+		Name:     networkFallbackUnit,
+		Enabled:  pointer.BoolPtr(true),
+		Contents: &contents,
+	}
+}
+
+// networkFallbackAgentConfig returns the lines of the agent service and the
+// podman arguments that pass IPA_NETWORK_FALLBACK to the agent once it is set.
+func (b *ignitionBuilder) networkFallbackAgentConfig(staticNetwork bool) (serviceLines, podmanArgs string) {
+	if !b.networkFallbackEnabled(staticNetwork) {
+		return "", ""
+	}
+	return fmt.Sprintf("EnvironmentFile=-%s\n", networkFallbackEnvPath), fmt.Sprintf("--env %s ", NetworkFallbackEnv)
+}
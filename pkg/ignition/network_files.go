@@ -0,0 +1,82 @@
+package ignition
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
+	"sigs.k8s.io/yaml"
+)
+
+// nmstate8021X holds the file references in the 802.1X settings of the
+// interfaces in NMState data.
+type nmstate8021X struct {
+	Interfaces []struct {
+		Name  string `json:"name"`
+		Dot1X *struct {
+			CACert     string `json:"ca-cert"`
+			ClientCert string `json:"client-cert"`
+			PrivateKey string `json:"private-key"`
+		} `json:"802.1x"`
+	} `json:"interfaces"`
+}
+
+// NMStateFiles returns the contents of the certificate and private key files
+// referenced by the 802.1X settings in NMState data, indexed by path. Each
+// file is provided by the key in the network data Secret matching its file
+// name, so an error is returned if a file is not provided or if two paths
+// have the same file name.
+func NMStateFiles(nmStateData []byte, networkData map[string][]byte) (map[string][]byte, error) {
+	state := &nmstate8021X{}
+	if err := yaml.Unmarshal(nmStateData, state); err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{}
+	pathsByName := map[string]string{}
+	for _, iface := range state.Interfaces {
+		if iface.Dot1X == nil {
+			continue
+		}
+		for _, filePath := range []string{iface.Dot1X.CACert, iface.Dot1X.ClientCert, iface.Dot1X.PrivateKey} {
+			if filePath == "" {
+				continue
+			}
+			if !path.IsAbs(filePath) {
+				return nil, fmt.Errorf("802.1x file %s of interface %s is not an absolute path", filePath, iface.Name)
+			}
+			name := path.Base(filePath)
+			if other, exists := pathsByName[name]; exists && other != filePath {
+				return nil, fmt.Errorf("802.1x files %s and %s have the same name", other, filePath)
+			}
+			data, exists := networkData[name]
+			if !exists {
+				return nil, fmt.Errorf("802.1x file %s of interface %s is not in the network data (as %s)", filePath, iface.Name, name)
+			}
+			pathsByName[name] = filePath
+			files[filePath] = data
+		}
+	}
+	return files, nil
+}
+
+// NetworkFiles causes the ramdisk to contain the given files, indexed by
+// path, that are referenced by the network configuration.
+func (b *ignitionBuilder) NetworkFiles(files map[string][]byte) {
+	b.networkFiles = files
+}
+
+func (b *ignitionBuilder) networkFileEntries() []ignition_config_types_32.File {
+	paths := make([]string, 0, len(b.networkFiles))
+	for filePath := range b.networkFiles {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	files := make([]ignition_config_types_32.File, 0, len(paths))
+	for _, filePath := range paths {
+		files = append(files, ignitionFileEmbed(filePath, 0600, true, b.networkFiles[filePath]))
+	}
+	return files
+}
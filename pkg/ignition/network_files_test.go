@@ -0,0 +1,101 @@
+package ignition
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const nmstate8021XData = `interfaces:
+- name: eth0
+  type: ethernet
+  802.1x:
+    identity: host-0
+    eap-methods:
+    - tls
+    ca-cert: /etc/pki/802-1x/ca.pem
+    client-cert: /etc/pki/802-1x/client.pem
+    private-key: /etc/pki/802-1x/client.key
+- name: eth1
+  type: ethernet
+  802.1x:
+    ca-cert: /etc/pki/802-1x/ca.pem
+`
+
+func TestNMStateFiles(t *testing.T) {
+	networkData := map[string][]byte{
+		"nmstate":    []byte(nmstate8021XData),
+		"ca.pem":     []byte("CA"),
+		"client.pem": []byte("CERT"),
+		"client.key": []byte("KEY"),
+		"unrelated":  []byte("ignored"),
+	}
+
+	files, err := NMStateFiles(networkData["nmstate"], networkData)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"/etc/pki/802-1x/ca.pem":     []byte("CA"),
+		"/etc/pki/802-1x/client.pem": []byte("CERT"),
+		"/etc/pki/802-1x/client.key": []byte("KEY"),
+	}, files)
+}
+
+func TestNMStateFilesInvalid(t *testing.T) {
+	testCases := []struct {
+		name        string
+		nmstate     string
+		networkData map[string][]byte
+	}{
+		{
+			name:        "missing",
+			nmstate:     nmstate8021XData,
+			networkData: map[string][]byte{"ca.pem": []byte("CA")},
+		},
+		{
+			name: "relative",
+			nmstate: `interfaces:
+- name: eth0
+  802.1x:
+    ca-cert: ca.pem
+`,
+			networkData: map[string][]byte{"ca.pem": []byte("CA")},
+		},
+		{
+			name: "duplicate name",
+			nmstate: `interfaces:
+- name: eth0
+  802.1x:
+    ca-cert: /etc/pki/a/ca.pem
+- name: eth1
+  802.1x:
+    ca-cert: /etc/pki/b/ca.pem
+`,
+			networkData: map[string][]byte{"ca.pem": []byte("CA")},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NMStateFiles([]byte(tc.nmstate), tc.networkData)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGenerateWithNetworkFiles(t *testing.T) {
+	builder, err := New(nil, nil,
+		"http://ironic.example.com", "",
+		"quay.io/openshift-release-dev/ironic-ipa-image",
+		"", "", "", "", "", "", "")
+	assert.NoError(t, err)
+	builder.NetworkFiles(map[string][]byte{
+		"/etc/pki/802-1x/client.key": []byte("KEY"),
+		"/etc/pki/802-1x/ca.pem":     []byte("CA"),
+	})
+
+	ignition, err := builder.GenerateConfig()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/etc/pki/802-1x/ca.pem", ignition.Storage.Files[1].Path)
+	assert.Equal(t, "/etc/pki/802-1x/client.key", ignition.Storage.Files[2].Path)
+	assert.Equal(t, 0600, *ignition.Storage.Files[2].Mode)
+}
@@ -1,15 +1,247 @@
 package ignition
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
 	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
 	"sigs.k8s.io/yaml"
 )
 
+const maxNMStateErrorMessageLen = 256
+
+var (
+	nmstateLogPrefixRe = regexp.MustCompile(`^\[[^\]]*\]\s*`)
+	nmstateKindRe      = regexp.MustCompile(`^(?:NmstateError:\s*)?([A-Z][A-Za-z]+):\s*(.+)$`)
+	nmstateLocationRe  = regexp.MustCompile(`\s*at line (\d+) column (\d+)`)
+	nmstatePathRe      = regexp.MustCompile(`\b((?:interfaces|routes|route-rules|dns-resolver|ovs-db|ovn)(?:\[\d+\])?(?:\.[\w-]+(?:\[\d+\])?)*):`)
+	nmstateIfaceIdxRe  = regexp.MustCompile(`^interfaces\[(\d+)\]`)
+	nmstateIfaceNameRe = regexp.MustCompile(`\b[Ii]nterface ['"]?([\w.@:-]+?)['"]?(?:\s|,|$)`)
+)
+
 type nmstateOutput struct {
 	NetworkManager [][]string `yaml:"NetworkManager"`
 }
 
-func nmstateOutputToFiles(generatedConfig []byte) ([]ignition_config_types_32.File, error) {
+type nmstateInterfaces struct {
+	Interfaces []struct {
+		Name string `json:"name"`
+	} `json:"interfaces"`
+}
+
+// NMStateError is a structured representation of a failure reported by
+// nmstatectl when converting NMState data.
+type NMStateError struct {
+	// Kind is the nmstate error kind, e.g. InvalidArgument.
+	Kind string
+	// Interface is the name of the offending interface, if known.
+	Interface string
+	// Path is the location in the NMState YAML of the offending field, if
+	// known.
+	Path string
+	// Line and Column locate the error in the NMState YAML, if known.
+	Line   int
+	Column int
+	// Message is a single-line summary of the error.
+	Message string
+	// Detail is the full output of nmstatectl.
+	Detail string
+}
+
+func (e *NMStateError) Error() string {
+	msg := e.Message
+	if e.Kind != "" {
+		msg = fmt.Sprintf("%s: %s", e.Kind, msg)
+	}
+	var location []string
+	if e.Interface != "" {
+		location = append(location, fmt.Sprintf("interface %s", e.Interface))
+	}
+	if e.Line > 0 {
+		location = append(location, fmt.Sprintf("line %d", e.Line))
+	}
+	if len(location) > 0 {
+		msg = fmt.Sprintf("%s (%s)", msg, strings.Join(location, ", "))
+	}
+	return msg
+}
+
+// parseNMStateError extracts a structured error from the stderr output of
+// nmstatectl. The nmStateData is used to resolve interface indices in the
+// error to interface names.
+func parseNMStateError(stderr string, nmStateData []byte) *NMStateError {
+	nmErr := &NMStateError{Detail: strings.TrimSpace(stderr)}
+
+	var firstLine string
+	for _, line := range strings.Split(nmErr.Detail, "\n") {
+		line = strings.TrimSpace(nmstateLogPrefixRe.ReplaceAllString(line, ""))
+		if line == "" {
+			continue
+		}
+		if firstLine == "" {
+			firstLine = line
+		}
+		if match := nmstateKindRe.FindStringSubmatch(line); match != nil {
+			nmErr.Kind = match[1]
+			nmErr.Message = match[2]
+			break
+		}
+	}
+	if nmErr.Message == "" {
+		nmErr.Message = firstLine
+	}
+	if nmErr.Message == "" {
+		nmErr.Message = "nmstatectl failed"
+	}
+
+	if match := nmstateLocationRe.FindStringSubmatch(nmErr.Message); match != nil {
+		nmErr.Line, _ = strconv.Atoi(match[1])
+		nmErr.Column, _ = strconv.Atoi(match[2])
+		nmErr.Message = nmstateLocationRe.ReplaceAllString(nmErr.Message, "")
+	}
+
+	if match := nmstatePathRe.FindStringSubmatch(nmErr.Message); match != nil {
+		nmErr.Path = match[1]
+	}
+
+	if match := nmstateIfaceNameRe.FindStringSubmatch(nmErr.Message); match != nil {
+		nmErr.Interface = match[1]
+	} else if match := nmstateIfaceIdxRe.FindStringSubmatch(nmErr.Path); match != nil {
+		nmErr.Interface = interfaceNameByIndex(nmStateData, match[1])
+	}
+
+	if len(nmErr.Message) > maxNMStateErrorMessageLen {
+		// Truncate on a rune boundary so that the message remains valid UTF-8
+		end := maxNMStateErrorMessageLen - 3
+		for end > 0 && !utf8.RuneStart(nmErr.Message[end]) {
+			end--
+		}
+		nmErr.Message = nmErr.Message[:end] + "..."
+	}
+	return nmErr
+}
+
+func interfaceNameByIndex(nmStateData []byte, index string) string {
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return ""
+	}
+	ifaces := &nmstateInterfaces{}
+	if err := yaml.Unmarshal(nmStateData, ifaces); err != nil {
+		return ""
+	}
+	if i < 0 || i >= len(ifaces.Interfaces) {
+		return ""
+	}
+	return ifaces.Interfaces[i].Name
+}
+
+// keyfileValue returns the value of a key in a section of a NetworkManager
+// keyfile.
+func keyfileValue(keyfile, section, key string) string {
+	current := ""
+	for _, line := range strings.Split(keyfile, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.Trim(line, "[]")
+			continue
+		}
+		if current == section && strings.HasPrefix(line, key+"=") {
+			return strings.TrimPrefix(line, key+"=")
+		}
+	}
+	return ""
+}
+
+// keyfileHasSection returns whether a NetworkManager keyfile contains a
+// section.
+func keyfileHasSection(keyfile, section string) bool {
+	for _, line := range strings.Split(keyfile, "\n") {
+		if strings.TrimSpace(line) == "["+section+"]" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchKeyfileByMAC rewrites a NetworkManager keyfile for an ethernet
+// connection so that it matches the device by MAC address instead of by
+// interface name.
+func matchKeyfileByMAC(keyfile, mac string) string {
+	lines := []string{}
+	current := ""
+	hasEthernet := false
+	for _, line := range strings.Split(keyfile, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			current = strings.Trim(trimmed, "[]")
+			lines = append(lines, line)
+			if current == "ethernet" {
+				hasEthernet = true
+				lines = append(lines, "mac-address="+mac)
+			}
+			continue
+		}
+		if current == "connection" && strings.HasPrefix(trimmed, "interface-name=") {
+			continue
+		}
+		if current == "ethernet" && strings.HasPrefix(trimmed, "mac-address=") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !hasEthernet {
+		lines = append(lines, "[ethernet]", "mac-address="+mac, "")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// replaceKeyfileParent replaces a reference to a parent interface by name
+// with a reference to the parent connection's UUID.
+func replaceKeyfileParent(keyfile string, parentUUIDs map[string]string) string {
+	lines := strings.Split(keyfile, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "parent=") {
+			continue
+		}
+		if uuid, found := parentUUIDs[strings.TrimPrefix(trimmed, "parent=")]; found {
+			lines[i] = "parent=" + uuid
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// matchInterfacesByMAC rewrites the keyfiles for the ethernet interfaces
+// with known MAC addresses so that they match the devices by MAC address,
+// allowing the interface names in the NMState data to differ from those on
+// the host.
+func matchInterfacesByMAC(keyfiles [][]string, macs map[string]string) {
+	parentUUIDs := map[string]string{}
+	for _, kf := range keyfiles {
+		if keyfileValue(kf[1], "connection", "type") != "ethernet" {
+			continue
+		}
+		name := keyfileValue(kf[1], "connection", "interface-name")
+		if mac, found := macs[name]; found {
+			if uuid := keyfileValue(kf[1], "connection", "uuid"); uuid != "" {
+				parentUUIDs[name] = uuid
+			}
+			kf[1] = matchKeyfileByMAC(kf[1], mac)
+		}
+	}
+	if len(parentUUIDs) == 0 {
+		return
+	}
+	for _, kf := range keyfiles {
+		kf[1] = replaceKeyfileParent(kf[1], parentUUIDs)
+	}
+}
+
+func nmstateOutputToFiles(generatedConfig []byte, macs map[string]string) ([]ignition_config_types_32.File, error) {
 	files := []ignition_config_types_32.File{}
 
 	networkManagerConfig := &nmstateOutput{}
@@ -20,6 +252,9 @@ func nmstateOutputToFiles(generatedConfig []byte) ([]ignition_config_types_32.Fi
 	if networkManagerConfig.NetworkManager == nil {
 		return files, nil
 	}
+	if len(macs) > 0 {
+		matchInterfacesByMAC(networkManagerConfig.NetworkManager, macs)
+	}
 	for _, v := range networkManagerConfig.NetworkManager {
 		files = append(files,
 			ignitionFileEmbed("/etc/NetworkManager/system-connections/"+v[0],
@@ -2,10 +2,13 @@ package ignition
 
 import (
 	"reflect"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
 	"github.com/google/go-cmp/cmp"
+	"github.com/vincent-petithory/dataurl"
 	"k8s.io/utils/pointer"
 )
 
@@ -138,7 +141,7 @@ NetworkManager:
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := nmstateOutputToFiles(tt.generatedConfig)
+			got, err := nmstateOutputToFiles(tt.generatedConfig, nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ignitionBuilder.nmstateOutputToFiles() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -149,3 +152,159 @@ NetworkManager:
 		})
 	}
 }
+
+func TestParseNMStateError(t *testing.T) {
+	nmstateData := []byte(`interfaces:
+- name: eth0
+  type: ethernet
+- name: br0
+  type: bogus
+`)
+	tests := []struct {
+		name   string
+		stderr string
+		want   NMStateError
+	}{
+		{
+			name:   "yaml error",
+			stderr: "[2023-05-10T10:00:00Z ERROR nmstatectl] InvalidArgument: Invalid YAML string: interfaces[1].type: unknown variant `bogus`, expected one of `ethernet`, `linux-bridge` at line 5 column 9\n",
+			want: NMStateError{
+				Kind:      "InvalidArgument",
+				Interface: "br0",
+				Path:      "interfaces[1].type",
+				Line:      5,
+				Column:    9,
+				Message:   "Invalid YAML string: interfaces[1].type: unknown variant `bogus`, expected one of `ethernet`, `linux-bridge`",
+			},
+		},
+		{
+			name:   "named interface",
+			stderr: "NmstateError: InvalidArgument: Interface eth0 has no IP address\n",
+			want: NMStateError{
+				Kind:      "InvalidArgument",
+				Interface: "eth0",
+				Message:   "Interface eth0 has no IP address",
+			},
+		},
+		{
+			name:   "backtrace",
+			stderr: "thread 'main' panicked at 'oops', src/lib.rs:1:1\nstack backtrace:\n   0: rust_begin_unwind\n",
+			want: NMStateError{
+				Message: "thread 'main' panicked at 'oops', src/lib.rs:1:1",
+			},
+		},
+		{
+			name: "empty",
+			want: NMStateError{
+				Message: "nmstatectl failed",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseNMStateError(tt.stderr, nmstateData)
+			tt.want.Detail = strings.TrimSpace(tt.stderr)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Error(cmp.Diff(tt.want, *got))
+			}
+		})
+	}
+}
+
+func TestParseNMStateErrorTruncated(t *testing.T) {
+	// Each rune is 3 bytes, so the limit falls within one
+	message := strings.Repeat("€", maxNMStateErrorMessageLen)
+	got := parseNMStateError("NmstateError: InvalidArgument: "+message+"\n", nil)
+	if !utf8.ValidString(got.Message) {
+		t.Errorf("truncated message is not valid UTF-8: %q", got.Message)
+	}
+	if len(got.Message) > maxNMStateErrorMessageLen || !strings.HasSuffix(got.Message, "€...") {
+		t.Errorf("unexpected truncated message %q", got.Message)
+	}
+}
+
+func TestNMStateErrorMessage(t *testing.T) {
+	err := &NMStateError{
+		Kind:      "InvalidArgument",
+		Interface: "br0",
+		Line:      5,
+		Message:   "unknown variant `bogus`",
+	}
+	expected := "InvalidArgument: unknown variant `bogus` (interface br0, line 5)"
+	if err.Error() != expected {
+		t.Errorf("unexpected message %q (should be %q)", err.Error(), expected)
+	}
+}
+
+func TestMatchInterfacesByMAC(t *testing.T) {
+	generatedConfig := []byte(`NetworkManager:
+- - eth0.nmconnection
+  - |
+    [connection]
+    id=eth0
+    uuid=8c2b5a5e-1b0b-4d1a-9a3c-5c1b0a2f0e01
+    type=ethernet
+    interface-name=eth0
+
+    [ethernet]
+
+    [ipv4]
+    method=auto
+- - eth0.100.nmconnection
+  - |
+    [connection]
+    id=eth0.100
+    type=vlan
+    interface-name=eth0.100
+
+    [vlan]
+    id=100
+    parent=eth0
+- - eth1.nmconnection
+  - |
+    [connection]
+    id=eth1
+    type=ethernet
+    interface-name=eth1
+`)
+
+	files, err := nmstateOutputToFiles(generatedConfig, map[string]string{
+		"eth0": "00:11:22:33:44:55",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(files))
+	}
+
+	contents := make([]string, len(files))
+	for i, f := range files {
+		decoded, err := dataurl.DecodeString(*f.Contents.Source)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		contents[i] = string(decoded.Data)
+	}
+
+	eth0 := `[connection]
+id=eth0
+uuid=8c2b5a5e-1b0b-4d1a-9a3c-5c1b0a2f0e01
+type=ethernet
+
+[ethernet]
+mac-address=00:11:22:33:44:55
+
+[ipv4]
+method=auto
+`
+	if diff := cmp.Diff(eth0, contents[0]); diff != "" {
+		t.Errorf("unexpected eth0 keyfile (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(contents[1], "parent=8c2b5a5e-1b0b-4d1a-9a3c-5c1b0a2f0e01") {
+		t.Errorf("vlan parent not replaced: %s", contents[1])
+	}
+	if !strings.Contains(contents[2], "interface-name=eth1") {
+		t.Errorf("unmatched interface rewritten: %s", contents[2])
+	}
+}
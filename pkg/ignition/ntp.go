@@ -0,0 +1,61 @@
+package ignition
+
+import (
+	"fmt"
+	"strings"
+
+	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	ntpWaitUnit = "chrony-wait.service"
+
+	// ntpWaitTries and ntpWaitInterval bound the time that the agent waits
+	// for the clock to be synchronized, so that an unreachable NTP server
+	// does not block provisioning.
+	ntpWaitTries    = 60
+	ntpWaitInterval = 2
+)
+
+// NTPServers causes the ramdisk to synchronize its clock with the given NTP
+// servers before starting the agent.
+func (b *ignitionBuilder) NTPServers(servers []string) {
+	b.ntpServers = servers
+}
+
+func (b *ignitionBuilder) chronyConf() ignition_config_types_32.File {
+	conf := &strings.Builder{}
+	for _, server := range b.ntpServers {
+		fmt.Fprintf(conf, "server %s iburst\n", server)
+	}
+	conf.WriteString(`driftfile /var/lib/chrony/drift
+makestep 1.0 -1
+rtcsync
+logdir /var/log/chrony
+`)
+	return ignitionFileEmbed("/etc/chrony.conf", 0644, true, []byte(conf.String()))
+}
+
+// ntpWaitService waits until chronyd has synchronized the clock, giving up
+// after a bounded time.
+func ntpWaitService() ignition_config_types_32.Unit {
+	contents := fmt.Sprintf(`[Unit]
+Description=Wait for NTP synchronization
+After=chronyd.service network-online.target
+Wants=chronyd.service network-online.target
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+TimeoutStartSec=%d
+ExecStart=-/usr/bin/chronyc waitsync %d 0 0 %d
+[Install]
+WantedBy=multi-user.target
+`, ntpWaitTries*ntpWaitInterval+30, ntpWaitTries, ntpWaitInterval)
+
+	return ignition_config_types_32.Unit{
+		Name:     ntpWaitUnit,
+		Enabled:  pointer.BoolPtr(true),
+		Contents: &contents,
+	}
+}
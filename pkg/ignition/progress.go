@@ -0,0 +1,117 @@
+package ignition
+
+import (
+	"fmt"
+
+	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	progressEnvPath    = "/etc/boot-progress.env"
+	progressScriptPath = "/usr/local/bin/report-boot-progress"
+
+	progressInitramfsUnit = "boot-progress-initramfs.service"
+	progressNetworkUnit   = "boot-progress-network.service"
+
+	// progressUnitTimeout is the maximum time in seconds that the units
+	// reporting progress before the agent starts may take, so that a report
+	// never holds up the agent for longer than a single attempt.
+	progressUnitTimeout = 20
+)
+
+// progressScript reports a boot milestone to the image server. Each request
+// times out after 10 seconds, and only a single attempt is made before
+// returning; if it fails, the report is retried for about a minute from a
+// separate transient unit, so that nothing waits for an unreachable image
+// server. When run with "agent-exited" from ExecStopPost, a failure is
+// reported if the agent service did not succeed.
+const progressScript = `#!/bin/bash
+# Usage: report-boot-progress <milestone> [message]
+. /etc/boot-progress.env
+
+milestone="$1"
+message="${2:-}"
+if [ "$milestone" = agent-exited ]; then
+    [ "${SERVICE_RESULT:-success}" = success ] && exit 0
+    milestone=failed
+    message="ironic-agent.service failed: ${SERVICE_RESULT} (${EXIT_CODE:-} ${EXIT_STATUS:-})"
+fi
+
+journal=""
+if [ "$milestone" = failed ]; then
+    journal="$(journalctl -b --no-pager -o cat -n 20 -u ironic-agent.service 2>/dev/null)"
+fi
+
+body="$(jq -n --arg milestone "$milestone" --arg message "$message" --arg journal "$journal" \
+    '{milestone: $milestone, message: $message, journal: $journal}')"
+
+report() {
+    curl -sf -o /dev/null --connect-timeout 5 -m 10 -X POST \
+        -H "Authorization: Bearer ${PROGRESS_TOKEN}" \
+        -H "Content-Type: application/json" \
+        --data-binary "$body" "${PROGRESS_URL}"
+}
+
+if [ -n "${PROGRESS_RETRY:-}" ]; then
+    for attempt in $(seq 6); do
+        sleep 5
+        report && exit 0
+    done
+    echo "unable to report boot progress ${milestone}" >&2
+    exit 0
+fi
+
+report && exit 0
+systemd-run --no-block --quiet --collect --property=RuntimeMaxSec=120 \
+    --setenv=PROGRESS_RETRY=1 "$0" "$milestone" "$message" ||
+    echo "unable to report boot progress ${milestone}" >&2
+exit 0
+`
+
+// ReportProgress causes the ramdisk to report its boot milestones to the
+// given URL, authenticating with the token.
+func (b *ignitionBuilder) ReportProgress(url, token string) {
+	b.progressURL = url
+	b.progressToken = token
+}
+
+func (b *ignitionBuilder) progressFiles() []ignition_config_types_32.File {
+	env := fmt.Sprintf("PROGRESS_URL=%s\nPROGRESS_TOKEN=%s\n", b.progressURL, b.progressToken)
+	return []ignition_config_types_32.File{
+		ignitionFileEmbed(progressEnvPath, 0600, false, []byte(env)),
+		ignitionFileEmbed(progressScriptPath, 0755, false, []byte(progressScript)),
+	}
+}
+
+func progressUnit(name, description, after, milestone string) ignition_config_types_32.Unit {
+	contents := fmt.Sprintf(`[Unit]
+Description=%s
+After=%s
+Wants=%s
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+TimeoutStartSec=%d
+ExecStart=%s %s
+[Install]
+WantedBy=multi-user.target
+`, description, after, after, progressUnitTimeout, progressScriptPath, milestone)
+
+	return ignition_config_types_32.Unit{
+		Name:     name,
+		Enabled:  pointer.BoolPtr(true),
+		Contents: &contents,
+	}
+}
+
+// progressUnits report the milestones reached before the agent starts. The
+// units are ordered so that the milestones are reported in sequence.
+func (b *ignitionBuilder) progressUnits() []ignition_config_types_32.Unit {
+	return []ignition_config_types_32.Unit{
+		progressUnit(progressInitramfsUnit, "Report ramdisk boot progress: initramfs complete",
+			"basic.target", "initramfs"),
+		progressUnit(progressNetworkUnit, "Report ramdisk boot progress: network online",
+			"network-online.target "+progressInitramfsUnit, "network-online"),
+	}
+}
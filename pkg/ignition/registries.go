@@ -0,0 +1,89 @@
+package ignition
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/registries"
+)
+
+const (
+	registriesConfDDir = "/etc/containers/registries.conf.d"
+	registryCertsDir   = "/etc/containers/certs.d"
+)
+
+// RegistriesDropins adds drop-in files, indexed by name, to
+// /etc/containers/registries.conf.d. An error is returned if any of them is
+// not a valid registries.conf file.
+func (b *ignitionBuilder) RegistriesDropins(dropins map[string][]byte) error {
+	if err := registries.ValidateDropins(dropins); err != nil {
+		return err
+	}
+	for name, data := range dropins {
+		b.registriesDropins[name] = data
+	}
+	return nil
+}
+
+// RegistryMirrors configures mirrors of the registries that the agent image
+// is pulled from, in a drop-in that takes precedence over registries.conf
+// and the other drop-ins.
+func (b *ignitionBuilder) RegistryMirrors(mirrors []v1alpha1.RegistryMirror) error {
+	if len(mirrors) == 0 {
+		return nil
+	}
+	data, err := registries.MirrorsDropin(mirrors)
+	if err != nil {
+		return fmt.Errorf("invalid registry mirrors: %w", err)
+	}
+	b.registriesDropins[registries.MirrorsDropinName] = data
+	return nil
+}
+
+// RegistryCertificates adds CA and client certificates for registries to
+// /etc/containers/certs.d. The files are indexed by their path relative to
+// that directory.
+func (b *ignitionBuilder) RegistryCertificates(certs map[string][]byte) error {
+	if err := registries.ValidateCerts(certs); err != nil {
+		return err
+	}
+	b.registryCerts = certs
+	return nil
+}
+
+func (b *ignitionBuilder) registriesFiles() []ignition_config_types_32.File {
+	var files []ignition_config_types_32.File
+	if len(b.registriesConf) > 0 {
+		files = append(files, ignitionFileEmbed("/etc/containers/registries.conf",
+			0644, true,
+			b.registriesConf))
+	}
+	for _, name := range sortedKeys(b.registriesDropins) {
+		files = append(files, ignitionFileEmbed(path.Join(registriesConfDDir, name),
+			0644, true,
+			b.registriesDropins[name]))
+	}
+	for _, name := range sortedKeys(b.registryCerts) {
+		mode := 0644
+		if path.Ext(name) == ".key" {
+			mode = 0600
+		}
+		files = append(files, ignitionFileEmbed(path.Join(registryCertsDir, name),
+			mode, true,
+			b.registryCerts[name]))
+	}
+	return files
+}
+
+func sortedKeys(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
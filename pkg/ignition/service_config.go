@@ -1,49 +1,125 @@
 package ignition
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net/url"
 	"strings"
 
 	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
 	"k8s.io/utils/pointer"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+)
+
+const (
+	ironicAPIPort       = "6385"
+	ironicInspectorPort = "5050"
 )
 
+// endpointURL returns the URL of an Ironic API given its base URL. The
+// default port is added unless the base URL already specifies a port or a
+// path, as it does when the API is behind a path-based proxy.
+func endpointURL(baseURL, defaultPort string) string {
+	base := strings.TrimSuffix(baseURL, "/")
+	if u, err := url.Parse(base); err == nil && (u.Port() != "" || u.Path != "") {
+		return base
+	}
+	return base + ":" + defaultPort
+}
+
+func (b *ignitionBuilder) ironicAPIURL() string {
+	return endpointURL(b.ironicBaseURL, ironicAPIPort)
+}
+
+func (b *ignitionBuilder) ironicInspectorURL() string {
+	return endpointURL(b.ironicInspectorBaseURL, ironicInspectorPort)
+}
+
+// InspectionMode sets where the agent sends the results of inspection. The
+// default is a standalone Ironic Inspector.
+func (b *ignitionBuilder) InspectionMode(mode v1alpha1.InspectionMode) {
+	b.inspectionMode = mode
+}
+
+// inspectionCallbackURL returns the URL to which the agent sends inspection
+// results, or an empty string if inspection is disabled.
+func (b *ignitionBuilder) inspectionCallbackURL() string {
+	switch b.inspectionMode {
+	case v1alpha1.InspectionModeIronic:
+		return b.ironicAPIURL() + "/v1/continue_inspection"
+	case v1alpha1.InspectionModeDisabled:
+		return ""
+	default:
+		return b.ironicInspectorURL() + "/v1/continue"
+	}
+}
+
 func (b *ignitionBuilder) IronicAgentConf() ignition_config_types_32.File {
-	template := `
-[DEFAULT]
-api_url = %s:6385
-inspection_callback_url = %s:5050/v1/continue
-insecure = True
-enable_vlan_interfaces = %s
-`
-	contents := fmt.Sprintf(template, b.ironicBaseURL, b.ironicInspectorBaseURL, ironicInspectorVlanInterfaces)
+	contents := fmt.Sprintf("\n[DEFAULT]\napi_url = %s\n", b.ironicAPIURL())
+	callbackURL := b.inspectionCallbackURL()
+	if callbackURL != "" {
+		contents += fmt.Sprintf("inspection_callback_url = %s\n", callbackURL)
+	}
+	contents += "insecure = True\n"
+	if callbackURL != "" {
+		contents += fmt.Sprintf("enable_vlan_interfaces = %s\n", ironicInspectorVlanInterfaces)
+	}
+	if b.debugPasswordHash != "" {
+		contents += "debug = True\n"
+	}
 	return ignitionFileEmbed("/etc/ironic-python-agent.conf", 0644, false, []byte(contents))
 }
 
-func (b *ignitionBuilder) IronicAgentService(copyNetwork bool) ignition_config_types_32.Unit {
-	flags := ironicAgentPodmanFlags
+// pullFlags returns the podman flags needed to pull the agent image.
+func (b *ignitionBuilder) pullFlags() []string {
+	flags := []string{}
+	if b.containerTrust == nil {
+		flags = append(flags, ironicAgentPodmanFlags)
+	}
 	if b.ironicAgentPullSecret != "" {
-		flags += " --authfile=/etc/authfile.json"
+		flags = append(flags, "--authfile=/etc/authfile.json")
+	}
+	return flags
+}
+
+func (b *ignitionBuilder) IronicAgentService(copyNetwork bool) ignition_config_types_32.Unit {
+	// When progress is reported, the agent is started only once the network
+	// milestone has been reported, so that the milestones are in order.
+	progressAfter, progressExec := "", ""
+	if b.progressURL != "" {
+		progressAfter = fmt.Sprintf("After=%s\n", progressNetworkUnit)
+		progressExec = fmt.Sprintf("ExecStartPre=-%[1]s agent-image-pulled\nExecStartPost=-%[1]s agent-started\nExecStopPost=-%[1]s agent-exited\n",
+			progressScriptPath)
+	}
+
+	pull := ""
+	if b.agentPullsImage() {
+		pull = fmt.Sprintf("ExecStartPre=/bin/podman pull %s\n", strings.Join(append([]string{b.ironicAgentImage}, b.pullFlags()...), " "))
 	}
 
 	unitTemplate := `[Unit]
 Description=Ironic Agent
 After=network-online.target
 Wants=network-online.target
-[Service]
+%s%s[Service]
 Environment="HTTP_PROXY=%s"
 Environment="HTTPS_PROXY=%s"
 Environment="NO_PROXY=%s"
 TimeoutStartSec=0
-Restart=on-failure
+Restart=%s
 RestartSec=5
 StartLimitIntervalSec=0
-ExecStartPre=/bin/podman pull %s %s
-ExecStart=/bin/podman run --rm --privileged --network host --mount type=bind,src=/etc/ironic-python-agent.conf,dst=/etc/ironic-python-agent/ignition.conf --mount type=bind,src=/dev,dst=/dev --mount type=bind,src=/sys,dst=/sys --mount type=bind,src=/run/dbus/system_bus_socket,dst=/run/dbus/system_bus_socket --mount type=bind,src=/,dst=/mnt/coreos --mount type=bind,src=/run/udev,dst=/run/udev --ipc=host --uts=host --env "IPA_COREOS_IP_OPTIONS=%s" --env IPA_COREOS_COPY_NETWORK=%v --env "IPA_DEFAULT_HOSTNAME=%s" --name ironic-agent %s
+%s%s%sExecStart=/bin/podman run --rm --privileged --network host --mount type=bind,src=/etc/ironic-python-agent.conf,dst=/etc/ironic-python-agent/ignition.conf --mount type=bind,src=/dev,dst=/dev --mount type=bind,src=/sys,dst=/sys --mount type=bind,src=/run/dbus/system_bus_socket,dst=/run/dbus/system_bus_socket --mount type=bind,src=/,dst=/mnt/coreos --mount type=bind,src=/run/udev,dst=/run/udev --ipc=host --uts=host --env "IPA_COREOS_IP_OPTIONS=%s" --env IPA_COREOS_COPY_NETWORK=%v --env "IPA_DEFAULT_HOSTNAME=%s" %s%s--name ironic-agent %s
 [Install]
 WantedBy=multi-user.target
 `
-	contents := fmt.Sprintf(unitTemplate, b.httpProxy, b.httpsProxy, b.noProxy, b.ironicAgentImage, flags, b.ipOptions, copyNetwork, b.hostname, b.ironicAgentImage)
+	hostnameEnv, hostname := b.agentHostnameConfig()
+	fallbackEnv, fallbackArgs := b.networkFallbackAgentConfig(copyNetwork)
+
+	contents := fmt.Sprintf(unitTemplate, progressAfter, b.agentUnitDependencies(), b.httpProxy, b.httpsProxy, b.noProxy,
+		b.agentRestart(), hostnameEnv+fallbackEnv, pull, progressExec, b.ipOptions, copyNetwork, hostname, fallbackArgs,
+		b.agentRuntimeArgs(), b.ironicAgentImage)
 
 	return ignition_config_types_32.Unit{
 		Name:     "ironic-agent.service",
@@ -53,7 +129,7 @@ WantedBy=multi-user.target
 }
 
 func (b *ignitionBuilder) authFile() ignition_config_types_32.File {
-	source := "data:;base64," + strings.TrimSpace(b.ironicAgentPullSecret)
+	source := "data:;base64," + base64.StdEncoding.EncodeToString(b.authFileContents)
 	return ignition_config_types_32.File{
 		Node:          ignition_config_types_32.Node{Path: "/etc/authfile.json"},
 		FileEmbedded1: ignition_config_types_32.FileEmbedded1{Contents: ignition_config_types_32.Resource{Source: &source}},
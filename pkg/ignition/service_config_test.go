@@ -7,6 +7,8 @@ import (
 	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
 	"github.com/google/go-cmp/cmp"
 	"k8s.io/utils/pointer"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
 )
 
 func TestIronicPythonAgentConf(t *testing.T) {
@@ -17,17 +19,68 @@ func TestIronicPythonAgentConf(t *testing.T) {
 		ironicBaseURL                 string
 		ironicInspectorBaseURL        string
 		ironicInspectorVlanInterfaces string
+		inspectionMode                v1alpha1.InspectionMode
 		want                          ignition_config_types_32.File
 	}{
 		{
 			name:                   "basic",
+			ironicBaseURL:          "http://192.0.2.1",
+			ironicInspectorBaseURL: "http://192.0.2.1",
+			want: ignition_config_types_32.File{
+				Node: ignition_config_types_32.Node{Path: "/etc/ironic-python-agent.conf", Overwrite: &expectedOverwrite},
+				FileEmbedded1: ignition_config_types_32.FileEmbedded1{
+					Contents: ignition_config_types_32.Resource{
+						Source: pointer.StringPtr("data:text/plain,%0A%5BDEFAULT%5D%0Aapi_url%20%3D%20http%3A%2F%2F192.0.2.1%3A6385%0Ainspection_callback_url%20%3D%20http%3A%2F%2F192.0.2.1%3A5050%2Fv1%2Fcontinue%0Ainsecure%20%3D%20True%0Aenable_vlan_interfaces%20%3D%20all%0A")},
+					Mode: &expectedMode},
+			},
+		},
+		{
+			name:                   "path-based proxy",
 			ironicBaseURL:          "http://example.com/foo",
 			ironicInspectorBaseURL: "http://example.com/bar",
 			want: ignition_config_types_32.File{
 				Node: ignition_config_types_32.Node{Path: "/etc/ironic-python-agent.conf", Overwrite: &expectedOverwrite},
 				FileEmbedded1: ignition_config_types_32.FileEmbedded1{
 					Contents: ignition_config_types_32.Resource{
-						Source: pointer.StringPtr("data:text/plain,%0A%5BDEFAULT%5D%0Aapi_url%20%3D%20http%3A%2F%2Fexample.com%2Ffoo%3A6385%0Ainspection_callback_url%20%3D%20http%3A%2F%2Fexample.com%2Fbar%3A5050%2Fv1%2Fcontinue%0Ainsecure%20%3D%20True%0Aenable_vlan_interfaces%20%3D%20all%0A")},
+						Source: pointer.StringPtr("data:text/plain,%0A%5BDEFAULT%5D%0Aapi_url%20%3D%20http%3A%2F%2Fexample.com%2Ffoo%0Ainspection_callback_url%20%3D%20http%3A%2F%2Fexample.com%2Fbar%2Fv1%2Fcontinue%0Ainsecure%20%3D%20True%0Aenable_vlan_interfaces%20%3D%20all%0A")},
+					Mode: &expectedMode},
+			},
+		},
+		{
+			name:                   "built-in inspection",
+			ironicBaseURL:          "https://ironic.example.com:8443/",
+			ironicInspectorBaseURL: "https://ironic.example.com:8443/",
+			inspectionMode:         v1alpha1.InspectionModeIronic,
+			want: ignition_config_types_32.File{
+				Node: ignition_config_types_32.Node{Path: "/etc/ironic-python-agent.conf", Overwrite: &expectedOverwrite},
+				FileEmbedded1: ignition_config_types_32.FileEmbedded1{
+					Contents: ignition_config_types_32.Resource{
+						Source: pointer.StringPtr("data:text/plain,%0A%5BDEFAULT%5D%0Aapi_url%20%3D%20https%3A%2F%2Fironic.example.com%3A8443%0Ainspection_callback_url%20%3D%20https%3A%2F%2Fironic.example.com%3A8443%2Fv1%2Fcontinue_inspection%0Ainsecure%20%3D%20True%0Aenable_vlan_interfaces%20%3D%20all%0A")},
+					Mode: &expectedMode},
+			},
+		},
+		{
+			name:                   "inspection disabled",
+			ironicBaseURL:          "http://192.0.2.1",
+			ironicInspectorBaseURL: "http://192.0.2.1",
+			inspectionMode:         v1alpha1.InspectionModeDisabled,
+			want: ignition_config_types_32.File{
+				Node: ignition_config_types_32.Node{Path: "/etc/ironic-python-agent.conf", Overwrite: &expectedOverwrite},
+				FileEmbedded1: ignition_config_types_32.FileEmbedded1{
+					Contents: ignition_config_types_32.Resource{
+						Source: pointer.StringPtr("data:text/plain,%0A%5BDEFAULT%5D%0Aapi_url%20%3D%20http%3A%2F%2F192.0.2.1%3A6385%0Ainsecure%20%3D%20True%0A")},
+					Mode: &expectedMode},
+			},
+		},
+		{
+			name:                   "resolved",
+			ironicBaseURL:          "https://192.0.2.1:6385",
+			ironicInspectorBaseURL: "https://[2001:db8::1]:5050/",
+			want: ignition_config_types_32.File{
+				Node: ignition_config_types_32.Node{Path: "/etc/ironic-python-agent.conf", Overwrite: &expectedOverwrite},
+				FileEmbedded1: ignition_config_types_32.FileEmbedded1{
+					Contents: ignition_config_types_32.Resource{
+						Source: pointer.StringPtr("data:text/plain,%0A%5BDEFAULT%5D%0Aapi_url%20%3D%20https%3A%2F%2F192.0.2.1%3A6385%0Ainspection_callback_url%20%3D%20https%3A%2F%2F%5B2001%3Adb8%3A%3A1%5D%3A5050%2Fv1%2Fcontinue%0Ainsecure%20%3D%20True%0Aenable_vlan_interfaces%20%3D%20all%0A")},
 					Mode: &expectedMode},
 			},
 		},
@@ -37,6 +90,7 @@ func TestIronicPythonAgentConf(t *testing.T) {
 			b := &ignitionBuilder{
 				ironicBaseURL:          tt.ironicBaseURL,
 				ironicInspectorBaseURL: tt.ironicInspectorBaseURL,
+				inspectionMode:         tt.inspectionMode,
 			}
 			if got := b.IronicAgentConf(); !reflect.DeepEqual(got, tt.want) {
 				t.Error(cmp.Diff(tt.want, got))
@@ -51,6 +105,7 @@ func TestIronicAgentService(t *testing.T) {
 		ironicAgentImage      string
 		ironicAgentPullSecret string
 		copyNetwork           bool
+		agentRuntime          *v1alpha1.AgentRuntimeConfig
 		want                  ignition_config_types_32.Unit
 	}{
 		{
@@ -83,6 +138,41 @@ func TestIronicAgentService(t *testing.T) {
 				Contents: pointer.StringPtr("[Unit]\nDescription=Ironic Agent\nAfter=network-online.target\nWants=network-online.target\n[Service]\nEnvironment=\"HTTP_PROXY=\"\nEnvironment=\"HTTPS_PROXY=\"\nEnvironment=\"NO_PROXY=\"\nTimeoutStartSec=0\nRestart=on-failure\nRestartSec=5\nStartLimitIntervalSec=0\nExecStartPre=/bin/podman pull http://example.com/foo:latest --tls-verify=false --authfile=/etc/authfile.json\nExecStart=/bin/podman run --rm --privileged --network host --mount type=bind,src=/etc/ironic-python-agent.conf,dst=/etc/ironic-python-agent/ignition.conf --mount type=bind,src=/dev,dst=/dev --mount type=bind,src=/sys,dst=/sys --mount type=bind,src=/run/dbus/system_bus_socket,dst=/run/dbus/system_bus_socket --mount type=bind,src=/,dst=/mnt/coreos --mount type=bind,src=/run/udev,dst=/run/udev --ipc=host --uts=host --env \"IPA_COREOS_IP_OPTIONS=ip=dhcp6\" --env IPA_COREOS_COPY_NETWORK=true --env \"IPA_DEFAULT_HOSTNAME=my-host\" --name ironic-agent http://example.com/foo:latest\n[Install]\nWantedBy=multi-user.target\n"),
 			},
 		},
+		{
+			name:                  "pull if not present",
+			ironicAgentImage:      "http://example.com/foo:latest",
+			ironicAgentPullSecret: "foo",
+			agentRuntime: &v1alpha1.AgentRuntimeConfig{
+				PullPolicy: v1alpha1.PullIfNotPresent,
+			},
+			want: ignition_config_types_32.Unit{
+				Name:     "ironic-agent.service",
+				Enabled:  pointer.BoolPtr(true),
+				Contents: pointer.StringPtr("[Unit]\nDescription=Ironic Agent\nAfter=network-online.target\nWants=network-online.target\n[Service]\nEnvironment=\"HTTP_PROXY=\"\nEnvironment=\"HTTPS_PROXY=\"\nEnvironment=\"NO_PROXY=\"\nTimeoutStartSec=0\nRestart=on-failure\nRestartSec=5\nStartLimitIntervalSec=0\nExecStart=/bin/podman run --rm --privileged --network host --mount type=bind,src=/etc/ironic-python-agent.conf,dst=/etc/ironic-python-agent/ignition.conf --mount type=bind,src=/dev,dst=/dev --mount type=bind,src=/sys,dst=/sys --mount type=bind,src=/run/dbus/system_bus_socket,dst=/run/dbus/system_bus_socket --mount type=bind,src=/,dst=/mnt/coreos --mount type=bind,src=/run/udev,dst=/run/udev --ipc=host --uts=host --env \"IPA_COREOS_IP_OPTIONS=ip=dhcp6\" --env IPA_COREOS_COPY_NETWORK=false --env \"IPA_DEFAULT_HOSTNAME=my-host\" --pull=missing --tls-verify=false --authfile=/etc/authfile.json --name ironic-agent http://example.com/foo:latest\n[Install]\nWantedBy=multi-user.target\n"),
+			},
+		},
+		{
+			name:             "runtime options",
+			ironicAgentImage: "http://example.com/foo:latest",
+			agentRuntime: &v1alpha1.AgentRuntimeConfig{
+				Mounts: []v1alpha1.AgentMount{
+					{HostPath: "/opt/vendor", ReadOnly: true},
+					{HostPath: "/var/tmp/raid", MountPath: "/tmp/raid"},
+				},
+				Devices:     []string{"/dev/ipmi0"},
+				Env:         []v1alpha1.AgentEnvVar{{Name: "VENDOR_OPTS", Value: `--verbose "all" 100%`}},
+				PodmanFlags: []string{"--cap-add=SYS_RAWIO"},
+				PullPolicy:  v1alpha1.PullNever,
+				Restart:     "always",
+				After:       []string{"vendor-setup.service"},
+				Requires:    []string{"vendor-setup.service"},
+			},
+			want: ignition_config_types_32.Unit{
+				Name:     "ironic-agent.service",
+				Enabled:  pointer.BoolPtr(true),
+				Contents: pointer.StringPtr("[Unit]\nDescription=Ironic Agent\nAfter=network-online.target\nWants=network-online.target\nAfter=vendor-setup.service\nRequires=vendor-setup.service\n[Service]\nEnvironment=\"HTTP_PROXY=\"\nEnvironment=\"HTTPS_PROXY=\"\nEnvironment=\"NO_PROXY=\"\nTimeoutStartSec=0\nRestart=always\nRestartSec=5\nStartLimitIntervalSec=0\nExecStart=/bin/podman run --rm --privileged --network host --mount type=bind,src=/etc/ironic-python-agent.conf,dst=/etc/ironic-python-agent/ignition.conf --mount type=bind,src=/dev,dst=/dev --mount type=bind,src=/sys,dst=/sys --mount type=bind,src=/run/dbus/system_bus_socket,dst=/run/dbus/system_bus_socket --mount type=bind,src=/,dst=/mnt/coreos --mount type=bind,src=/run/udev,dst=/run/udev --ipc=host --uts=host --env \"IPA_COREOS_IP_OPTIONS=ip=dhcp6\" --env IPA_COREOS_COPY_NETWORK=false --env \"IPA_DEFAULT_HOSTNAME=my-host\" --mount type=bind,src=/opt/vendor,dst=/opt/vendor,ro=true --mount type=bind,src=/var/tmp/raid,dst=/tmp/raid --device /dev/ipmi0 --env \"VENDOR_OPTS=--verbose \\\"all\\\" 100%%\" --pull=never --cap-add=SYS_RAWIO --name ironic-agent http://example.com/foo:latest\n[Install]\nWantedBy=multi-user.target\n"),
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -91,6 +181,7 @@ func TestIronicAgentService(t *testing.T) {
 				ironicAgentPullSecret: tt.ironicAgentPullSecret,
 				ipOptions:             "ip=dhcp6",
 				hostname:              "my-host",
+				agentRuntime:          tt.agentRuntime,
 			}
 			if got := b.IronicAgentService(tt.copyNetwork); !reflect.DeepEqual(got, tt.want) {
 				t.Error(cmp.Diff(tt.want, got))
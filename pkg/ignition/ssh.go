@@ -0,0 +1,33 @@
+package ignition
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	ignition_config_types_32 "github.com/coreos/ignition/v2/config/v3_2/types"
+)
+
+// SSHHostKeys causes the ramdisk to use the given SSH host keys, indexed by
+// file name in /etc/ssh, instead of generating new ones at boot.
+func (b *ignitionBuilder) SSHHostKeys(keys map[string][]byte) {
+	b.sshHostKeys = keys
+}
+
+func (b *ignitionBuilder) sshHostKeyFiles() []ignition_config_types_32.File {
+	names := make([]string, 0, len(b.sshHostKeys))
+	for name := range b.sshHostKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := make([]ignition_config_types_32.File, 0, len(names))
+	for _, name := range names {
+		mode := 0600
+		if strings.HasSuffix(name, ".pub") {
+			mode = 0644
+		}
+		files = append(files, ignitionFileEmbed(path.Join("/etc/ssh", name), mode, true, b.sshHostKeys[name]))
+	}
+	return files
+}
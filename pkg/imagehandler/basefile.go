@@ -1,14 +1,19 @@
 package imagehandler
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 
 	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+	"github.com/openshift/assisted-image-service/pkg/overlay"
 )
 
+const ignitionImagePath = "/images/ignition.img"
+
 type baseFile interface {
 	Size() (int64, error)
-	InsertIgnition(*isoeditor.IgnitionContent) (isoeditor.ImageReader, error)
+	InsertIgnition(ignition *isoeditor.IgnitionContent, networkArchive []byte, kernelArgs string) (isoeditor.ImageReader, error)
 }
 
 type baseFileData struct {
@@ -35,8 +40,49 @@ func newBaseIso(filename string) *baseIso {
 	return &baseIso{baseFileData{filename: filename}}
 }
 
-func (biso *baseIso) InsertIgnition(ignition *isoeditor.IgnitionContent) (isoeditor.ImageReader, error) {
-	return isoeditor.NewRHCOSStreamReader(biso.filename, ignition, nil, nil)
+func (biso *baseIso) InsertIgnition(ignitionContent *isoeditor.IgnitionContent, networkArchive []byte, kernelArgs string) (isoeditor.ImageReader, error) {
+	var kargs []byte
+	if kernelArgs != "" {
+		kargs = []byte(fmt.Sprintf(" %s\n", kernelArgs))
+	}
+	if len(networkArchive) == 0 {
+		return isoeditor.NewRHCOSStreamReader(biso.filename, ignitionContent, nil, kargs)
+	}
+
+	// The keyfiles are added to the ignition embed area as a second archive,
+	// as coreos-installer does for `iso network embed`.
+	ignitionArchive, err := ignitionContent.Archive()
+	if err != nil {
+		return nil, err
+	}
+	content := &bytes.Buffer{}
+	if _, err := ignitionArchive.WriteTo(content); err != nil {
+		return nil, err
+	}
+	content.Write(networkArchive)
+
+	start, length, err := isoeditor.GetISOFileInfo(ignitionImagePath, biso.filename)
+	if err != nil {
+		return nil, err
+	}
+	if int64(content.Len()) > length {
+		return nil, fmt.Errorf("ignition and network config (%d bytes) exceed embed area size (%d bytes)", content.Len(), length)
+	}
+
+	r, err := isoeditor.NewRHCOSStreamReader(biso.filename, ignitionContent, nil, kargs)
+	if err != nil {
+		return nil, err
+	}
+	withNetwork, err := overlay.NewOverlayReader(r, overlay.Overlay{
+		Reader: bytes.NewReader(content.Bytes()),
+		Offset: start,
+		Length: int64(content.Len()),
+	})
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return withNetwork, nil
 }
 
 type baseInitramfs struct {
@@ -47,6 +93,19 @@ func newBaseInitramfs(filename string) *baseInitramfs {
 	return &baseInitramfs{baseFileData{filename: filename}}
 }
 
-func (birfs *baseInitramfs) InsertIgnition(ignition *isoeditor.IgnitionContent) (isoeditor.ImageReader, error) {
-	return isoeditor.NewInitRamFSStreamReader(birfs.filename, ignition)
+func (birfs *baseInitramfs) InsertIgnition(ignitionContent *isoeditor.IgnitionContent, networkArchive []byte, kernelArgs string) (isoeditor.ImageReader, error) {
+	// The kernel arguments cannot be embedded, so they are passed by
+	// whatever boots the initramfs
+	r, err := isoeditor.NewInitRamFSStreamReader(birfs.filename, ignitionContent)
+	if err != nil || len(networkArchive) == 0 {
+		return r, err
+	}
+
+	// The kernel unpacks each of the concatenated archives in turn
+	withNetwork, err := overlay.NewAppendReader(r, bytes.NewReader(networkArchive))
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return withNetwork, nil
 }
@@ -0,0 +1,258 @@
+package imagehandler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Download describes the progress of the download of an image by a single
+// client.
+type Download struct {
+	// Path is the URL path of the image.
+	Path string
+	// ClientAddress is the IP address of the client.
+	ClientAddress string
+	// Size is the size of the image, if known.
+	Size int64
+	// Bytes is the number of bytes of the image sent to the client so far,
+	// across all requests.
+	Bytes int64
+	// Started is the time of the first request for the image.
+	Started time.Time
+}
+
+// DownloadObserver is notified when clients download images. A download
+// starts with the first request from a client for an image, and completes
+// once every byte of the image has been sent to the client, which may take
+// many range requests.
+type DownloadObserver interface {
+	DownloadStarted(download Download)
+	DownloadCompleted(download Download)
+}
+
+// downloadIdleTimeout is how long an incomplete download is tracked after
+// the last request for it, e.g. when the client gives up or the image is
+// discarded.
+const downloadIdleTimeout = time.Hour
+
+type downloadID struct {
+	path   string
+	client string
+}
+
+// byteRange is a half-open range of bytes [start, end).
+type byteRange struct {
+	start, end int64
+}
+
+type downloadProgress struct {
+	Download
+	covered    []byteRange
+	lastActive time.Time
+}
+
+// addRange records that a range of bytes has been sent, and returns whether
+// the whole image has now been sent.
+func (p *downloadProgress) addRange(r byteRange) bool {
+	if r.end <= r.start {
+		return p.complete()
+	}
+	covered := append(p.covered, r)
+	sort.Slice(covered, func(i, j int) bool { return covered[i].start < covered[j].start })
+
+	merged := covered[:1]
+	for _, next := range covered[1:] {
+		last := &merged[len(merged)-1]
+		if next.start <= last.end {
+			if next.end > last.end {
+				last.end = next.end
+			}
+			continue
+		}
+		merged = append(merged, next)
+	}
+	p.covered = merged
+	return p.complete()
+}
+
+func (p *downloadProgress) complete() bool {
+	return p.Size > 0 && len(p.covered) == 1 &&
+		p.covered[0].start == 0 && p.covered[0].end >= p.Size
+}
+
+// downloadTracker is an http.Handler that reports the downloads of the files
+// served by another handler to a DownloadObserver.
+type downloadTracker struct {
+	next     http.Handler
+	observer DownloadObserver
+
+	downloads map[downloadID]*downloadProgress
+	lastSweep time.Time
+	mu        sync.Mutex
+
+	// now returns the current time; it is replaced in tests.
+	now func() time.Time
+}
+
+// TrackDownloads returns an http.Handler that serves files using the given
+// handler, notifying the observer of the progress of each download.
+func TrackDownloads(next http.Handler, observer DownloadObserver) http.Handler {
+	return &downloadTracker{
+		next:      next,
+		observer:  observer,
+		downloads: map[downloadID]*downloadProgress{},
+		now:       time.Now,
+	}
+}
+
+func (t *downloadTracker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		t.next.ServeHTTP(w, req)
+		return
+	}
+
+	client, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		client = req.RemoteAddr
+	}
+	id := downloadID{path: req.URL.Path, client: client}
+
+	cw := &countingWriter{ResponseWriter: w}
+	cw.onHeader = func(status int) {
+		if status == http.StatusOK || status == http.StatusPartialContent {
+			t.start(id, cw.Header())
+		}
+	}
+	t.next.ServeHTTP(cw, req)
+
+	switch cw.status {
+	case http.StatusOK:
+		t.finish(id, byteRange{0, cw.written}, cw.written)
+	case http.StatusPartialContent:
+		if start, _, ok := parseContentRange(cw.Header().Get("Content-Range")); ok {
+			t.finish(id, byteRange{start, start + cw.written}, cw.written)
+		} else {
+			// Multiple ranges in a multipart response are not tracked
+			t.finish(id, byteRange{}, cw.written)
+		}
+	}
+}
+
+// start records the start of a request for an image, which is the start of a
+// new download unless the client is already downloading it.
+func (t *downloadTracker) start(id downloadID, header http.Header) {
+	size := int64(-1)
+	if _, total, ok := parseContentRange(header.Get("Content-Range")); ok {
+		size = total
+	} else if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+		size = length
+	}
+
+	now := t.now()
+	t.mu.Lock()
+	t.evictIdle(now)
+	progress, exists := t.downloads[id]
+	started := !exists
+	if started {
+		progress = &downloadProgress{
+			Download: Download{
+				Path:          id.path,
+				ClientAddress: id.client,
+				Started:       now,
+			},
+		}
+		t.downloads[id] = progress
+	}
+	progress.lastActive = now
+	if size >= 0 {
+		progress.Size = size
+	}
+	download := progress.Download
+	t.mu.Unlock()
+
+	if started {
+		t.observer.DownloadStarted(download)
+	}
+}
+
+// finish records the bytes sent in response to a request for an image.
+func (t *downloadTracker) finish(id downloadID, sent byteRange, written int64) {
+	t.mu.Lock()
+	progress, exists := t.downloads[id]
+	if !exists {
+		t.mu.Unlock()
+		return
+	}
+	progress.Bytes += written
+	completed := progress.addRange(sent)
+	if completed {
+		// A further request from the client starts a new download
+		delete(t.downloads, id)
+	}
+	download := progress.Download
+	t.mu.Unlock()
+
+	if completed {
+		t.observer.DownloadCompleted(download)
+	}
+}
+
+// evictIdle stops tracking the downloads that have had no requests for
+// downloadIdleTimeout. The downloads are checked at most once a minute. The
+// caller must hold the lock.
+func (t *downloadTracker) evictIdle(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+	for id, progress := range t.downloads {
+		if now.Sub(progress.lastActive) > downloadIdleTimeout {
+			delete(t.downloads, id)
+		}
+	}
+}
+
+// parseContentRange parses a Content-Range header of the form
+// "bytes start-end/size".
+func parseContentRange(value string) (start, size int64, ok bool) {
+	var end int64
+	if !strings.HasPrefix(value, "bytes ") {
+		return 0, 0, false
+	}
+	if _, err := fmt.Sscanf(value, "bytes %d-%d/%d", &start, &end, &size); err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
+
+// countingWriter is an http.ResponseWriter that records the status and the
+// number of bytes of the response body.
+type countingWriter struct {
+	http.ResponseWriter
+	status   int
+	written  int64
+	onHeader func(status int)
+}
+
+func (w *countingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.onHeader(status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,124 @@
+package imagehandler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeObserver struct {
+	started   []Download
+	completed []Download
+}
+
+func (o *fakeObserver) DownloadStarted(download Download) { o.started = append(o.started, download) }
+func (o *fakeObserver) DownloadCompleted(download Download) {
+	o.completed = append(o.completed, download)
+}
+
+func TestTrackDownloads(t *testing.T) {
+	content := "0123456789abcdefghij"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/image.iso" {
+			http.NotFound(w, req)
+			return
+		}
+		http.ServeContent(w, req, "image.iso", time.Time{}, strings.NewReader(content))
+	})
+	observer := &fakeObserver{}
+	tracker := TrackDownloads(handler, observer)
+
+	get := func(path, client, byteRange string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = client + ":12345"
+		if byteRange != "" {
+			req.Header.Set("Range", byteRange)
+		}
+		tracker.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	get("/missing.iso", "192.0.2.1", "")
+	if len(observer.started) != 0 {
+		t.Fatalf("download of missing image started")
+	}
+
+	// A BMC downloads the image in overlapping chunks, out of order
+	get("/image.iso", "192.0.2.1", "bytes=10-19")
+	if len(observer.started) != 1 || observer.started[0].ClientAddress != "192.0.2.1" ||
+		observer.started[0].Size != int64(len(content)) {
+		t.Fatalf("unexpected downloads started %+v", observer.started)
+	}
+	get("/image.iso", "192.0.2.1", "bytes=0-5")
+	if len(observer.completed) != 0 {
+		t.Fatalf("download completed with missing bytes")
+	}
+	get("/image.iso", "192.0.2.1", "bytes=4-11")
+	if len(observer.started) != 1 {
+		t.Errorf("expected a single download, got %d", len(observer.started))
+	}
+	if len(observer.completed) != 1 {
+		t.Fatalf("expected download to complete")
+	}
+	if observer.completed[0].Bytes != 24 {
+		t.Errorf("unexpected bytes sent %d", observer.completed[0].Bytes)
+	}
+
+	// Another client downloads the whole image at once
+	get("/image.iso", "192.0.2.2", "")
+	if len(observer.started) != 2 || len(observer.completed) != 2 {
+		t.Fatalf("expected second download to start and complete")
+	}
+	if observer.completed[1].ClientAddress != "192.0.2.2" || observer.completed[1].Bytes != int64(len(content)) {
+		t.Errorf("unexpected download %+v", observer.completed[1])
+	}
+
+	// Downloading again starts a new download
+	get("/image.iso", "192.0.2.1", "bytes=0-1")
+	if len(observer.started) != 3 {
+		t.Errorf("expected a new download to start")
+	}
+}
+
+func TestTrackDownloadsEviction(t *testing.T) {
+	content := "0123456789"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.ServeContent(w, req, "image.iso", time.Time{}, strings.NewReader(content))
+	})
+	now := time.Now()
+	tracker := TrackDownloads(handler, &fakeObserver{}).(*downloadTracker)
+	tracker.now = func() time.Time { return now }
+
+	get := func(path, client, byteRange string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = client + ":12345"
+		if byteRange != "" {
+			req.Header.Set("Range", byteRange)
+		}
+		tracker.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Completed downloads are no longer tracked
+	get("/image.iso", "192.0.2.1", "")
+	if len(tracker.downloads) != 0 {
+		t.Errorf("completed download still tracked")
+	}
+
+	// Incomplete downloads are tracked until they are idle
+	get("/image.iso", "192.0.2.1", "bytes=0-1")
+	get("/other.iso", "192.0.2.2", "bytes=0-1")
+	if len(tracker.downloads) != 2 {
+		t.Fatalf("expected 2 downloads tracked, got %d", len(tracker.downloads))
+	}
+	now = now.Add(downloadIdleTimeout / 2)
+	get("/other.iso", "192.0.2.2", "bytes=2-3")
+	now = now.Add(downloadIdleTimeout/2 + time.Minute)
+	get("/new.iso", "192.0.2.3", "bytes=0-1")
+	if _, exists := tracker.downloads[downloadID{path: "/image.iso", client: "192.0.2.1"}]; exists {
+		t.Errorf("idle download still tracked")
+	}
+	if len(tracker.downloads) != 2 {
+		t.Errorf("expected 2 downloads tracked, got %d", len(tracker.downloads))
+	}
+}
@@ -0,0 +1,66 @@
+package imagehandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"path"
+	"sort"
+
+	"github.com/cavaliercoder/go-cpio"
+)
+
+// firstbootNetworkDir is the directory in the initramfs from which CoreOS
+// copies NetworkManager keyfiles for use in the initramfs and on first boot.
+const firstbootNetworkDir = "etc/coreos-firstboot-network"
+
+// FirstbootNetwork is the network configuration for the initramfs of an
+// image.
+type FirstbootNetwork struct {
+	// Keyfiles are the NetworkManager keyfiles to add to the initramfs,
+	// indexed by file name.
+	Keyfiles map[string][]byte
+	// KernelArgs are the kernel arguments with which the initramfs uses
+	// them. They are embedded in ISOs; an initramfs must be booted with them.
+	KernelArgs string
+}
+
+// firstbootNetworkArchive returns a compressed CPIO archive containing the
+// given NetworkManager keyfiles in the firstboot network directory.
+func firstbootNetworkArchive(keyfiles map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(keyfiles))
+	for name := range keyfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buf)
+	cpioWriter := cpio.NewWriter(gzipWriter)
+
+	for _, dir := range []string{"etc", firstbootNetworkDir} {
+		if err := cpioWriter.WriteHeader(&cpio.Header{Name: dir, Mode: cpio.ModeDir | 0o755}); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		data := keyfiles[name]
+		if err := cpioWriter.WriteHeader(&cpio.Header{
+			Name: path.Join(firstbootNetworkDir, name),
+			Mode: cpio.ModeRegular | 0o600,
+			Size: int64(len(data)),
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := cpioWriter.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cpioWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package imagehandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cavaliercoder/go-cpio"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// readArchive returns the regular files in a compressed CPIO archive.
+func readArchive(t *testing.T, data []byte) map[string]string {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipReader.Multistream(false)
+	cpioReader := cpio.NewReader(gzipReader)
+	files := map[string]string{}
+	for {
+		header, err := cpioReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Mode.IsRegular() {
+			contents, err := io.ReadAll(cpioReader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[header.Name] = string(contents)
+		}
+	}
+	return files
+}
+
+func TestFirstbootNetworkArchive(t *testing.T) {
+	archive, err := firstbootNetworkArchive(map[string][]byte{
+		"eth1.nmconnection": []byte("[connection]\nid=eth1\n"),
+		"eth0.nmconnection": []byte("[connection]\nid=eth0\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := readArchive(t, archive)
+	expected := map[string]string{
+		"etc/coreos-firstboot-network/eth0.nmconnection": "[connection]\nid=eth0\n",
+		"etc/coreos-firstboot-network/eth1.nmconnection": "[connection]\nid=eth1\n",
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("unexpected files %v", files)
+	}
+	for name, contents := range expected {
+		if files[name] != contents {
+			t.Errorf("expected %s to contain %q, got %q", name, contents, files[name])
+		}
+	}
+}
+
+func TestInitramfsWithNetwork(t *testing.T) {
+	base := []byte("base initramfs")
+	filename := filepath.Join(t.TempDir(), "initramfs.img")
+	if err := os.WriteFile(filename, base, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ignitionConfig := []byte(`{"ignition":{"version":"3.2.0"}}`)
+	baseURL, _ := url.Parse("http://localhost:8080")
+	handler := NewImageHandler(zap.New(zap.UseDevMode(true)), "dummyfile.iso", filename, baseURL)
+
+	_, err := handler.ServeImage("host.initramfs", ignitionConfig, FirstbootNetwork{
+		Keyfiles:   map[string][]byte{"eth0.nmconnection": []byte("[connection]\nid=eth0\n")},
+		KernelArgs: "rd.neednet=1",
+	}, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := handler.FileSystem().Open("/host.initramfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ignitionArchive, err := (&isoeditor.IgnitionContent{Config: ignitionConfig}).Archive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ignitionLength := ignitionArchive.Size()
+	if !bytes.HasPrefix(data, base) {
+		t.Fatal("base initramfs was modified")
+	}
+	files := readArchive(t, data[len(base)+int(ignitionLength):])
+	if files["etc/coreos-firstboot-network/eth0.nmconnection"] != "[connection]\nid=eth0\n" {
+		t.Errorf("keyfile not found in %v", files)
+	}
+}
@@ -27,6 +27,8 @@ type imageFile struct {
 	name            string
 	size            int64
 	ignitionContent []byte
+	networkArchive  []byte
+	kernelArgs      string
 	imageReader     isoeditor.ImageReader
 	initramfs       bool
 }
@@ -42,7 +44,7 @@ func (f *imageFile) Init(inputFile baseFile) error {
 
 	var err error
 	ignition := &isoeditor.IgnitionContent{Config: f.ignitionContent}
-	f.imageReader, err = inputFile.InsertIgnition(ignition)
+	f.imageReader, err = inputFile.InsertIgnition(ignition, f.networkArchive, f.kernelArgs)
 	if err != nil {
 		return err
 	}
@@ -14,6 +14,7 @@ limitations under the License.
 package imagehandler
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -52,7 +53,7 @@ var _ http.FileSystem = &imageFileSystem{}
 
 type ImageHandler interface {
 	FileSystem() http.FileSystem
-	ServeImage(key string, ignitionContent []byte, initramfs, static bool) (string, error)
+	ServeImage(key string, ignitionContent []byte, network FirstbootNetwork, initramfs, static bool) (string, error)
 	RemoveImage(key string)
 }
 
@@ -80,18 +81,18 @@ func (f *imageFileSystem) getBaseImage(initramfs bool) baseFile {
 	}
 }
 
-func (f *imageFileSystem) getNameForKey(key string) (name string, err error) {
+// getNameForKey returns the name under which to serve an image. The name is
+// derived from the key and the content, so that every replica of the
+// controller serves the same image at the same URL.
+func (f *imageFileSystem) getNameForKey(key string, ignitionContent []byte) string {
 	if img, exists := f.images[key]; exists {
-		return img.name, nil
+		return img.name
 	}
-	rand, err := uuid.NewRandom()
-	if err == nil {
-		name = rand.String()
-	}
-	return
+	data := append([]byte(key+"\x00"), ignitionContent...)
+	return uuid.NewSHA1(uuid.NameSpaceURL, data).String()
 }
 
-func (f *imageFileSystem) ServeImage(key string, ignitionContent []byte, initramfs, static bool) (string, error) {
+func (f *imageFileSystem) ServeImage(key string, ignitionContent []byte, network FirstbootNetwork, initramfs, static bool) (string, error) {
 	size, err := f.getBaseImage(initramfs).Size()
 	if err != nil {
 		return "", InvalidBaseImageError{cause: err}
@@ -100,12 +101,17 @@ func (f *imageFileSystem) ServeImage(key string, ignitionContent []byte, initram
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	if img, exists := f.images[key]; exists && !bytes.Equal(img.ignitionContent, ignitionContent) {
+		// The content has changed, so replace the image (under a new name,
+		// unless it is static). The network configuration is derived from
+		// the same inputs as the ignition, so it cannot change on its own.
+		delete(f.keys, img.name)
+		delete(f.images, key)
+	}
+
 	name := key
 	if !static {
-		name, err = f.getNameForKey(key)
-		if err != nil {
-			return "", err
-		}
+		name = f.getNameForKey(key, ignitionContent)
 	}
 	p, err := url.Parse(fmt.Sprintf("/%s", name))
 	if err != nil {
@@ -113,11 +119,19 @@ func (f *imageFileSystem) ServeImage(key string, ignitionContent []byte, initram
 	}
 
 	if _, exists := f.images[key]; !exists {
+		var networkArchive []byte
+		if len(network.Keyfiles) > 0 {
+			if networkArchive, err = firstbootNetworkArchive(network.Keyfiles); err != nil {
+				return "", err
+			}
+		}
 		f.keys[name] = key
 		f.images[key] = &imageFile{
 			name:            name,
 			size:            size,
 			ignitionContent: ignitionContent,
+			networkArchive:  networkArchive,
+			kernelArgs:      network.KernelArgs,
 			initramfs:       initramfs,
 		}
 	}
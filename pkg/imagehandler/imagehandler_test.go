@@ -95,11 +95,11 @@ func TestNewImageHandler(t *testing.T) {
 	ifs.isoFile.size = 12345
 	ifs.initramfsFile.size = 12345
 
-	url1, err := handler.ServeImage("test-key-1", []byte{}, false, false)
+	url1, err := handler.ServeImage("test-key-1", []byte{}, FirstbootNetwork{}, false, false)
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
-	url2, err := handler.ServeImage("test-key-2", []byte{}, true, false)
+	url2, err := handler.ServeImage("test-key-2", []byte{}, FirstbootNetwork{}, true, false)
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
@@ -109,7 +109,7 @@ func TestNewImageHandler(t *testing.T) {
 		t.Errorf("can't look up image file \"%s\"", name2)
 	}
 
-	url1again, err := handler.ServeImage("test-key-1", []byte{}, false, false)
+	url1again, err := handler.ServeImage("test-key-1", []byte{}, FirstbootNetwork{}, false, false)
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
@@ -119,12 +119,63 @@ func TestNewImageHandler(t *testing.T) {
 	}
 
 	handler.RemoveImage("test-key-1")
-	url1yetagain, err := handler.ServeImage("test-key-1", []byte{}, false, false)
+	url1yetagain, err := handler.ServeImage("test-key-1", []byte{}, FirstbootNetwork{}, false, false)
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
-	if url1yetagain == url1 {
-		t.Errorf("same URLs returned after removal: %s", url1yetagain)
+	if url1yetagain != url1 {
+		t.Errorf("URL for same key and content changed after removal: %s %s", url1, url1yetagain)
+	}
+	if url1 == url2 {
+		t.Errorf("same URL returned for different keys: %s", url1)
+	}
+
+	// Another replica serves the same image at the same URL
+	otherHandler := NewImageHandler(zap.New(zap.UseDevMode(true)),
+		"dummyfile.iso",
+		"dummyfile.initramfs",
+		baseUrl)
+	otherHandler.(*imageFileSystem).isoFile.size = 12345
+	url1other, err := otherHandler.ServeImage("test-key-1", []byte{}, FirstbootNetwork{}, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if url1other != url1 {
+		t.Errorf("inconsistent URLs between handlers: %s %s", url1, url1other)
+	}
+}
+
+func TestServeImageContentChanged(t *testing.T) {
+	baseUrl, err := url.Parse("http://base.test:1234")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	handler := NewImageHandler(zap.New(zap.UseDevMode(true)),
+		"dummyfile.iso",
+		"dummyfile.initramfs",
+		baseUrl)
+
+	ifs := handler.(*imageFileSystem)
+	ifs.isoFile.size = 12345
+
+	url1, err := handler.ServeImage("test-key-1", []byte("old"), FirstbootNetwork{}, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	url2, err := handler.ServeImage("test-key-1", []byte("new"), FirstbootNetwork{}, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if url2 == url1 {
+		t.Errorf("same URL returned after content changed: %s", url2)
+	}
+	if ifs.imageFileByName(url1[22:]) != nil {
+		t.Errorf("old image still served after content changed")
+	}
+	img := ifs.imageFileByName(url2[22:])
+	if img == nil || string(img.ignitionContent) != "new" {
+		t.Errorf("new image content not served")
 	}
 }
 
@@ -142,15 +193,15 @@ func TestNewImageHandlerStatic(t *testing.T) {
 	ifs.isoFile.size = 12345
 	ifs.initramfsFile.size = 12345
 
-	url1, err := handler.ServeImage("test-name-1.iso", []byte{}, false, true)
+	url1, err := handler.ServeImage("test-name-1.iso", []byte{}, FirstbootNetwork{}, false, true)
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
-	url2, err := handler.ServeImage("test-name-2.initramfs", []byte{}, true, true)
+	url2, err := handler.ServeImage("test-name-2.initramfs", []byte{}, FirstbootNetwork{}, true, true)
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
-	url1again, err := handler.ServeImage("test-name-1.iso", []byte{}, false, true)
+	url1again, err := handler.ServeImage("test-name-1.iso", []byte{}, FirstbootNetwork{}, false, true)
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
@@ -0,0 +1,95 @@
+package imagehandler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	isoSuffix       = ".iso"
+	initramfsSuffix = ".initramfs"
+)
+
+// SignedImage identifies an image that is published at a signed URL.
+type SignedImage struct {
+	Namespace string
+	Name      string
+	UID       string
+	Initramfs bool
+}
+
+// URLSigner publishes images at URLs that identify the image and are signed,
+// so that an image server can regenerate the image on demand from the URL
+// alone, without holding any state. The path of each URL has the form
+// /<namespace>/<name>/<signature>.<iso|initramfs>. The UID is included in
+// the signature, so that the URL is not valid for a later object with the
+// same name.
+type URLSigner struct {
+	key     []byte
+	baseURL *url.URL
+}
+
+// NewURLSigner returns a URLSigner that signs URLs with the given key.
+func NewURLSigner(key []byte, baseURL *url.URL) *URLSigner {
+	return &URLSigner{key: key, baseURL: baseURL}
+}
+
+// FileName returns the name of the file for an image, which is unique for
+// every image.
+func (s *URLSigner) FileName(image SignedImage) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\x00%s\x00%s\x00%t", image.Namespace, image.Name, image.UID, image.Initramfs)
+	suffix := isoSuffix
+	if image.Initramfs {
+		suffix = initramfsSuffix
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) + suffix
+}
+
+// URL returns the signed URL of an image.
+func (s *URLSigner) URL(image SignedImage) string {
+	p := &url.URL{Path: path.Join("/", image.Namespace, image.Name, s.FileName(image))}
+	return s.baseURL.ResolveReference(p).String()
+}
+
+// Parse returns the image identified by a URL path, without its UID, and the
+// file name. The signature is not verified; use Verify once the UID is known.
+func (s *URLSigner) Parse(urlPath string) (SignedImage, string, error) {
+	parts := strings.Split(strings.Trim(urlPath, "/"), "/")
+	if len(parts) != 3 {
+		return SignedImage{}, "", fmt.Errorf("invalid image path %q", urlPath)
+	}
+
+	image := SignedImage{Namespace: parts[0], Name: parts[1]}
+	fileName := parts[2]
+	switch {
+	case strings.HasSuffix(fileName, initramfsSuffix):
+		image.Initramfs = true
+	case strings.HasSuffix(fileName, isoSuffix):
+	default:
+		return SignedImage{}, "", fmt.Errorf("invalid image path %q", urlPath)
+	}
+	return image, fileName, nil
+}
+
+// Verify checks that the file name of an image matches its signature.
+func (s *URLSigner) Verify(image SignedImage, fileName string) bool {
+	return hmac.Equal([]byte(s.FileName(image)), []byte(fileName))
+}
+
+// ObjectToken returns a token, signed with the key, that authenticates
+// requests concerning an object. The UID is included, so that the token is
+// not valid for a later object with the same name. The purpose distinguishes
+// the tokens for different kinds of request signed with the same key.
+func ObjectToken(key []byte, purpose string, meta metav1.Object) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\x00%s\x00%s\x00%s", purpose, meta.GetNamespace(), meta.GetName(), meta.GetUID())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,74 @@
+package imagehandler
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestURLSigner(t *testing.T) {
+	baseURL, _ := url.Parse("http://images.example.com:8084")
+	signer := NewURLSigner([]byte("key"), baseURL)
+	image := SignedImage{Namespace: "test", Name: "host-0", UID: "uid", Initramfs: true}
+
+	imageURL := signer.URL(image)
+	if !strings.HasPrefix(imageURL, "http://images.example.com:8084/test/host-0/") ||
+		!strings.HasSuffix(imageURL, ".initramfs") {
+		t.Fatalf("unexpected URL %s", imageURL)
+	}
+
+	u, _ := url.Parse(imageURL)
+	parsed, fileName, err := signer.Parse(u.Path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if parsed.Namespace != "test" || parsed.Name != "host-0" || !parsed.Initramfs {
+		t.Errorf("unexpected image %+v", parsed)
+	}
+
+	parsed.UID = "uid"
+	if !signer.Verify(parsed, fileName) {
+		t.Errorf("signature not verified")
+	}
+	parsed.UID = "other-uid"
+	if signer.Verify(parsed, fileName) {
+		t.Errorf("signature verified for a different UID")
+	}
+	parsed.UID = "uid"
+	if NewURLSigner([]byte("other-key"), baseURL).Verify(parsed, fileName) {
+		t.Errorf("signature verified with a different key")
+	}
+	iso := image
+	iso.Initramfs = false
+	if signer.FileName(iso) == fileName {
+		t.Errorf("same file name for different formats")
+	}
+
+	for _, invalid := range []string{"/", "/test/host-0", "/test/host-0/abc.img", "/a/test/host-0/abc.iso"} {
+		if _, _, err := signer.Parse(invalid); err == nil {
+			t.Errorf("expected error parsing %s", invalid)
+		}
+	}
+}
+
+func TestObjectToken(t *testing.T) {
+	meta := &metav1.ObjectMeta{Namespace: "test", Name: "host-0", UID: "uid"}
+	token := ObjectToken([]byte("key"), "progress", meta)
+	if token != ObjectToken([]byte("key"), "progress", meta.DeepCopy()) {
+		t.Errorf("token is not stable")
+	}
+
+	recreated := meta.DeepCopy()
+	recreated.UID = "other-uid"
+	for name, other := range map[string]string{
+		"key":     ObjectToken([]byte("other-key"), "progress", meta),
+		"purpose": ObjectToken([]byte("key"), "log-upload", meta),
+		"UID":     ObjectToken([]byte("key"), "progress", recreated),
+	} {
+		if other == token {
+			t.Errorf("same token for a different %s", name)
+		}
+	}
+}
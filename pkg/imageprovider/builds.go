@@ -0,0 +1,214 @@
+package imageprovider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/metal3-io/baremetal-operator/pkg/imageprovider"
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/config"
+	"github.com/openshift/image-customization-controller/pkg/debug"
+	"github.com/openshift/image-customization-controller/pkg/env"
+	"github.com/openshift/image-customization-controller/pkg/ignition"
+	"github.com/openshift/image-customization-controller/pkg/imagehandler"
+	"github.com/openshift/image-customization-controller/pkg/sshkeys"
+)
+
+// imageBuild tracks an ignition build for a single image that runs in the
+// background.
+type imageBuild struct {
+	inputHash string
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	// content and err must not be accessed until done is closed.
+	content imageContent
+	err     error
+}
+
+// imageContent is the output of an ignition build.
+type imageContent struct {
+	ignition []byte
+	// network is the network configuration for the initramfs.
+	network imagehandler.FirstbootNetwork
+}
+
+func (b *imageBuild) finished() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// imageParams holds the inputs to an ignition build that are specific to a
+// single image.
+type imageParams struct {
+	Hostname      string
+	InterfaceMACs map[string]string
+	ProgressURL   string
+	ProgressToken string
+	LogUploadURL  string
+	// DebugPasswordHash is set when debugging is enabled for the image.
+	DebugPasswordHash string
+	// SSHAuthorizedKeys are authorized to log in to this image only.
+	SSHAuthorizedKeys string
+	// SSHHostKeys holds the host key files to install in /etc/ssh.
+	SSHHostKeys map[string][]byte
+	// NTPServers are the NTP servers to synchronize the clock with.
+	NTPServers []string
+	// NetworkFiles holds the files referenced by the network data, indexed
+	// by path.
+	NetworkFiles map[string][]byte
+	// HostnameSources are the configured sources of the hostname, and
+	// Hostnames the names of those known when the image is built.
+	HostnameSources []v1alpha1.HostnameSource
+	Hostnames       map[v1alpha1.HostnameSource]string
+}
+
+// buildInputHash returns a digest of all of the inputs to an ignition build.
+func (ip *rhcosImageProvider) buildInputHash(cfg *config.Config, networkData imageprovider.NetworkData, params imageParams) (string, error) {
+	data, err := json.Marshal(struct {
+		NMState           []byte
+		RegistriesConf    []byte
+		RegistriesDropins map[string][]byte
+		RegistryCerts     map[string][]byte
+		ContainerTrust    map[string][]byte
+		Env               *env.EnvInputs
+		AgentRuntime      *v1alpha1.AgentRuntimeConfig
+		RegistryMirrors   []v1alpha1.RegistryMirror
+		InspectionMode    v1alpha1.InspectionMode
+		NetworkFallback   time.Duration
+		Params            imageParams
+	}{
+		NMState:           networkData["nmstate"],
+		RegistriesConf:    ip.RegistriesConf,
+		RegistriesDropins: ip.RegistriesDropins,
+		RegistryCerts:     ip.RegistryCerts,
+		ContainerTrust:    ip.ContainerTrust,
+		Env:               cfg.Inputs,
+		AgentRuntime:      cfg.AgentRuntime,
+		RegistryMirrors:   cfg.RegistryMirrors,
+		InspectionMode:    cfg.InspectionMode,
+		NetworkFallback:   cfg.NetworkFallbackTimeout,
+		Params:            params,
+	})
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// ignitionConfig returns the content for the given image if a build with
+// the same inputs has completed. Otherwise it ensures that a build is running
+// in the background and returns ImageNotReady; the caller is expected to try
+// again later.
+func (ip *rhcosImageProvider) ignitionConfig(data imageprovider.ImageData, cfg *config.Config, networkData imageprovider.NetworkData, log logr.Logger) (imageContent, error) {
+	key := imageKey(data)
+	macs, err := ip.interfaceMACs(data, networkData, log)
+	if err != nil {
+		return imageContent{}, err
+	}
+	params := imageParams{
+		Hostname:          data.ImageMetadata.Name,
+		InterfaceMACs:     macs,
+		SSHAuthorizedKeys: sshkeys.AuthorizedKeys(networkData),
+	}
+	if err := setHostnames(&params, cfg, data.ImageMetadata); err != nil {
+		return imageContent{}, imageprovider.BuildInvalidError(err)
+	}
+	if params.NTPServers, err = ntpServers(cfg, networkData); err != nil {
+		return imageContent{}, imageprovider.BuildInvalidError(err)
+	}
+	if params.NetworkFiles, err = ignition.NMStateFiles(networkData["nmstate"], networkData); err != nil {
+		return imageContent{}, imageprovider.BuildInvalidError(err)
+	}
+	if ip.Progress != nil {
+		params.ProgressURL = ip.Progress.URL(data.ImageMetadata)
+		params.ProgressToken = ip.Progress.Token(data.ImageMetadata)
+	}
+	if ip.Logs != nil {
+		params.LogUploadURL = ip.Logs.UploadURL(data.ImageMetadata)
+	}
+	if ip.DebugPasswords != nil && debug.Enabled(data.ImageMetadata, networkData) {
+		password, err := ip.DebugPasswords.Password(context.TODO(), data.ImageMetadata)
+		if err != nil {
+			return imageContent{}, fmt.Errorf("unable to get debug console password: %w", err)
+		}
+		params.DebugPasswordHash = debug.PasswordHash(password, data.ImageMetadata)
+	}
+	if ip.HostKeys != nil {
+		if params.SSHHostKeys, err = ip.HostKeys.HostKeys(context.TODO(), data.ImageMetadata); err != nil {
+			return imageContent{}, fmt.Errorf("unable to get SSH host keys: %w", err)
+		}
+	}
+	inputHash, err := ip.buildInputHash(cfg, networkData, params)
+	if err != nil {
+		return imageContent{}, err
+	}
+
+	ip.buildsLock.Lock()
+	defer ip.buildsLock.Unlock()
+
+	build, exists := ip.builds[key]
+	if exists && build.inputHash == inputHash {
+		if !build.finished() {
+			return imageContent{}, imageprovider.ImageNotReady{}
+		}
+		if build.err != nil && !errors.As(build.err, &imageprovider.ImageBuildInvalid{}) {
+			// Only permanent failures are cached; retry anything else
+			delete(ip.builds, key)
+		}
+		return build.content, build.err
+	}
+
+	if exists {
+		build.cancel()
+	}
+	ip.builds[key] = ip.startBuild(inputHash, cfg, networkData, params, log)
+	ip.recordConfigSources(data, cfg)
+	return imageContent{}, imageprovider.ImageNotReady{}
+}
+
+// startBuild runs an ignition build in the background once a build slot is
+// available.
+func (ip *rhcosImageProvider) startBuild(inputHash string, cfg *config.Config, networkData imageprovider.NetworkData, params imageParams, log logr.Logger) *imageBuild {
+	ctx, cancel := context.WithCancel(context.Background())
+	build := &imageBuild{
+		inputHash: inputHash,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		defer close(build.done)
+		defer cancel()
+
+		select {
+		case ip.buildSlots <- struct{}{}:
+			defer func() { <-ip.buildSlots }()
+		case <-ctx.Done():
+			build.err = ctx.Err()
+			return
+		}
+
+		log.Info("building image")
+		build.content, build.err = ip.buildIgnitionConfig(ctx, cfg, networkData, params)
+		if build.err != nil {
+			log.Info("image build failed", "error", build.err.Error())
+		} else {
+			log.Info("image build complete")
+		}
+	}()
+
+	return build
+}
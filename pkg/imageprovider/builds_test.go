@@ -0,0 +1,261 @@
+package imageprovider
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/metal3-io/baremetal-operator/pkg/imageprovider"
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/config"
+	"github.com/openshift/image-customization-controller/pkg/env"
+	"github.com/openshift/image-customization-controller/pkg/imagehandler"
+)
+
+type fakeImageHandler struct {
+	served map[string][]byte
+}
+
+var _ imagehandler.ImageHandler = &fakeImageHandler{}
+
+func (f *fakeImageHandler) FileSystem() http.FileSystem { return f }
+func (f *fakeImageHandler) Open(name string) (http.File, error) {
+	if _, served := f.served[name]; !served {
+		return nil, fs.ErrNotExist
+	}
+	return nil, nil
+}
+func (f *fakeImageHandler) ServeImage(key string, ignitionContent []byte, network imagehandler.FirstbootNetwork, initramfs, static bool) (string, error) {
+	f.served[key] = ignitionContent
+	return "http://example.com/" + key, nil
+}
+func (f *fakeImageHandler) RemoveImage(key string) { delete(f.served, key) }
+
+func testProvider() (*rhcosImageProvider, *fakeImageHandler) {
+	handler := &fakeImageHandler{served: map[string][]byte{}}
+	inputs := &env.EnvInputs{
+		IronicBaseURL:    "http://ironic.example.com",
+		IronicAgentImage: "quay.io/openshift-release-dev/ironic-ipa-image",
+	}
+	provider, err := NewRHCOSImageProvider(handler, inputs, RHCOSOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return provider.(*rhcosImageProvider), handler
+}
+
+func TestNewRHCOSImageProviderInvalidRegistries(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "mirrors.conf"), []byte("[[registry]]\nlocation = \"quay.io\"\nmirrors = []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inputs := &env.EnvInputs{
+		IronicAgentImage:   "quay.io/openshift-release-dev/ironic-ipa-image",
+		RegistriesConfDDir: dir,
+	}
+	if _, err := NewRHCOSImageProvider(&fakeImageHandler{}, inputs, RHCOSOptions{}); err == nil {
+		t.Error("expected invalid drop-in to be rejected")
+	}
+}
+
+func testImageData(name string) imageprovider.ImageData {
+	return imageprovider.ImageData{
+		ImageMetadata: &metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "test",
+			UID:       "uid",
+		},
+		Format:       metal3.ImageFormatISO,
+		Architecture: "x86_64",
+	}
+}
+
+// buildImage calls BuildImage until the background build is complete.
+func buildImage(t *testing.T, provider *rhcosImageProvider, data imageprovider.ImageData, networkData imageprovider.NetworkData) (imageprovider.GeneratedImage, error) {
+	for {
+		image, err := provider.BuildImage(data, networkData, zap.New(zap.UseDevMode(true)))
+		if !errors.As(err, &imageprovider.ImageNotReady{}) {
+			return image, err
+		}
+
+		provider.buildsLock.Lock()
+		build := provider.builds[imageKey(data)]
+		provider.buildsLock.Unlock()
+		select {
+		case <-build.done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for build")
+		}
+	}
+}
+
+func TestBuildImageNotReady(t *testing.T) {
+	provider, _ := testProvider()
+	data := testImageData("host-0")
+
+	// Occupy the only build slot so the build cannot complete
+	provider.buildSlots <- struct{}{}
+
+	_, err := provider.BuildImage(data, nil, zap.New(zap.UseDevMode(true)))
+	if !errors.As(err, &imageprovider.ImageNotReady{}) {
+		t.Fatalf("expected ImageNotReady, got %v", err)
+	}
+	_, err = provider.BuildImage(data, nil, zap.New(zap.UseDevMode(true)))
+	if !errors.As(err, &imageprovider.ImageNotReady{}) {
+		t.Fatalf("expected ImageNotReady, got %v", err)
+	}
+
+	build := provider.builds[imageKey(data)]
+	if err := provider.DiscardImage(data); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	select {
+	case <-build.done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("build not cancelled after discard")
+	}
+	if !errors.Is(build.err, context.Canceled) {
+		t.Errorf("unexpected build error %v", build.err)
+	}
+	if _, exists := provider.builds[imageKey(data)]; exists {
+		t.Errorf("build not removed after discard")
+	}
+}
+
+func TestBuildImageEventsLeaderOnly(t *testing.T) {
+	provider, _ := testProvider()
+	events := record.NewFakeRecorder(10)
+	elected := make(chan struct{})
+	provider.EventRecorder = events
+	provider.Elected = elected
+
+	if _, err := buildImage(t, provider, testImageData("host-0"), nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(events.Events) != 0 {
+		t.Errorf("unexpected event %q when not leader", <-events.Events)
+	}
+
+	close(elected)
+	if _, err := buildImage(t, provider, testImageData("host-1"), nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(events.Events) != 1 {
+		t.Errorf("expected one event when leader, got %d", len(events.Events))
+	}
+}
+
+func TestBuildImageCache(t *testing.T) {
+	provider, handler := testProvider()
+	data := testImageData("host-0")
+	key := imageKey(data)
+
+	image, err := buildImage(t, provider, data, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if image.ImageURL != "http://example.com/"+key {
+		t.Errorf("unexpected URL %s", image.ImageURL)
+	}
+	build := provider.builds[key]
+	if string(build.content.ignition) != string(handler.served[key]) {
+		t.Errorf("cached ignition does not match served ignition")
+	}
+
+	// A completed build with matching inputs is reused
+	build.content.ignition = []byte("cached")
+	if _, err := provider.BuildImage(data, nil, zap.New(zap.UseDevMode(true))); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if string(handler.served[key]) != "cached" {
+		t.Errorf("cached build not reused")
+	}
+
+	// A change in the inputs causes a rebuild
+	provider.EnvInputs.IpOptions = "ip=dhcp6"
+	if _, err := buildImage(t, provider, data, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if provider.builds[key].inputHash == build.inputHash {
+		t.Errorf("input hash not updated after change")
+	}
+	if string(handler.served[key]) == "cached" {
+		t.Errorf("stale build reused after change")
+	}
+
+	if err := provider.DiscardImage(data); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, exists := provider.builds[key]; exists {
+		t.Errorf("build not removed after discard")
+	}
+}
+
+func TestBuildImageKernelArgs(t *testing.T) {
+	provider, _ := testProvider()
+	provider.EnvInputs.IpOptions = "ip=dhcp6"
+
+	image, err := buildImage(t, provider, testImageData("host-0"), nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if image.ExtraKernelParams != "" {
+		t.Errorf("unexpected kernel params for ISO %q", image.ExtraKernelParams)
+	}
+
+	data := testImageData("host-1")
+	data.Format = metal3.ImageFormatInitRD
+	image, err = buildImage(t, provider, data, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if image.ExtraKernelParams != "ip=dhcp6" {
+		t.Errorf("unexpected kernel params for initramfs %q", image.ExtraKernelParams)
+	}
+}
+
+func TestBuildInputHash(t *testing.T) {
+	provider, _ := testProvider()
+
+	hash1, err := provider.buildInputHash(&config.Config{Inputs: provider.EnvInputs}, imageprovider.NetworkData{"nmstate": []byte("foo")}, imageParams{Hostname: "host-0"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	hash2, err := provider.buildInputHash(&config.Config{Inputs: provider.EnvInputs}, imageprovider.NetworkData{"nmstate": []byte("foo")}, imageParams{Hostname: "host-1"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	hash3, err := provider.buildInputHash(&config.Config{Inputs: provider.EnvInputs}, imageprovider.NetworkData{"nmstate": []byte("bar")}, imageParams{Hostname: "host-0"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	hash1again, err := provider.buildInputHash(&config.Config{Inputs: provider.EnvInputs}, imageprovider.NetworkData{"nmstate": []byte("foo")}, imageParams{Hostname: "host-0"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	hash4, err := provider.buildInputHash(&config.Config{
+		Inputs:       provider.EnvInputs,
+		AgentRuntime: &v1alpha1.AgentRuntimeConfig{PullPolicy: v1alpha1.PullNever},
+	}, imageprovider.NetworkData{"nmstate": []byte("foo")}, imageParams{Hostname: "host-0"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if hash1 != hash1again {
+		t.Errorf("inconsistent hashes for same inputs: %s %s", hash1, hash1again)
+	}
+	if hash1 == hash2 || hash1 == hash3 || hash1 == hash4 {
+		t.Errorf("same hash for different inputs")
+	}
+}
@@ -0,0 +1,211 @@
+package imageprovider
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sync"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/imagehandler"
+)
+
+// DownloadStatusAnnotation is the annotation on a PreprovisioningImage that
+// records the latest download of its image.
+const DownloadStatusAnnotation = "imagecustomization.openshift.io/download-status"
+
+// downloadQueueLength is the number of download status updates that may be
+// pending before further updates are dropped.
+const downloadQueueLength = 100
+
+// DownloadStatus is the content of the DownloadStatusAnnotation.
+type DownloadStatus struct {
+	// Started is when the latest download began.
+	Started metav1.Time `json:"started"`
+	// Completed is when the latest download finished, if it has.
+	Completed *metav1.Time `json:"completed,omitempty"`
+	// ClientAddress is the address of the client that downloaded the image.
+	ClientAddress string `json:"clientAddress"`
+	// Bytes is the number of bytes sent to the client.
+	Bytes int64 `json:"bytes"`
+}
+
+// trackedImage is an image whose downloads are reported.
+type trackedImage struct {
+	key  string
+	meta *metav1.ObjectMeta
+}
+
+type downloadUpdate struct {
+	image  *metal3.PreprovisioningImage
+	status DownloadStatus
+}
+
+// DownloadReporter records the downloads of images as Events and an
+// annotation on each PreprovisioningImage, so that it is possible to tell
+// whether a host has fetched its image.
+type DownloadReporter struct {
+	client   client.Client
+	recorder record.EventRecorder
+	log      logr.Logger
+
+	// images maps the URL path of each image served to its
+	// PreprovisioningImage.
+	images     map[string]trackedImage
+	imagesLock sync.Mutex
+
+	updates chan downloadUpdate
+}
+
+var _ imagehandler.DownloadObserver = &DownloadReporter{}
+
+// NewDownloadReporter returns a DownloadReporter that updates the
+// PreprovisioningImages using the given client. The reporter must be added to
+// the manager to write the annotations.
+func NewDownloadReporter(c client.Client, recorder record.EventRecorder, log logr.Logger) *DownloadReporter {
+	return &DownloadReporter{
+		client:   c,
+		recorder: recorder,
+		log:      log,
+		images:   map[string]trackedImage{},
+		updates:  make(chan downloadUpdate, downloadQueueLength),
+	}
+}
+
+// track records that the image with the given key is served at a URL.
+func (r *DownloadReporter) track(key, imageURL string, meta *metav1.ObjectMeta) {
+	if r == nil {
+		return
+	}
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return
+	}
+
+	r.imagesLock.Lock()
+	defer r.imagesLock.Unlock()
+	r.images[u.Path] = trackedImage{key: key, meta: meta.DeepCopy()}
+}
+
+// forget stops tracking downloads of the image with the given key.
+func (r *DownloadReporter) forget(key string) {
+	if r == nil {
+		return
+	}
+
+	r.imagesLock.Lock()
+	defer r.imagesLock.Unlock()
+	for path, tracked := range r.images {
+		if tracked.key == key {
+			delete(r.images, path)
+		}
+	}
+}
+
+func (r *DownloadReporter) image(path string) *metal3.PreprovisioningImage {
+	r.imagesLock.Lock()
+	defer r.imagesLock.Unlock()
+
+	tracked, exists := r.images[path]
+	if !exists {
+		return nil
+	}
+	return &metal3.PreprovisioningImage{ObjectMeta: *tracked.meta.DeepCopy()}
+}
+
+func (r *DownloadReporter) DownloadStarted(download imagehandler.Download) {
+	img := r.image(download.Path)
+	if img == nil {
+		return
+	}
+
+	r.log.Info("image download started", "preprovisioningimage", client.ObjectKeyFromObject(img),
+		"client", download.ClientAddress)
+	r.recorder.Eventf(img, corev1.EventTypeNormal, "DownloadStarted",
+		"Image download started by %s", download.ClientAddress)
+	r.enqueue(img, DownloadStatus{
+		Started:       metav1.NewTime(download.Started),
+		ClientAddress: download.ClientAddress,
+	})
+}
+
+func (r *DownloadReporter) DownloadCompleted(download imagehandler.Download) {
+	img := r.image(download.Path)
+	if img == nil {
+		return
+	}
+
+	r.log.Info("image download completed", "preprovisioningimage", client.ObjectKeyFromObject(img),
+		"client", download.ClientAddress, "bytes", download.Bytes)
+	r.recorder.Eventf(img, corev1.EventTypeNormal, "DownloadCompleted",
+		"Image downloaded by %s (%d bytes)", download.ClientAddress, download.Bytes)
+	completed := metav1.Now()
+	r.enqueue(img, DownloadStatus{
+		Started:       metav1.NewTime(download.Started),
+		Completed:     &completed,
+		ClientAddress: download.ClientAddress,
+		Bytes:         download.Bytes,
+	})
+}
+
+// enqueue queues an update to the annotation, so that downloads are not
+// delayed by writes to the API.
+func (r *DownloadReporter) enqueue(img *metal3.PreprovisioningImage, status DownloadStatus) {
+	select {
+	case r.updates <- downloadUpdate{image: img, status: status}:
+	default:
+		r.log.Info("dropping download status update", "preprovisioningimage", client.ObjectKeyFromObject(img))
+	}
+}
+
+// Start writes the queued annotation updates until the context is done.
+func (r *DownloadReporter) Start(ctx context.Context) error {
+	for {
+		select {
+		case update := <-r.updates:
+			if err := r.updateAnnotation(ctx, update); err != nil {
+				r.log.Info("unable to update download status",
+					"preprovisioningimage", client.ObjectKeyFromObject(update.image),
+					"error", err.Error())
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// NeedLeaderElection returns false, since every replica serves images.
+func (r *DownloadReporter) NeedLeaderElection() bool {
+	return false
+}
+
+// updateAnnotation writes the download status to the annotation.
+func (r *DownloadReporter) updateAnnotation(ctx context.Context, update downloadUpdate) error {
+	value, err := json.Marshal(update.status)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid": update.image.UID,
+			"annotations": map[string]string{
+				DownloadStatusAnnotation: string(value),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return client.IgnoreNotFound(r.client.Patch(ctx, update.image,
+		client.RawPatch(types.MergePatchType, patch)))
+}
+
+// +kubebuilder:rbac:groups=metal3.io,resources=preprovisioningimages,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
@@ -0,0 +1,93 @@
+package imageprovider
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/openshift/image-customization-controller/pkg/imagehandler"
+)
+
+type patchRecorder struct {
+	client.Client
+	patches map[client.ObjectKey][]byte
+}
+
+func (p *patchRecorder) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	p.patches[client.ObjectKeyFromObject(obj)] = data
+	return nil
+}
+
+func TestDownloadReporter(t *testing.T) {
+	fake := &patchRecorder{patches: map[client.ObjectKey][]byte{}}
+	events := record.NewFakeRecorder(10)
+	reporter := NewDownloadReporter(fake, events, zap.New(zap.UseDevMode(true)))
+	data := testImageData("host-0")
+	reporter.track(imageKey(data), "http://example.com/images/host-0.iso", data.ImageMetadata)
+
+	started := time.Now()
+	download := imagehandler.Download{
+		Path:          "/images/host-0.iso",
+		ClientAddress: "192.0.2.1",
+		Started:       started,
+	}
+	reporter.DownloadStarted(imagehandler.Download{Path: "/images/other.iso"})
+	reporter.DownloadStarted(download)
+	download.Bytes = 1234
+	reporter.DownloadCompleted(download)
+
+	for _, reason := range []string{"DownloadStarted", "DownloadCompleted"} {
+		select {
+		case event := <-events.Events:
+			if !strings.HasPrefix(event, corev1.EventTypeNormal+" "+reason) {
+				t.Errorf("unexpected event %q", event)
+			}
+		default:
+			t.Fatalf("missing %s event", reason)
+		}
+	}
+	if len(reporter.updates) != 2 {
+		t.Fatalf("expected 2 updates, got %d", len(reporter.updates))
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := reporter.updateAnnotation(context.TODO(), <-reporter.updates); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	patch := struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}{}
+	key := client.ObjectKey{Namespace: "test", Name: "host-0"}
+	if err := json.Unmarshal(fake.patches[key], &patch); err != nil {
+		t.Fatalf("invalid patch %v", err)
+	}
+	status := DownloadStatus{}
+	if err := json.Unmarshal([]byte(patch.Metadata.Annotations[DownloadStatusAnnotation]), &status); err != nil {
+		t.Fatalf("invalid annotation %v", err)
+	}
+	if status.Completed == nil || status.Bytes != 1234 || status.ClientAddress != "192.0.2.1" ||
+		status.Started.Unix() != started.Unix() {
+		t.Errorf("unexpected download status %+v", status)
+	}
+
+	// Downloads are not reported once the image is discarded
+	reporter.forget(imageKey(data))
+	reporter.DownloadStarted(download)
+	if len(reporter.updates) != 0 {
+		t.Errorf("download of discarded image reported")
+	}
+}
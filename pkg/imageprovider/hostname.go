@@ -0,0 +1,64 @@
+package imageprovider
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/config"
+)
+
+// bareMetalHostName returns the name of the BareMetalHost that owns an image,
+// if any.
+func bareMetalHostName(image *metav1.ObjectMeta) string {
+	for _, owner := range image.OwnerReferences {
+		if owner.Kind == "BareMetalHost" {
+			return owner.Name
+		}
+	}
+	return ""
+}
+
+// setHostnames sets the hostname sources of an image, the names of those
+// that are known when the image is built, and the default hostname passed to
+// the agent, which is the first of those names in priority order or else the
+// name of the image.
+func setHostnames(params *imageParams, cfg *config.Config, image *metav1.ObjectMeta) error {
+	sources := cfg.HostnameSources
+	if sources == nil {
+		return nil
+	}
+
+	names := map[v1alpha1.HostnameSource]string{
+		v1alpha1.HostnameSourceBareMetalHost:        bareMetalHostName(image),
+		v1alpha1.HostnameSourcePreprovisioningImage: image.Name,
+	}
+	if config.UsesHostnameTemplate(sources) {
+		var err error
+		names[v1alpha1.HostnameSourceTemplate], err = config.RenderHostname(cfg.HostnameTemplate, config.HostnameTemplateData{
+			Name:          image.Name,
+			Namespace:     image.Namespace,
+			BareMetalHost: names[v1alpha1.HostnameSourceBareMetalHost],
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	params.HostnameSources = sources
+	params.Hostnames = map[v1alpha1.HostnameSource]string{}
+	defaultHostname := ""
+	for _, source := range sources {
+		name := names[source]
+		if name == "" {
+			continue
+		}
+		params.Hostnames[source] = name
+		if defaultHostname == "" {
+			defaultHostname = name
+		}
+	}
+	if defaultHostname != "" {
+		params.Hostname = defaultHostname
+	}
+	return nil
+}
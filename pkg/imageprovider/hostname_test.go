@@ -0,0 +1,89 @@
+package imageprovider
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/config"
+)
+
+func TestSetHostnames(t *testing.T) {
+	image := &metav1.ObjectMeta{
+		Namespace: "site-1",
+		Name:      "image-0",
+		OwnerReferences: []metav1.OwnerReference{
+			{Kind: "BareMetalHost", Name: "host-0"},
+		},
+	}
+
+	for _, tc := range []struct {
+		name             string
+		cfg              config.Config
+		expectedSources  []v1alpha1.HostnameSource
+		expectedNames    map[v1alpha1.HostnameSource]string
+		expectedHostname string
+	}{
+		{
+			name:             "default",
+			expectedHostname: "image-0",
+		},
+		{
+			name: "template",
+			cfg: config.Config{
+				HostnameSources: []v1alpha1.HostnameSource{
+					v1alpha1.HostnameSourceDHCPv4, v1alpha1.HostnameSourceTemplate, v1alpha1.HostnameSourceBareMetalHost,
+				},
+				HostnameTemplate: "{{.BareMetalHost}}.{{.Namespace}}.example.com",
+			},
+			expectedSources: []v1alpha1.HostnameSource{
+				v1alpha1.HostnameSourceDHCPv4, v1alpha1.HostnameSourceTemplate, v1alpha1.HostnameSourceBareMetalHost,
+			},
+			expectedNames: map[v1alpha1.HostnameSource]string{
+				v1alpha1.HostnameSourceTemplate:      "host-0.site-1.example.com",
+				v1alpha1.HostnameSourceBareMetalHost: "host-0",
+			},
+			expectedHostname: "host-0.site-1.example.com",
+		},
+		{
+			name: "template not a source",
+			cfg: config.Config{
+				HostnameSources:  []v1alpha1.HostnameSource{v1alpha1.HostnameSourcePreprovisioningImage},
+				HostnameTemplate: "{{.Name}}_invalid",
+			},
+			expectedSources: []v1alpha1.HostnameSource{v1alpha1.HostnameSourcePreprovisioningImage},
+			expectedNames: map[v1alpha1.HostnameSource]string{
+				v1alpha1.HostnameSourcePreprovisioningImage: "image-0",
+			},
+			expectedHostname: "image-0",
+		},
+		{
+			name: "dynamic only",
+			cfg: config.Config{
+				HostnameSources: []v1alpha1.HostnameSource{v1alpha1.HostnameSourceDHCPv6, v1alpha1.HostnameSourceReverseDNS},
+			},
+			expectedSources: []v1alpha1.HostnameSource{v1alpha1.HostnameSourceDHCPv6, v1alpha1.HostnameSourceReverseDNS},
+			expectedNames:   map[v1alpha1.HostnameSource]string{},
+			// The image name is still the agent's default
+			expectedHostname: "image-0",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := imageParams{Hostname: image.Name}
+			if err := setHostnames(&params, &tc.cfg, image); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(params.HostnameSources, tc.expectedSources) {
+				t.Errorf("expected sources %v, got %v", tc.expectedSources, params.HostnameSources)
+			}
+			if !reflect.DeepEqual(params.Hostnames, tc.expectedNames) {
+				t.Errorf("expected names %v, got %v", tc.expectedNames, params.Hostnames)
+			}
+			if params.Hostname != tc.expectedHostname {
+				t.Errorf("expected hostname %q, got %q", tc.expectedHostname, params.Hostname)
+			}
+		})
+	}
+}
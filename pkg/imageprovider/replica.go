@@ -0,0 +1,173 @@
+package imageprovider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/metal3-io/baremetal-operator/pkg/imageprovider"
+)
+
+// replicaRetryDelay is how long to wait before checking again whether an
+// image build has completed.
+const replicaRetryDelay = 10 * time.Second
+
+// ReplicaReconciler builds the image for each PreprovisioningImage from the
+// format and network data recorded in its status by the leader, so that every
+// replica of the controller is able to serve every image. Since image URLs
+// are derived from the image content, all replicas serve an image at the
+// same URL.
+type ReplicaReconciler struct {
+	client.Client
+	Log           logr.Logger
+	ImageProvider imageprovider.ImageProvider
+
+	// elected is closed when this replica becomes the leader, at which point
+	// the images are built by the leader's reconciler instead.
+	elected <-chan struct{}
+
+	// served records the images built by this reconciler, so that they can
+	// be discarded once the PreprovisioningImage changes or is deleted.
+	served     map[types.NamespacedName]imageprovider.ImageData
+	servedLock sync.Mutex
+}
+
+func (r *ReplicaReconciler) isLeader() bool {
+	select {
+	case <-r.elected:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *ReplicaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("preprovisioningimage", req.NamespacedName)
+
+	if r.isLeader() {
+		return ctrl.Result{}, nil
+	}
+
+	img := &metal3.PreprovisioningImage{}
+	if err := r.Get(ctx, req.NamespacedName, img); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, r.discard(req.NamespacedName)
+		}
+		return ctrl.Result{}, err
+	}
+
+	data := imageprovider.ImageData{
+		ImageMetadata:     img.ObjectMeta.DeepCopy(),
+		Format:            img.Status.Format,
+		Architecture:      img.Status.Architecture,
+		NetworkDataStatus: img.Status.NetworkData,
+	}
+	if !img.DeletionTimestamp.IsZero() || img.Status.ImageUrl == "" {
+		// Wait for the leader to record a built image
+		return ctrl.Result{}, r.discard(req.NamespacedName)
+	}
+
+	var networkData imageprovider.NetworkData
+	if name := img.Status.NetworkData.Name; name != "" {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: img.Namespace, Name: name}, secret); err != nil {
+			return ctrl.Result{}, err
+		}
+		if secret.ResourceVersion != img.Status.NetworkData.Version {
+			log.Info("network data has changed; waiting for leader")
+			return ctrl.Result{}, nil
+		}
+		networkData = secret.Data
+	}
+
+	image, err := r.ImageProvider.BuildImage(data, networkData, log)
+	if errors.As(err, &imageprovider.ImageNotReady{}) {
+		return ctrl.Result{RequeueAfter: replicaRetryDelay}, nil
+	}
+	if err != nil {
+		log.Info("unable to build image", "error", err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err := r.record(req.NamespacedName, data); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if image.ImageURL != img.Status.ImageUrl {
+		// The inputs cached by this replica may be stale (e.g. a rotated
+		// pull secret), so check again until it serves the same image
+		log.Info("image URL differs from leader", "url", image.ImageURL, "leaderURL", img.Status.ImageUrl)
+		return ctrl.Result{RequeueAfter: replicaRetryDelay}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// record notes the image built for a PreprovisioningImage, and stops serving
+// any image previously built for it with a different format or architecture.
+func (r *ReplicaReconciler) record(name types.NamespacedName, data imageprovider.ImageData) error {
+	r.servedLock.Lock()
+	defer r.servedLock.Unlock()
+
+	previous, exists := r.served[name]
+	r.served[name] = data
+	if exists && imageKey(previous) != imageKey(data) {
+		return r.ImageProvider.DiscardImage(previous)
+	}
+	return nil
+}
+
+// discard stops serving the image previously built for a
+// PreprovisioningImage.
+func (r *ReplicaReconciler) discard(name types.NamespacedName) error {
+	r.servedLock.Lock()
+	defer r.servedLock.Unlock()
+
+	previous, exists := r.served[name]
+	if !exists {
+		return nil
+	}
+	delete(r.served, name)
+	return r.ImageProvider.DiscardImage(previous)
+}
+
+// replicaController is a controller that runs on every replica, not only
+// the leader.
+type replicaController struct {
+	controller.Controller
+}
+
+func (replicaController) NeedLeaderElection() bool {
+	return false
+}
+
+func (r *ReplicaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.served = map[types.NamespacedName]imageprovider.ImageData{}
+	r.elected = mgr.Elected()
+
+	c, err := controller.NewUnmanaged("preprovisioningimage-replica", mgr, controller.Options{
+		Reconciler: r,
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &metal3.PreprovisioningImage{}},
+		&handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &corev1.Secret{}},
+		&handler.EnqueueRequestForOwner{OwnerType: &metal3.PreprovisioningImage{}}); err != nil {
+		return err
+	}
+	return mgr.Add(replicaController{c})
+}
@@ -0,0 +1,97 @@
+package imageprovider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/metal3-io/baremetal-operator/pkg/imageprovider"
+)
+
+type fakeClient struct {
+	client.Client
+	image *metal3.PreprovisioningImage
+}
+
+func (f *fakeClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	img, ok := obj.(*metal3.PreprovisioningImage)
+	if !ok || f.image == nil || key != client.ObjectKeyFromObject(f.image) {
+		return k8serrors.NewNotFound(schema.GroupResource{}, key.Name)
+	}
+	f.image.DeepCopyInto(img)
+	return nil
+}
+
+func TestReplicaReconcile(t *testing.T) {
+	provider, handler := testProvider()
+	fake := &fakeClient{image: &metal3.PreprovisioningImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-0", Namespace: "test", UID: "uid"},
+	}}
+	r := &ReplicaReconciler{
+		Client:        fake,
+		Log:           zap.New(zap.UseDevMode(true)),
+		ImageProvider: provider,
+		served:        map[types.NamespacedName]imageprovider.ImageData{},
+		elected:       make(chan struct{}),
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "host-0"}}
+
+	// Nothing is built until the leader has recorded an image
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(provider.builds) != 0 {
+		t.Fatalf("unexpected build started")
+	}
+
+	key := imageKey(testImageData("host-0"))
+	fake.image.Status = metal3.PreprovisioningImageStatus{
+		ImageUrl:     "http://example.com/" + key,
+		Format:       metal3.ImageFormatISO,
+		Architecture: "x86_64",
+	}
+	for i := 0; ; i++ {
+		result, err := r.Reconcile(context.TODO(), req)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if result.RequeueAfter == 0 {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("timed out waiting for build")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if _, served := handler.served[key]; !served {
+		t.Errorf("image not served")
+	}
+
+	// The image is checked again while its URL differs from the leader's
+	fake.image.Status.ImageUrl = "http://example.com/other"
+	result, err := r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if result.RequeueAfter != replicaRetryDelay {
+		t.Errorf("expected requeue when the image URL differs, got %v", result)
+	}
+
+	// The image is discarded once the PreprovisioningImage is deleted
+	fake.image = nil
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, served := handler.served[key]; served {
+		t.Errorf("image still served after deletion")
+	}
+}
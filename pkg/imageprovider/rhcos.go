@@ -1,35 +1,161 @@
 package imageprovider
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
 
 	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
 	"github.com/metal3-io/baremetal-operator/pkg/imageprovider"
+	"github.com/openshift/image-customization-controller/pkg/config"
+	"github.com/openshift/image-customization-controller/pkg/debug"
+	"github.com/openshift/image-customization-controller/pkg/endpoints"
 	"github.com/openshift/image-customization-controller/pkg/env"
+	"github.com/openshift/image-customization-controller/pkg/hardware"
 	"github.com/openshift/image-customization-controller/pkg/ignition"
 	"github.com/openshift/image-customization-controller/pkg/imagehandler"
+	"github.com/openshift/image-customization-controller/pkg/logsink"
+	"github.com/openshift/image-customization-controller/pkg/progress"
+	"github.com/openshift/image-customization-controller/pkg/sshkeys"
 )
 
 type rhcosImageProvider struct {
 	ImageHandler   imagehandler.ImageHandler
 	EnvInputs      *env.EnvInputs
+	Config         config.Loader
 	RegistriesConf []byte
+	// RegistriesDropins holds the registries.conf.d drop-ins, if any.
+	RegistriesDropins map[string][]byte
+	// RegistryCerts holds the files for /etc/containers/certs.d, if any.
+	RegistryCerts map[string][]byte
+	// ContainerTrust holds the container trust policy files, if any.
+	ContainerTrust map[string][]byte
+	EventRecorder  record.EventRecorder
+	// Inventory, if set, is used to match the ethernet interfaces in the
+	// network data to the host's NICs by MAC address.
+	Inventory hardware.Inventory
+	// Endpoints, if set, is used to find the Ironic URLs that are not
+	// configured explicitly.
+	Endpoints endpoints.Resolver
+	// Downloads, if set, is used to report the downloads of each image.
+	Downloads *DownloadReporter
+	// Progress, if set, is used to configure the ramdisk to report its boot
+	// progress.
+	Progress *progress.Callbacks
+	// Logs, if set, is the sink to which the ramdisk uploads its journal.
+	Logs *logsink.Sink
+	// DebugPasswords, if set, provides the console password for images
+	// that have debugging enabled.
+	DebugPasswords debug.Passwords
+	// HostKeys, if set, provides the SSH host keys for each image.
+	HostKeys sshkeys.HostKeys
+	// Elected, if set, is closed when this replica becomes the leader.
+	// Events are recorded only by the leader.
+	Elected <-chan struct{}
+
+	// builds holds the latest build for each image key, so that nmstatectl
+	// need not be run again when the inputs are unchanged.
+	builds     map[string]*imageBuild
+	buildsLock sync.Mutex
+
+	// buildSlots limits the number of builds running in the background.
+	buildSlots chan struct{}
+	// nmstateSlots limits the number of concurrent nmstatectl processes.
+	nmstateSlots chan struct{}
+}
+
+// RHCOSOptions holds the optional dependencies of the image provider. Any
+// of them may be left unset.
+type RHCOSOptions struct {
+	// Config loads the configuration for each image. By default, the
+	// environment is used for every image.
+	Config config.Loader
+	// Inventory is used to match the ethernet interfaces in the network data
+	// to the host's NICs by MAC address.
+	Inventory hardware.Inventory
+	// Endpoints is used to find the Ironic URLs that are not configured
+	// explicitly.
+	Endpoints endpoints.Resolver
+	// Downloads is used to report the downloads of each image.
+	Downloads *DownloadReporter
+	// Progress is used to configure the ramdisk to report its boot progress.
+	Progress *progress.Callbacks
+	// Logs is the sink to which the ramdisk uploads its journal.
+	Logs *logsink.Sink
+	// DebugPasswords provides the console password for images that have
+	// debugging enabled.
+	DebugPasswords debug.Passwords
+	// HostKeys provides the SSH host keys for each image.
+	HostKeys sshkeys.HostKeys
+	// EventRecorder records Events for the PreprovisioningImages.
+	EventRecorder record.EventRecorder
+	// Elected is closed when this replica becomes the leader. If set,
+	// replicas that are not the leader do not record Events.
+	Elected <-chan struct{}
 }
 
-func NewRHCOSImageProvider(imageServer imagehandler.ImageHandler, inputs *env.EnvInputs) imageprovider.ImageProvider {
+// NewRHCOSImageProvider returns an ImageProvider that builds RHCOS images. The
+// registries and container trust files are read once, so an error is
+// returned if any of them are invalid.
+func NewRHCOSImageProvider(imageServer imagehandler.ImageHandler, inputs *env.EnvInputs, opts RHCOSOptions) (imageprovider.ImageProvider, error) {
+	configLoader := opts.Config
+	if configLoader == nil {
+		configLoader = config.NewStaticLoader(inputs)
+	}
+
 	registries, err := inputs.RegistriesConf()
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	registriesDropins, err := inputs.RegistriesDropins()
+	if err != nil {
+		return nil, err
+	}
+	registryCerts, err := inputs.RegistryCertificates()
+	if err != nil {
+		return nil, err
+	}
+	containerTrust, err := inputs.ContainerTrust()
+	if err != nil {
+		return nil, err
 	}
 
-	return &rhcosImageProvider{
-		ImageHandler:   imageServer,
-		EnvInputs:      inputs,
-		RegistriesConf: registries,
+	maxConcurrency := inputs.NMStateMaxConcurrency
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
 	}
+	buildWorkers := inputs.ImageBuildWorkers
+	if buildWorkers < 1 {
+		buildWorkers = 1
+	}
+
+	return &rhcosImageProvider{
+		ImageHandler:      imageServer,
+		EnvInputs:         inputs,
+		Config:            configLoader,
+		RegistriesConf:    registries,
+		RegistriesDropins: registriesDropins,
+		RegistryCerts:     registryCerts,
+		ContainerTrust:    containerTrust,
+		EventRecorder:     opts.EventRecorder,
+		Inventory:         opts.Inventory,
+		Endpoints:         opts.Endpoints,
+		Downloads:         opts.Downloads,
+		Progress:          opts.Progress,
+		Logs:              opts.Logs,
+		DebugPasswords:    opts.DebugPasswords,
+		HostKeys:          opts.HostKeys,
+		Elected:           opts.Elected,
+		builds:            map[string]*imageBuild{},
+		buildSlots:        make(chan struct{}, buildWorkers),
+		nmstateSlots:      make(chan struct{}, maxConcurrency),
+	}, nil
 }
 
 func (ip *rhcosImageProvider) SupportsArchitecture(arch string) bool {
@@ -45,34 +171,129 @@ func (ip *rhcosImageProvider) SupportsFormat(format metal3.ImageFormat) bool {
 	}
 }
 
-func (ip *rhcosImageProvider) buildIgnitionConfig(networkData imageprovider.NetworkData, hostname string) ([]byte, error) {
+func (ip *rhcosImageProvider) buildIgnitionConfig(ctx context.Context, cfg *config.Config, networkData imageprovider.NetworkData, params imageParams) (imageContent, error) {
+	inputs := cfg.Inputs
 	nmstateData := networkData["nmstate"]
 
 	builder, err := ignition.New(nmstateData, ip.RegistriesConf,
-		ip.EnvInputs.IronicBaseURL,
-		ip.EnvInputs.IronicInspectorBaseURL,
-		ip.EnvInputs.IronicAgentImage,
-		ip.EnvInputs.IronicAgentPullSecret,
-		ip.EnvInputs.IronicRAMDiskSSHKey,
-		ip.EnvInputs.IpOptions,
-		ip.EnvInputs.HttpProxy,
-		ip.EnvInputs.HttpsProxy,
-		ip.EnvInputs.NoProxy,
-		hostname,
+		inputs.IronicBaseURL,
+		inputs.IronicInspectorBaseURL,
+		inputs.IronicAgentImage,
+		inputs.IronicAgentPullSecret,
+		strings.TrimSpace(inputs.IronicRAMDiskSSHKey+"\n"+params.SSHAuthorizedKeys),
+		inputs.IpOptions,
+		inputs.HttpProxy,
+		inputs.HttpsProxy,
+		inputs.NoProxy,
+		params.Hostname,
 	)
 	if err != nil {
-		return nil, imageprovider.BuildInvalidError(err)
+		return imageContent{}, imageprovider.BuildInvalidError(err)
+	}
+	builder.MatchInterfacesByMAC(params.InterfaceMACs)
+	if err := builder.RegistriesDropins(ip.RegistriesDropins); err != nil {
+		return imageContent{}, imageprovider.BuildInvalidError(err)
+	}
+	if err := builder.RegistryMirrors(cfg.RegistryMirrors); err != nil {
+		return imageContent{}, imageprovider.BuildInvalidError(err)
 	}
+	if err := builder.RegistryCertificates(ip.RegistryCerts); err != nil {
+		return imageContent{}, imageprovider.BuildInvalidError(err)
+	}
+	if len(ip.ContainerTrust) > 0 {
+		if err := builder.ContainerTrust(ip.ContainerTrust); err != nil {
+			return imageContent{}, imageprovider.BuildInvalidError(err)
+		}
+	}
+	builder.AgentRuntime(cfg.AgentRuntime)
+	builder.InspectionMode(cfg.InspectionMode)
+	builder.NetworkFallback(cfg.NetworkFallbackTimeout)
+	if params.ProgressURL != "" {
+		builder.ReportProgress(params.ProgressURL, params.ProgressToken)
+	}
+	if params.LogUploadURL != "" {
+		builder.UploadJournal(params.LogUploadURL)
+	}
+	if params.DebugPasswordHash != "" {
+		builder.Debug(params.DebugPasswordHash)
+	}
+	if len(params.SSHHostKeys) > 0 {
+		builder.SSHHostKeys(params.SSHHostKeys)
+	}
+	if len(params.NTPServers) > 0 {
+		builder.NTPServers(params.NTPServers)
+	}
+	builder.NetworkFiles(params.NetworkFiles)
+	if params.HostnameSources != nil {
+		builder.HostnameSources(params.HostnameSources, params.Hostnames)
+	}
+
+	ctx, cancel := inputs.NMStateContext(ctx)
+	defer cancel()
 
-	err, message := builder.ProcessNetworkState()
-	if message != "" {
-		return nil, imageprovider.BuildInvalidError(errors.New(message))
+	// Limit the number of concurrent nmstatectl processes
+	select {
+	case ip.nmstateSlots <- struct{}{}:
+	case <-ctx.Done():
+		return imageContent{}, fmt.Errorf("gave up waiting to run nmstatectl: %w", ctx.Err())
 	}
+	err = builder.ProcessNetworkState(ctx)
+	<-ip.nmstateSlots
 	if err != nil {
+		nmstateErr := &ignition.NMStateError{}
+		if errors.As(err, &nmstateErr) {
+			return imageContent{}, imageprovider.BuildInvalidError(err)
+		}
+		return imageContent{}, err
+	}
+
+	ignitionConfig, err := builder.Generate()
+	if err != nil {
+		return imageContent{}, err
+	}
+	keyfiles, err := ignition.NetworkKeyfiles(ignitionConfig)
+	if err != nil {
+		return imageContent{}, err
+	}
+	return imageContent{
+		ignition: ignitionConfig,
+		network: imagehandler.FirstbootNetwork{
+			Keyfiles:   keyfiles,
+			KernelArgs: ignition.FirstbootKernelArgs(keyfiles, inputs.IpOptions),
+		},
+	}, nil
+}
+
+// ntpServers returns the NTP servers for a host, which are those listed in
+// its network data if any, or the global ones otherwise.
+func ntpServers(cfg *config.Config, networkData imageprovider.NetworkData) ([]string, error) {
+	if hostServers := networkData[config.NTPServersNetworkDataKey]; len(hostServers) > 0 {
+		return config.ParseNTPServers(string(hostServers))
+	}
+	return cfg.NTPServers, nil
+}
+
+// interfaceMACs returns the MAC addresses of the host's NICs matching the
+// ethernet interfaces in the network data, if the host has been inspected.
+func (ip *rhcosImageProvider) interfaceMACs(data imageprovider.ImageData, networkData imageprovider.NetworkData, log logr.Logger) (map[string]string, error) {
+	if ip.Inventory == nil {
+		return nil, nil
+	}
+
+	nics, err := ip.Inventory.NICs(context.TODO(), data.ImageMetadata)
+	if err != nil || len(nics) == 0 {
 		return nil, err
 	}
 
-	return builder.Generate()
+	check, err := hardware.CheckInterfaces(networkData["nmstate"], nics)
+	if err != nil {
+		// Invalid network data is reported by nmstatectl
+		return nil, nil
+	}
+	for _, mismatch := range check.Mismatches {
+		log.Info("network data does not match host", "mismatch", mismatch)
+	}
+	return check.MACs, nil
 }
 
 func imageKey(data imageprovider.ImageData) string {
@@ -85,23 +306,129 @@ func imageKey(data imageprovider.ImageData) string {
 	)
 }
 
+// imageIgnition returns the content for an image, loading the configuration
+// that applies to it. If the ignition is not yet available, ImageNotReady is
+// returned.
+func (ip *rhcosImageProvider) imageIgnition(data imageprovider.ImageData, networkData imageprovider.NetworkData, log logr.Logger) (imageContent, error) {
+	cfg, err := ip.Config.Load(context.TODO(), data.ImageMetadata)
+	if err != nil {
+		if errors.As(err, &config.InvalidConfigError{}) {
+			return imageContent{}, imageprovider.BuildInvalidError(err)
+		}
+		return imageContent{}, err
+	}
+
+	if ip.Endpoints != nil {
+		cfg, err = ip.Endpoints.Resolve(context.TODO(), cfg, networkData["nmstate"])
+		if errors.As(err, &endpoints.NoEndpointsError{}) {
+			log.Info("waiting for Ironic endpoints", "reason", err.Error())
+			return imageContent{}, imageprovider.ImageNotReady{}
+		}
+		if err != nil {
+			return imageContent{}, err
+		}
+	}
+
+	content, err := ip.ignitionConfig(data, cfg, networkData, log)
+	if err != nil {
+		ip.recordNetworkError(data, err)
+		return imageContent{}, err
+	}
+	return content, nil
+}
+
 func (ip *rhcosImageProvider) BuildImage(data imageprovider.ImageData, networkData imageprovider.NetworkData, log logr.Logger) (imageprovider.GeneratedImage, error) {
 	generated := imageprovider.GeneratedImage{}
-	ignitionConfig, err := ip.buildIgnitionConfig(networkData, data.ImageMetadata.Name)
+	key := imageKey(data)
+
+	content, err := ip.imageIgnition(data, networkData, log)
 	if err != nil {
 		return generated, err
 	}
 
-	url, err := ip.ImageHandler.ServeImage(imageKey(data), ignitionConfig,
+	url, err := ip.ImageHandler.ServeImage(key, content.ignition, content.network,
 		data.Format == metal3.ImageFormatInitRD, false)
 	if errors.As(err, &imagehandler.InvalidBaseImageError{}) {
 		return generated, imageprovider.BuildInvalidError(err)
 	}
+	if err != nil {
+		return generated, err
+	}
+	ip.Downloads.track(key, url, data.ImageMetadata)
 	generated.ImageURL = url
-	return generated, err
+
+	// The kernel arguments are embedded in ISOs, but must be passed by Ironic
+	// when booting an initramfs
+	if data.Format == metal3.ImageFormatInitRD {
+		generated.ExtraKernelParams = content.network.KernelArgs
+	}
+	return generated, nil
+}
+
+// recordsEvents returns whether this replica records Events. Replicas that
+// are not the leader build the same images as the leader, so they would
+// otherwise record duplicate Events.
+func (ip *rhcosImageProvider) recordsEvents() bool {
+	if ip.EventRecorder == nil {
+		return false
+	}
+	if ip.Elected == nil {
+		return true
+	}
+	select {
+	case <-ip.Elected:
+		return true
+	default:
+		return false
+	}
+}
+
+// recordNetworkError emits an Event on the PreprovisioningImage containing the
+// full nmstatectl output, since only a summary fits in the status condition.
+func (ip *rhcosImageProvider) recordNetworkError(data imageprovider.ImageData, err error) {
+	nmstateErr := &ignition.NMStateError{}
+	if !ip.recordsEvents() || !errors.As(err, &nmstateErr) {
+		return
+	}
+
+	img := &metal3.PreprovisioningImage{ObjectMeta: *data.ImageMetadata}
+	message := nmstateErr.Error()
+	if nmstateErr.Path != "" {
+		message = fmt.Sprintf("%s [path: %s]", message, nmstateErr.Path)
+	}
+	if nmstateErr.Detail != "" {
+		message = fmt.Sprintf("%s\n%s", message, nmstateErr.Detail)
+	}
+	ip.EventRecorder.Event(img, corev1.EventTypeWarning, "InvalidNetworkData", message)
+}
+
+// recordConfigSources emits an Event on the PreprovisioningImage recording
+// where the configuration used to build it came from.
+func (ip *rhcosImageProvider) recordConfigSources(data imageprovider.ImageData, cfg *config.Config) {
+	if !ip.recordsEvents() {
+		return
+	}
+
+	img := &metal3.PreprovisioningImage{ObjectMeta: *data.ImageMetadata}
+	ip.EventRecorder.Eventf(img, corev1.EventTypeNormal, "BuildStarted",
+		"Building image using configuration from %s", strings.Join(cfg.Sources, ", "))
+}
+
+// cancelBuild stops any build for the image and forgets its result.
+func (ip *rhcosImageProvider) cancelBuild(key string) {
+	ip.buildsLock.Lock()
+	defer ip.buildsLock.Unlock()
+
+	if build, exists := ip.builds[key]; exists {
+		build.cancel()
+		delete(ip.builds, key)
+	}
 }
 
 func (ip *rhcosImageProvider) DiscardImage(data imageprovider.ImageData) error {
-	ip.ImageHandler.RemoveImage(imageKey(data))
+	key := imageKey(data)
+	ip.cancelBuild(key)
+	ip.Downloads.forget(key)
+	ip.ImageHandler.RemoveImage(key)
 	return nil
 }
@@ -0,0 +1,267 @@
+package imageprovider
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/metal3-io/baremetal-operator/pkg/imageprovider"
+	"github.com/openshift/image-customization-controller/pkg/imagehandler"
+)
+
+// statelessBuildTimeout is the maximum time to wait for an image to be
+// generated when it is requested.
+const statelessBuildTimeout = 2 * time.Minute
+
+// statelessImageProvider publishes images at signed URLs from which the
+// image can be regenerated on demand, so that any replica of the controller
+// can serve any image without having built it beforehand.
+type statelessImageProvider struct {
+	*rhcosImageProvider
+	reader client.Reader
+	signer *imagehandler.URLSigner
+	log    logr.Logger
+
+	// opened records the images generated on request, indexed by image key,
+	// so that they can be discarded once the PreprovisioningImage is deleted.
+	opened     map[types.NamespacedName]map[string]imageprovider.ImageData
+	openedLock sync.Mutex
+}
+
+// NewStatelessImageProvider returns an ImageProvider that publishes images
+// at URLs signed by the given signer, and an http.FileSystem that serves the
+// images at those URLs by generating them from the PreprovisioningImage and
+// its network data Secret. The provider must have been created by
+// NewRHCOSImageProvider.
+func NewStatelessImageProvider(provider imageprovider.ImageProvider, reader client.Reader, signer *imagehandler.URLSigner, log logr.Logger) (imageprovider.ImageProvider, http.FileSystem) {
+	ip := &statelessImageProvider{
+		rhcosImageProvider: provider.(*rhcosImageProvider),
+		reader:             reader,
+		signer:             signer,
+		log:                log,
+		opened:             map[types.NamespacedName]map[string]imageprovider.ImageData{},
+	}
+	return ip, ip
+}
+
+func signedImage(data imageprovider.ImageData) imagehandler.SignedImage {
+	return imagehandler.SignedImage{
+		Namespace: data.ImageMetadata.Namespace,
+		Name:      data.ImageMetadata.Name,
+		UID:       string(data.ImageMetadata.UID),
+		Initramfs: data.Format == metal3.ImageFormatInitRD,
+	}
+}
+
+func (ip *statelessImageProvider) BuildImage(data imageprovider.ImageData, networkData imageprovider.NetworkData, log logr.Logger) (imageprovider.GeneratedImage, error) {
+	// Build the image anyway, to validate the configuration before publishing
+	// the URL and to have it ready when it is first requested.
+	content, err := ip.imageIgnition(data, networkData, log)
+	if err != nil {
+		return imageprovider.GeneratedImage{}, err
+	}
+	imageURL := ip.signer.URL(signedImage(data))
+	ip.Downloads.track(imageKey(data), imageURL, data.ImageMetadata)
+	generated := imageprovider.GeneratedImage{
+		ImageURL: imageURL,
+	}
+
+	// The kernel arguments are embedded in ISOs, but must be passed by Ironic
+	// when booting an initramfs
+	if data.Format == metal3.ImageFormatInitRD {
+		generated.ExtraKernelParams = content.network.KernelArgs
+	}
+	return generated, nil
+}
+
+func (ip *statelessImageProvider) DiscardImage(data imageprovider.ImageData) error {
+	ip.openedLock.Lock()
+	name := types.NamespacedName{Namespace: data.ImageMetadata.Namespace, Name: data.ImageMetadata.Name}
+	delete(ip.opened[name], imageKey(data))
+	if len(ip.opened[name]) == 0 {
+		delete(ip.opened, name)
+	}
+	ip.openedLock.Unlock()
+
+	ip.cancelBuild(imageKey(data))
+	ip.Downloads.forget(imageKey(data))
+	ip.ImageHandler.RemoveImage(ip.signer.FileName(signedImage(data)))
+	return nil
+}
+
+// Open generates the image at the given path, if the path is correctly
+// signed for an existing PreprovisioningImage.
+func (ip *statelessImageProvider) Open(name string) (http.File, error) {
+	if name == "/" {
+		return ip.ImageHandler.FileSystem().Open(name)
+	}
+
+	image, fileName, err := ip.signer.Parse(name)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	log := ip.log.WithValues("preprovisioningimage", image.Namespace+"/"+image.Name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), statelessBuildTimeout)
+	defer cancel()
+
+	img := &metal3.PreprovisioningImage{}
+	key := client.ObjectKey{Namespace: image.Namespace, Name: image.Name}
+	if err := ip.reader.Get(ctx, key, img); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+	image.UID = string(img.UID)
+	if !ip.signer.Verify(image, fileName) {
+		log.Info("image requested with invalid signature")
+		return nil, fs.ErrNotExist
+	}
+
+	var networkData imageprovider.NetworkData
+	if img.Spec.NetworkDataName != "" {
+		secret := &corev1.Secret{}
+		key := client.ObjectKey{Namespace: img.Namespace, Name: img.Spec.NetworkDataName}
+		if err := ip.reader.Get(ctx, key, secret); err != nil {
+			return nil, err
+		}
+		networkData = secret.Data
+	}
+
+	format := metal3.ImageFormatISO
+	if image.Initramfs {
+		format = metal3.ImageFormatInitRD
+	}
+	data := imageprovider.ImageData{
+		ImageMetadata: img.ObjectMeta.DeepCopy(),
+		Format:        format,
+		Architecture:  img.Spec.Architecture,
+	}
+	ip.recordOpened(data)
+	content, err := ip.waitForIgnition(ctx, data, networkData, log)
+	if err != nil {
+		log.Info("unable to generate image", "error", err.Error())
+		return nil, err
+	}
+
+	if _, err := ip.ImageHandler.ServeImage(fileName, content.ignition, content.network, image.Initramfs, true); err != nil {
+		return nil, err
+	}
+	ip.Downloads.track(imageKey(data), ip.signer.URL(image), data.ImageMetadata)
+	return ip.ImageHandler.FileSystem().Open(fileName)
+}
+
+// waitForIgnition returns the content for an image, waiting for it to be
+// built if necessary.
+func (ip *statelessImageProvider) waitForIgnition(ctx context.Context, data imageprovider.ImageData, networkData imageprovider.NetworkData, log logr.Logger) (imageContent, error) {
+	for {
+		content, err := ip.imageIgnition(data, networkData, log)
+		if !errors.As(err, &imageprovider.ImageNotReady{}) {
+			return content, err
+		}
+
+		// Wait for the build to finish, or poll if the image is not ready
+		// for another reason, e.g. while waiting for Ironic endpoints.
+		var done <-chan struct{}
+		ip.buildsLock.Lock()
+		if build, exists := ip.builds[imageKey(data)]; exists {
+			done = build.done
+		}
+		ip.buildsLock.Unlock()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return imageContent{}, ctx.Err()
+		}
+	}
+}
+
+// recordOpened notes an image generated on request.
+func (ip *statelessImageProvider) recordOpened(data imageprovider.ImageData) {
+	ip.openedLock.Lock()
+	defer ip.openedLock.Unlock()
+
+	name := types.NamespacedName{Namespace: data.ImageMetadata.Namespace, Name: data.ImageMetadata.Name}
+	if ip.opened[name] == nil {
+		ip.opened[name] = map[string]imageprovider.ImageData{}
+	}
+	ip.opened[name][imageKey(data)] = data
+}
+
+// discardOpened discards the images generated on request for a
+// PreprovisioningImage, except those for the given UID.
+func (ip *statelessImageProvider) discardOpened(name types.NamespacedName, uid types.UID) error {
+	ip.openedLock.Lock()
+	var stale []imageprovider.ImageData
+	for _, data := range ip.opened[name] {
+		if data.ImageMetadata.UID != uid {
+			stale = append(stale, data)
+		}
+	}
+	ip.openedLock.Unlock()
+
+	for _, data := range stale {
+		if err := ip.DiscardImage(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StatelessReconciler discards the images generated on request by a
+// stateless image provider once their PreprovisioningImage is deleted. It
+// runs on every replica, since replicas that are not the leader never
+// reconcile the PreprovisioningImage itself.
+type StatelessReconciler struct {
+	client.Client
+	ImageProvider imageprovider.ImageProvider
+}
+
+func (r *StatelessReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ip := r.ImageProvider.(*statelessImageProvider)
+
+	img := &metal3.PreprovisioningImage{}
+	if err := r.Get(ctx, req.NamespacedName, img); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, ip.discardOpened(req.NamespacedName, "")
+		}
+		return ctrl.Result{}, err
+	}
+
+	uid := img.UID
+	if !img.DeletionTimestamp.IsZero() {
+		uid = ""
+	}
+	return ctrl.Result{}, ip.discardOpened(req.NamespacedName, uid)
+}
+
+func (r *StatelessReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	c, err := controller.NewUnmanaged("preprovisioningimage-stateless", mgr, controller.Options{
+		Reconciler: r,
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &metal3.PreprovisioningImage{}},
+		&handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+	return mgr.Add(replicaController{c})
+}
@@ -0,0 +1,118 @@
+package imageprovider
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/url"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/metal3-io/baremetal-operator/pkg/imageprovider"
+	"github.com/openshift/image-customization-controller/pkg/imagehandler"
+)
+
+func TestStatelessImages(t *testing.T) {
+	baseURL, _ := url.Parse("http://images.example.com")
+	signer := imagehandler.NewURLSigner([]byte("key"), baseURL)
+	fake := &fakeClient{image: &metal3.PreprovisioningImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-0", Namespace: "test", UID: "uid"},
+		Spec:       metal3.PreprovisioningImageSpec{Architecture: "x86_64"},
+	}}
+	log := zap.New(zap.UseDevMode(true))
+
+	leader, _ := testProvider()
+	provider, _ := NewStatelessImageProvider(leader, fake, signer, log)
+	data := testImageData("host-0")
+	var image imageprovider.GeneratedImage
+	var err error
+	for i := 0; i < 100; i++ {
+		image, err = provider.BuildImage(data, nil, log)
+		if !errors.As(err, &imageprovider.ImageNotReady{}) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	imageURL, _ := url.Parse(image.ImageURL)
+	if imageURL.String() != signer.URL(signedImage(data)) {
+		t.Errorf("unexpected URL %s", image.ImageURL)
+	}
+
+	// Another replica serves the image without having built it
+	replica, handler := testProvider()
+	replicaProvider, fileSystem := NewStatelessImageProvider(replica, fake, signer, log)
+	if _, err := fileSystem.Open(imageURL.Path); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	fileName := signer.FileName(signedImage(data))
+	if _, served := handler.served[fileName]; !served {
+		t.Errorf("image not served")
+	}
+
+	if _, err := fileSystem.Open(imageURL.Path + "x"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected image with invalid signature not to exist, got %v", err)
+	}
+
+	// The URL is not valid for a new image with the same name
+	fake.image.UID = "new-uid"
+	if _, err := fileSystem.Open(imageURL.Path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected image for old UID not to exist, got %v", err)
+	}
+
+	// The replica discards the image once it is replaced
+	r := &StatelessReconciler{Client: fake, ImageProvider: replicaProvider}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "host-0"}}
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, served := handler.served[fileName]; served {
+		t.Errorf("image for old UID still served")
+	}
+	if len(replica.builds) != 0 {
+		t.Errorf("build for old UID not removed")
+	}
+
+	fake.image = nil
+	if _, err := fileSystem.Open(imageURL.Path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected deleted image not to exist, got %v", err)
+	}
+}
+
+func TestStatelessImageKernelArgs(t *testing.T) {
+	baseURL, _ := url.Parse("http://images.example.com")
+	signer := imagehandler.NewURLSigner([]byte("key"), baseURL)
+	log := zap.New(zap.UseDevMode(true))
+
+	leader, _ := testProvider()
+	leader.EnvInputs.IpOptions = "ip=dhcp6"
+	provider, _ := NewStatelessImageProvider(leader, &fakeClient{}, signer, log)
+	data := testImageData("host-0")
+	data.Format = metal3.ImageFormatInitRD
+	var image imageprovider.GeneratedImage
+	var err error
+	for i := 0; i < 100; i++ {
+		image, err = provider.BuildImage(data, nil, log)
+		if !errors.As(err, &imageprovider.ImageNotReady{}) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if image.ImageURL != signer.URL(signedImage(data)) {
+		t.Errorf("unexpected URL %s", image.ImageURL)
+	}
+	if image.ExtraKernelParams != "ip=dhcp6" {
+		t.Errorf("unexpected kernel params for initramfs %q", image.ExtraKernelParams)
+	}
+}
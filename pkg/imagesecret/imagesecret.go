@@ -0,0 +1,73 @@
+package imagesecret
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/metal3-io/baremetal-operator/pkg/secretutils"
+)
+
+// GetOrCreate returns the Secret with the given name in the namespace of an
+// image, creating it with the data returned by generate if it does not exist.
+// A created Secret is owned by the PreprovisioningImage, so that it is deleted
+// along with it, and is labelled so that it is cached. An existing Secret that
+// is not owned by the image (e.g. one left over from a deleted image of the
+// same name that has yet to be garbage collected) is not used, and an error is
+// returned instead.
+func GetOrCreate(ctx context.Context, c client.Client, apiReader client.Reader, image *metav1.ObjectMeta, name string, generate func() (*corev1.Secret, error)) (*corev1.Secret, error) {
+	key := client.ObjectKey{Namespace: image.Namespace, Name: name}
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, key, secret)
+	if !k8serrors.IsNotFound(err) {
+		if err != nil {
+			return nil, err
+		}
+		return checkOwner(secret, image)
+	}
+
+	secret, err = generate()
+	if err != nil {
+		return nil, err
+	}
+	secret.Name = key.Name
+	secret.Namespace = key.Namespace
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	secret.Labels[secretutils.LabelEnvironmentName] = secretutils.LabelEnvironmentValue
+	secret.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: metal3.GroupVersion.String(),
+		Kind:       "PreprovisioningImage",
+		Name:       image.Name,
+		UID:        image.UID,
+	}}
+
+	err = c.Create(ctx, secret)
+	if k8serrors.IsAlreadyExists(err) {
+		// Created by another replica since it was looked up
+		secret = &corev1.Secret{}
+		if err = apiReader.Get(ctx, key, secret); err != nil {
+			return nil, err
+		}
+		return checkOwner(secret, image)
+	}
+	return secret, err
+}
+
+// checkOwner returns the Secret if it is owned by the image.
+func checkOwner(secret *corev1.Secret, image *metav1.ObjectMeta) (*corev1.Secret, error) {
+	for _, owner := range secret.OwnerReferences {
+		if owner.Kind == "PreprovisioningImage" && owner.UID == image.UID {
+			return secret, nil
+		}
+	}
+	return nil, fmt.Errorf("secret %s/%s is not owned by PreprovisioningImage %s", secret.Namespace, secret.Name, image.Name)
+}
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create
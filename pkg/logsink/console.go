@@ -0,0 +1,122 @@
+package logsink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFieldSize is the maximum size of a binary journal field.
+const maxFieldSize = 1024 * 1024
+
+// readEntry reads an entry in the journal export format: fields of the form
+// NAME=value on a line each, or, for binary values, NAME on a line followed
+// by the size as a little-endian 64-bit integer, the data and a newline.
+// Entries are separated by an empty line.
+func readEntry(r *bufio.Reader) (map[string]string, error) {
+	entry := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			// An entry at the end of the log may be incomplete while it
+			// is being uploaded
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(entry) == 0 {
+				continue
+			}
+			return entry, nil
+		}
+		if name, value, isText := strings.Cut(line, "="); isText {
+			entry[name] = value
+			continue
+		}
+
+		header, err := r.Peek(8)
+		if err != nil {
+			return nil, err
+		}
+		size := binary.LittleEndian.Uint64(header)
+		if size > maxFieldSize {
+			// Leave the data to be skipped, in case this is not a field
+			return nil, fmt.Errorf("journal field %s too large", line)
+		}
+		if _, err := r.Discard(8); err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+1)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		entry[line] = string(data[:size])
+	}
+}
+
+// consoleLine renders a journal entry as a line of text, in the form shown on
+// the console.
+func consoleLine(entry map[string]string) (string, bool) {
+	message, exists := entry["MESSAGE"]
+	if !exists {
+		return "", false
+	}
+
+	timestamp := ""
+	if usec, err := strconv.ParseInt(entry["__REALTIME_TIMESTAMP"], 10, 64); err == nil {
+		timestamp = time.UnixMicro(usec).UTC().Format("2006-01-02T15:04:05.000000Z") + " "
+	}
+	identifier := entry["SYSLOG_IDENTIFIER"]
+	switch {
+	case entry["_TRANSPORT"] == "kernel":
+		identifier = "kernel"
+	case identifier == "":
+		identifier = entry["_COMM"]
+	}
+	if pid := entry["_PID"]; pid != "" && identifier != "kernel" {
+		identifier += "[" + pid + "]"
+	}
+	return fmt.Sprintf("%s%s: %s\n", timestamp, identifier, strings.TrimRight(message, "\n")), true
+}
+
+// skipEntry discards the rest of a malformed entry.
+func skipEntry(r *bufio.Reader) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if line == "\n" {
+			return nil
+		}
+	}
+}
+
+// renderConsole writes the messages in a journal, in the export format, as
+// text. The journal may start in the middle of an entry once it has been
+// rotated, so malformed entries are skipped.
+func renderConsole(w io.Writer, journal io.Reader) error {
+	r := bufio.NewReader(journal)
+	for {
+		entry, err := readEntry(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			if err := skipEntry(r); err != nil {
+				return nil
+			}
+			continue
+		}
+		if line, ok := consoleLine(entry); ok {
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestRenderConsole(t *testing.T) {
+	journal := &bytes.Buffer{}
+	// The tail of an entry split when the log was rotated
+	journal.WriteString("ing\n_PID=1\n\n")
+	journal.WriteString("__REALTIME_TIMESTAMP=1700000000000000\n_TRANSPORT=kernel\nMESSAGE=Linux version 5.14.0\n\n")
+	journal.WriteString("__REALTIME_TIMESTAMP=1700000001500000\nSYSLOG_IDENTIFIER=podman\n_PID=42\nMESSAGE\n")
+	message := "Error: pulling image\nunauthorized"
+	_ = binary.Write(journal, binary.LittleEndian, uint64(len(message)))
+	journal.WriteString(message + "\n\n")
+	journal.WriteString("_COMM=ironic-python-agent\nMESSAGE=no timestamp\n\n")
+	// An entry that is still being uploaded
+	journal.WriteString("__REALTIME_TIMESTAMP=1700000002000000\nMESSAGE=incompl")
+
+	out := &strings.Builder{}
+	if err := renderConsole(out, journal); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := "2023-11-14T22:13:20.000000Z kernel: Linux version 5.14.0\n" +
+		"2023-11-14T22:13:21.500000Z podman[42]: Error: pulling image\nunauthorized\n" +
+		"ironic-python-agent: no timestamp\n"
+	if out.String() != expected {
+		t.Errorf("unexpected console log:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
@@ -0,0 +1,349 @@
+package logsink
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/imagehandler"
+)
+
+const (
+	// PathPrefix is the path on the image server at which logs are uploaded
+	// and downloaded.
+	PathPrefix = "/logs/"
+
+	// LogsURLAnnotation is the annotation on a PreprovisioningImage that
+	// records the URL from which the logs of its ramdisk can be downloaded.
+	LogsURLAnnotation = "imagecustomization.openshift.io/logs-url"
+
+	logSuffix     = ".journal"
+	rotatedSuffix = ".journal.1"
+
+	// cleanupInterval is how often expired logs are removed.
+	cleanupInterval = time.Hour
+)
+
+// Sink stores the journals uploaded by the ramdisk of each host on disk,
+// and serves them for download. Each host's log is rotated once it reaches
+// the maximum size, keeping one previous file, and logs that have not been
+// written to within the retention period are removed.
+type Sink struct {
+	dir       string
+	key       []byte
+	baseURL   *url.URL
+	maxSize   int64
+	retention time.Duration
+	client    client.Client
+	log       logr.Logger
+
+	// locks serialises uploads to the log of each host.
+	locks     map[string]*sync.Mutex
+	locksLock sync.Mutex
+}
+
+// NewSink returns a Sink that stores logs in the given directory. Uploads
+// and downloads are authenticated with tokens signed with the key, and are
+// accepted only for PreprovisioningImages that exist. The download URL of
+// each image's logs is recorded in an annotation using the client.
+func NewSink(dir string, key []byte, baseURL *url.URL, maxSize int64, retention time.Duration, c client.Client, log logr.Logger) *Sink {
+	return &Sink{
+		dir:       dir,
+		key:       key,
+		baseURL:   baseURL,
+		maxSize:   maxSize,
+		retention: retention,
+		client:    c,
+		log:       log,
+		locks:     map[string]*sync.Mutex{},
+	}
+}
+
+const (
+	uploadPurpose   = "log-upload"
+	downloadPurpose = "log-download"
+)
+
+// token returns the token that authenticates uploads or downloads for an
+// image. Separate tokens are used, since the upload token is embedded in the
+// image.
+func (s *Sink) token(purpose string, meta metav1.Object) string {
+	return imagehandler.ObjectToken(s.key, purpose, meta)
+}
+
+// UploadURL returns the URL to which the ramdisk for an image uploads its
+// journal. The token is part of the path, since the upload client cannot
+// send other credentials.
+func (s *Sink) UploadURL(meta metav1.Object) string {
+	p := &url.URL{Path: path.Join(PathPrefix, meta.GetNamespace(), meta.GetName(), s.token(uploadPurpose, meta))}
+	return s.baseURL.ResolveReference(p).String()
+}
+
+// DownloadURL returns the URL from which the journal of the ramdisk for an
+// image can be downloaded. The console log is available at the same URL with
+// /console appended.
+func (s *Sink) DownloadURL(meta metav1.Object) string {
+	p := &url.URL{Path: path.Join(PathPrefix, meta.GetNamespace(), meta.GetName(), s.token(downloadPurpose, meta))}
+	return s.baseURL.ResolveReference(p).String()
+}
+
+func (s *Sink) logPath(namespace, name string) string {
+	return filepath.Join(s.dir, namespace, name+logSuffix)
+}
+
+func (s *Sink) lock(namespace, name string) *sync.Mutex {
+	s.locksLock.Lock()
+	defer s.locksLock.Unlock()
+
+	key := namespace + "/" + name
+	if _, exists := s.locks[key]; !exists {
+		s.locks[key] = &sync.Mutex{}
+	}
+	return s.locks[key]
+}
+
+// ServeHTTP accepts uploads with POST (or PUT) at
+// /logs/<namespace>/<name>/<upload token>, and serves the stored journal with
+// GET at /logs/<namespace>/<name>/<download token>, or a text rendering of it
+// at the same path with /console appended.
+func (s *Sink) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, PathPrefix), "/"), "/")
+	if len(parts) < 3 || len(parts) > 4 || parts[0] == "" || parts[1] == "" ||
+		strings.HasPrefix(parts[0], ".") || strings.HasPrefix(parts[1], ".") {
+		http.NotFound(w, req)
+		return
+	}
+	namespace, name, token := parts[0], parts[1], parts[2]
+
+	switch req.Method {
+	case http.MethodGet:
+		console := len(parts) == 4
+		if console && parts[3] != "console" {
+			http.NotFound(w, req)
+			return
+		}
+		if _, ok := s.authorize(w, req, namespace, name, downloadPurpose, token); ok {
+			s.download(w, req, namespace, name, console)
+		}
+	case http.MethodPost, http.MethodPut:
+		// systemd-journal-upload appends /upload to the URL
+		if len(parts) == 4 && parts[3] != "upload" {
+			http.NotFound(w, req)
+			return
+		}
+		if img, ok := s.authorize(w, req, namespace, name, uploadPurpose, token); ok {
+			s.upload(w, req, img)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorize checks the token of a request for the logs of an image, and
+// returns the image if it is valid. Otherwise, an error is written.
+func (s *Sink) authorize(w http.ResponseWriter, req *http.Request, namespace, name, purpose, token string) (*metal3.PreprovisioningImage, bool) {
+	img := &metal3.PreprovisioningImage{}
+	if err := s.client.Get(req.Context(), client.ObjectKey{Namespace: namespace, Name: name}, img); err != nil {
+		if k8serrors.IsNotFound(err) {
+			http.NotFound(w, req)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if !hmac.Equal([]byte(token), []byte(s.token(purpose, img))) {
+		s.log.Info("log request with invalid token", "preprovisioningimage", namespace+"/"+name, "method", req.Method)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	return img, true
+}
+
+func (s *Sink) upload(w http.ResponseWriter, req *http.Request, img *metal3.PreprovisioningImage) {
+	namespace, name := img.Namespace, img.Name
+	log := s.log.WithValues("preprovisioningimage", namespace+"/"+name)
+
+	lock := s.lock(namespace, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	written, err := s.appendLog(namespace, name, req.Body)
+	if err != nil {
+		log.Info("log upload failed", "error", err.Error(), "bytes", written)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Info("received ramdisk logs", "bytes", written)
+	if err := s.recordDownloadURL(req.Context(), img); err != nil {
+		log.Info("unable to record logs URL", "error", err.Error())
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recordDownloadURL writes the download URL of the logs of an image to an
+// annotation, if it is not already there, so that users who can read the
+// PreprovisioningImage can find them.
+func (s *Sink) recordDownloadURL(ctx context.Context, img *metal3.PreprovisioningImage) error {
+	downloadURL := s.DownloadURL(img)
+	if img.Annotations[LogsURLAnnotation] == downloadURL {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid": img.UID,
+			"annotations": map[string]string{
+				LogsURLAnnotation: downloadURL,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return client.IgnoreNotFound(s.client.Patch(ctx, img,
+		client.RawPatch(types.MergePatchType, patch)))
+}
+
+// appendLog writes the uploaded data to the end of the log, rotating it
+// whenever it reaches the maximum size.
+func (s *Sink) appendLog(namespace, name string, data io.Reader) (int64, error) {
+	logPath := s.logPath(namespace, name)
+	if err := os.MkdirAll(filepath.Dir(logPath), 0700); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for {
+		f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return total, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return total, err
+		}
+
+		remaining := s.maxSize - info.Size()
+		if remaining <= 0 {
+			f.Close()
+			if err := os.Rename(logPath, strings.TrimSuffix(logPath, logSuffix)+rotatedSuffix); err != nil {
+				return total, err
+			}
+			continue
+		}
+
+		n, err := io.Copy(f, io.LimitReader(data, remaining))
+		total += n
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil || n < remaining {
+			return total, err
+		}
+	}
+}
+
+// download serves the stored log, either in the journal export format or
+// rendered as text. Uploads are streamed for as long as the ramdisk runs, so
+// the log is read without waiting for them to finish.
+func (s *Sink) download(w http.ResponseWriter, req *http.Request, namespace, name string, console bool) {
+	logPath := s.logPath(namespace, name)
+	var files []io.Reader
+	for _, file := range []string{strings.TrimSuffix(logPath, logSuffix) + rotatedSuffix, logPath} {
+		f, err := os.Open(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			s.log.Info("unable to read log", "path", file, "error", err.Error())
+			http.Error(w, "unable to read log", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+
+	// The rotated file continues in the current one
+	journal := io.MultiReader(files...)
+	var err error
+	if console {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err = renderConsole(w, journal)
+	} else {
+		w.Header().Set("Content-Type", "application/vnd.fdo.journal")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", namespace+"-"+name+logSuffix))
+		_, err = io.Copy(w, journal)
+	}
+	if err != nil {
+		s.log.Info("unable to send log", "preprovisioningimage", namespace+"/"+name, "error", err.Error())
+	}
+}
+
+// removeExpired deletes the logs that have not been written to within the
+// retention period.
+func (s *Sink) removeExpired() error {
+	cutoff := time.Now().Add(-s.retention)
+	return filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if info.ModTime().Before(cutoff) {
+			s.log.Info("removing expired log", "path", p)
+			if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Start removes expired logs periodically until the context is done.
+func (s *Sink) Start(ctx context.Context) error {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.removeExpired(); err != nil {
+			s.log.Info("unable to remove expired logs", "error", err.Error())
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// NeedLeaderElection returns false, since the sink runs only in a single
+// replica of the controller, which stores every log it receives.
+func (s *Sink) NeedLeaderElection() bool {
+	return false
+}
+
+// +kubebuilder:rbac:groups=metal3.io,resources=preprovisioningimages,verbs=get;list;watch;patch
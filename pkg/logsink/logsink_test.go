@@ -0,0 +1,126 @@
+package logsink
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+)
+
+type fakeClient struct {
+	client.Client
+	image   *metal3.PreprovisioningImage
+	patches []string
+}
+
+func (f *fakeClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if key != client.ObjectKeyFromObject(f.image) {
+		return k8serrors.NewNotFound(schema.GroupResource{}, key.Name)
+	}
+	f.image.DeepCopyInto(obj.(*metal3.PreprovisioningImage))
+	return nil
+}
+
+func (f *fakeClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	f.patches = append(f.patches, string(data))
+	return nil
+}
+
+func TestSink(t *testing.T) {
+	dir := t.TempDir()
+	baseURL, _ := url.Parse("http://images.example.com:8084")
+	img := &metal3.PreprovisioningImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-0", Namespace: "test", UID: "uid"},
+	}
+	fake := &fakeClient{image: img}
+	sink := NewSink(dir, []byte("key"), baseURL, 10, time.Hour, fake,
+		zap.New(zap.UseDevMode(true)))
+
+	uploadURL, _ := url.Parse(sink.UploadURL(img))
+	if !strings.HasPrefix(uploadURL.String(), "http://images.example.com:8084/logs/test/host-0/") {
+		t.Fatalf("unexpected upload URL %s", uploadURL)
+	}
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		sink.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+
+	downloadURL, _ := url.Parse(sink.DownloadURL(img))
+	if downloadURL.Path == uploadURL.Path {
+		t.Fatalf("upload token is valid for downloads")
+	}
+
+	if rr := request(http.MethodGet, downloadURL.Path, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected no logs, got %d", rr.Code)
+	}
+	if rr := request(http.MethodPost, "/logs/test/host-0/invalid", "data"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected invalid token to be rejected, got %d", rr.Code)
+	}
+	if rr := request(http.MethodPost, "/logs/test/host-1/token", "data"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected unknown image not to be found, got %d", rr.Code)
+	}
+
+	if rr := request(http.MethodPost, uploadURL.Path, "0123456"); rr.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", rr.Code)
+	}
+	if rr := request(http.MethodPost, uploadURL.Path+"/upload", "789abcdef"); rr.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", rr.Code)
+	}
+
+	// The log is rotated at the maximum size, keeping one previous file
+	rotated, _ := os.ReadFile(filepath.Join(dir, "test", "host-0.journal.1"))
+	current, _ := os.ReadFile(filepath.Join(dir, "test", "host-0.journal"))
+	if string(rotated) != "0123456789" || string(current) != "abcdef" {
+		t.Errorf("unexpected files %q %q", rotated, current)
+	}
+
+	rr := request(http.MethodGet, downloadURL.Path, "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "0123456789abcdef" {
+		t.Errorf("unexpected download %d %q", rr.Code, rr.Body.String())
+	}
+
+	// Downloads require the download token
+	for _, path := range []string{"/logs/test/host-0", uploadURL.Path, "/logs/test/host-0/invalid/console"} {
+		if rr := request(http.MethodGet, path, ""); rr.Code == http.StatusOK {
+			t.Errorf("unauthorized download from %s", path)
+		}
+	}
+
+	// The download URL is recorded on the image
+	if len(fake.patches) != 2 || !strings.Contains(fake.patches[0], downloadURL.String()) {
+		t.Errorf("unexpected patches %v", fake.patches)
+	}
+
+	// Logs are removed once they expire
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"host-0.journal", "host-0.journal.1"} {
+		if err := os.Chtimes(filepath.Join(dir, "test", name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.removeExpired(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if rr := request(http.MethodGet, downloadURL.Path, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected expired logs to be removed, got %d", rr.Code)
+	}
+}
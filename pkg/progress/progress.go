@@ -0,0 +1,226 @@
+package progress
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/openshift/image-customization-controller/pkg/imagehandler"
+)
+
+// Milestone is a stage in the boot of the ramdisk.
+type Milestone string
+
+const (
+	MilestoneInitramfs        Milestone = "initramfs"
+	MilestoneNetworkOnline    Milestone = "network-online"
+	MilestoneAgentImagePulled Milestone = "agent-image-pulled"
+	MilestoneAgentStarted     Milestone = "agent-started"
+	MilestoneFailed           Milestone = "failed"
+)
+
+// reasons maps each milestone to the reason used in Events and the
+// condition.
+var reasons = map[Milestone]string{
+	MilestoneInitramfs:        "InitramfsComplete",
+	MilestoneNetworkOnline:    "NetworkOnline",
+	MilestoneAgentImagePulled: "AgentImagePulled",
+	MilestoneAgentStarted:     "AgentStarted",
+	MilestoneFailed:           "BootFailed",
+}
+
+// ConditionBootProgress is the PreprovisioningImage condition reporting the
+// latest milestone reached by the ramdisk. It is True once the agent has
+// started, and False if the boot has failed.
+const ConditionBootProgress = "BootProgress"
+
+const (
+	// PathPrefix is the path on the image server at which progress is
+	// reported.
+	PathPrefix = "/progress/"
+
+	// maxReportSize is the maximum size of a progress report.
+	maxReportSize = 64 * 1024
+	// maxMessageLength is the maximum length of the message recorded in an
+	// Event or condition.
+	maxMessageLength = 1024
+	// updateAttempts is the number of times to retry a conflicting update.
+	updateAttempts = 5
+)
+
+// Report is the body of a progress callback from the ramdisk.
+type Report struct {
+	Milestone Milestone `json:"milestone"`
+	Message   string    `json:"message,omitempty"`
+	// Journal is an excerpt of the journal, sent with failures.
+	Journal string `json:"journal,omitempty"`
+}
+
+// Callbacks generates the URL and token with which the ramdisk for each
+// image reports its progress, and handles the reports.
+type Callbacks struct {
+	key     []byte
+	baseURL *url.URL
+}
+
+// NewCallbacks returns Callbacks that sign tokens with the given key and
+// receive reports at the image server with the given base URL.
+func NewCallbacks(key []byte, baseURL *url.URL) *Callbacks {
+	return &Callbacks{key: key, baseURL: baseURL}
+}
+
+// URL returns the URL to which the ramdisk for an image reports progress.
+func (c *Callbacks) URL(meta metav1.Object) string {
+	p := &url.URL{Path: path.Join(PathPrefix, meta.GetNamespace(), meta.GetName())}
+	return c.baseURL.ResolveReference(p).String()
+}
+
+// Token returns the token with which the ramdisk for an image authenticates
+// its reports.
+func (c *Callbacks) Token(meta metav1.Object) string {
+	return imagehandler.ObjectToken(c.key, "progress", meta)
+}
+
+// Handler returns an http.Handler that receives progress reports and records
+// them as Events and a condition on the PreprovisioningImage.
+func (c *Callbacks) Handler(k8sClient client.Client, recorder record.EventRecorder, log logr.Logger) http.Handler {
+	return &handler{callbacks: c, client: k8sClient, recorder: recorder, log: log}
+}
+
+type handler struct {
+	callbacks *Callbacks
+	client    client.Client
+	recorder  record.EventRecorder
+	log       logr.Logger
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, PathPrefix), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, req)
+		return
+	}
+	key := client.ObjectKey{Namespace: parts[0], Name: parts[1]}
+	log := h.log.WithValues("preprovisioningimage", key)
+
+	img := &metal3.PreprovisioningImage{}
+	if err := h.client.Get(req.Context(), key, img); err != nil {
+		if k8serrors.IsNotFound(err) {
+			http.NotFound(w, req)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !hmac.Equal([]byte(token), []byte(h.callbacks.Token(img))) {
+		log.Info("progress report with invalid token")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	report := Report{}
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxReportSize)).Decode(&report); err != nil {
+		http.Error(w, "invalid report", http.StatusBadRequest)
+		return
+	}
+	if _, valid := reasons[report.Milestone]; !valid {
+		http.Error(w, fmt.Sprintf("unknown milestone %q", report.Milestone), http.StatusBadRequest)
+		return
+	}
+
+	log.Info("boot progress", "milestone", report.Milestone, "message", report.Message)
+	h.recordEvent(img, report)
+	if err := h.updateCondition(req.Context(), key, img.UID, report); err != nil {
+		log.Info("unable to update boot progress condition", "error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) recordEvent(img *metal3.PreprovisioningImage, report Report) {
+	eventType := corev1.EventTypeNormal
+	if report.Milestone == MilestoneFailed {
+		eventType = corev1.EventTypeWarning
+	}
+	message := report.Message
+	if message == "" {
+		message = fmt.Sprintf("Ramdisk reached milestone %s", report.Milestone)
+	}
+	if report.Journal != "" {
+		message = fmt.Sprintf("%s\n%s", message, report.Journal)
+	}
+	h.recorder.Event(img, eventType, reasons[report.Milestone], truncate(message))
+}
+
+// progressCondition returns the condition reflecting a progress report.
+func progressCondition(report Report, generation int64) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               ConditionBootProgress,
+		Status:             metav1.ConditionUnknown,
+		ObservedGeneration: generation,
+		Reason:             reasons[report.Milestone],
+		Message:            truncate(report.Message),
+	}
+	switch report.Milestone {
+	case MilestoneAgentStarted:
+		condition.Status = metav1.ConditionTrue
+	case MilestoneFailed:
+		condition.Status = metav1.ConditionFalse
+	}
+	return condition
+}
+
+// updateCondition sets the condition on the PreprovisioningImage, retrying
+// if the update conflicts with another.
+func (h *handler) updateCondition(ctx context.Context, key client.ObjectKey, uid types.UID, report Report) error {
+	var err error
+	for attempt := 0; attempt < updateAttempts; attempt++ {
+		img := &metal3.PreprovisioningImage{}
+		if err = h.client.Get(ctx, key, img); err != nil {
+			return err
+		}
+		if img.UID != uid {
+			return nil
+		}
+		meta.SetStatusCondition(&img.Status.Conditions, progressCondition(report, img.Generation))
+		err = h.client.Status().Update(ctx, img)
+		if !k8serrors.IsConflict(err) {
+			return err
+		}
+		time.Sleep(time.Duration(attempt+1) * 100 * time.Millisecond)
+	}
+	return err
+}
+
+func truncate(message string) string {
+	if len(message) > maxMessageLength {
+		return message[:maxMessageLength-3] + "..."
+	}
+	return message
+}
+
+// +kubebuilder:rbac:groups=metal3.io,resources=preprovisioningimages/status,verbs=get;update;patch
@@ -0,0 +1,115 @@
+package progress
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	metal3 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+)
+
+type fakeClient struct {
+	client.Client
+	image     *metal3.PreprovisioningImage
+	conflicts int
+}
+
+func (f *fakeClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if f.image == nil || key != client.ObjectKeyFromObject(f.image) {
+		return k8serrors.NewNotFound(schema.GroupResource{}, key.Name)
+	}
+	f.image.DeepCopyInto(obj.(*metal3.PreprovisioningImage))
+	return nil
+}
+
+func (f *fakeClient) Status() client.StatusWriter {
+	return f
+}
+
+func (f *fakeClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if f.conflicts > 0 {
+		f.conflicts--
+		return k8serrors.NewConflict(schema.GroupResource{}, obj.GetName(), nil)
+	}
+	obj.(*metal3.PreprovisioningImage).DeepCopyInto(f.image)
+	return nil
+}
+
+func (f *fakeClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return nil
+}
+
+func TestCallbacks(t *testing.T) {
+	baseURL, _ := url.Parse("http://images.example.com:8084")
+	callbacks := NewCallbacks([]byte("key"), baseURL)
+	img := &metal3.PreprovisioningImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-0", Namespace: "test", UID: "uid"},
+	}
+	fake := &fakeClient{image: img.DeepCopy(), conflicts: 1}
+	events := record.NewFakeRecorder(10)
+	handler := callbacks.Handler(fake, events, zap.New(zap.UseDevMode(true)))
+
+	callbackURL := callbacks.URL(img)
+	if callbackURL != "http://images.example.com:8084/progress/test/host-0" {
+		t.Fatalf("unexpected URL %s", callbackURL)
+	}
+
+	post := func(path, token, body string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	token := callbacks.Token(img)
+	if code := post("/progress/test/host-0", "invalid", `{"milestone":"initramfs"}`); code != http.StatusUnauthorized {
+		t.Errorf("expected invalid token to be rejected, got %d", code)
+	}
+	if code := post("/progress/test/host-1", token, `{"milestone":"initramfs"}`); code != http.StatusNotFound {
+		t.Errorf("expected unknown image not to be found, got %d", code)
+	}
+	if code := post("/progress/test/host-0", token, `{"milestone":"unknown"}`); code != http.StatusBadRequest {
+		t.Errorf("expected unknown milestone to be rejected, got %d", code)
+	}
+
+	if code := post("/progress/test/host-0", token, `{"milestone":"network-online"}`); code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", code)
+	}
+	condition := meta.FindStatusCondition(fake.image.Status.Conditions, ConditionBootProgress)
+	if condition == nil || condition.Status != metav1.ConditionUnknown || condition.Reason != "NetworkOnline" {
+		t.Errorf("unexpected condition %+v", condition)
+	}
+	if event := <-events.Events; !strings.HasPrefix(event, "Normal NetworkOnline") {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	if code := post("/progress/test/host-0", token,
+		`{"milestone":"failed","message":"pull failed","journal":"Error: unauthorized"}`); code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", code)
+	}
+	condition = meta.FindStatusCondition(fake.image.Status.Conditions, ConditionBootProgress)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Message != "pull failed" {
+		t.Errorf("unexpected condition %+v", condition)
+	}
+	if event := <-events.Events; event != "Warning BootFailed pull failed\nError: unauthorized" {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	// The token is not valid for a new image with the same name
+	fake.image.UID = "new-uid"
+	if code := post("/progress/test/host-0", token, `{"milestone":"agent-started"}`); code != http.StatusUnauthorized {
+		t.Errorf("expected token for old UID to be rejected, got %d", code)
+	}
+}
@@ -0,0 +1,76 @@
+package pullsecret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// AuthFile is the containers auth file format used by podman, which is the
+// same as the content of a kubernetes.io/dockerconfigjson Secret.
+type AuthFile struct {
+	Auths map[string]json.RawMessage `json:"auths"`
+}
+
+// Parse reads a pull secret in either the kubernetes.io/dockerconfigjson
+// format or the legacy kubernetes.io/dockercfg format. The data may
+// optionally be base64-encoded.
+func Parse(data []byte) (*AuthFile, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' {
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("pull secret is neither JSON nor base64-encoded JSON")
+		}
+		data = bytes.TrimSpace(decoded)
+	}
+
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid pull secret: %w", err)
+	}
+
+	authFile := &AuthFile{Auths: map[string]json.RawMessage{}}
+	if auths, found := raw["auths"]; found {
+		if err := json.Unmarshal(auths, &authFile.Auths); err != nil {
+			return nil, fmt.Errorf("invalid pull secret auths: %w", err)
+		}
+		return authFile, nil
+	}
+
+	// The legacy format has the registries at the top level
+	for registry, auth := range raw {
+		authFile.Auths[registry] = auth
+	}
+	return authFile, nil
+}
+
+// Merge returns an AuthFile containing the credentials for all registries in
+// both files. Where both contain credentials for the same registry, those in
+// the override are used.
+func Merge(base, override *AuthFile) *AuthFile {
+	merged := &AuthFile{Auths: map[string]json.RawMessage{}}
+	for registry, auth := range base.Auths {
+		merged.Auths[registry] = auth
+	}
+	for registry, auth := range override.Auths {
+		merged.Auths[registry] = auth
+	}
+	return merged
+}
+
+// JSON returns the auth file content.
+func (a *AuthFile) JSON() ([]byte, error) {
+	return json.Marshal(a)
+}
+
+// Encode returns the base64-encoded auth file content, as used in the
+// IRONIC_AGENT_PULL_SECRET environment variable.
+func (a *AuthFile) Encode() (string, error) {
+	data, err := a.JSON()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,76 @@
+package pullsecret
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	const expected = `{"auths":{"quay.io":{"auth":"Zm9vOmJhcg=="}}}`
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{
+			name: "dockerconfigjson",
+			data: expected,
+		},
+		{
+			name: "dockercfg",
+			data: `{"quay.io":{"auth":"Zm9vOmJhcg=="}}`,
+		},
+		{
+			name: "base64",
+			data: base64.StdEncoding.EncodeToString([]byte(expected)) + "\n",
+		},
+		{
+			name:    "not JSON",
+			data:    "pull secret",
+			wantErr: true,
+		},
+		{
+			name:    "invalid auths",
+			data:    `{"auths":[]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authFile, err := Parse([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			data, err := authFile.JSON()
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if string(data) != expected {
+				t.Errorf("unexpected auth file %s", data)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base, _ := Parse([]byte(`{"auths":{"quay.io":{"auth":"YQ=="},"registry.example.com":{"auth":"Yg=="}}}`))
+	override, _ := Parse([]byte(`{"auths":{"registry.example.com":{"auth":"Yw=="}}}`))
+
+	data, err := Merge(base, override).JSON()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if string(data) != `{"auths":{"quay.io":{"auth":"YQ=="},"registry.example.com":{"auth":"Yw=="}}}` {
+		t.Errorf("unexpected merged auth file %s", data)
+	}
+	if len(base.Auths) != 2 || string(base.Auths["registry.example.com"]) != `{"auth":"Yg=="}` {
+		t.Error("base modified by merge")
+	}
+}
@@ -0,0 +1,191 @@
+package registries
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+)
+
+// MirrorsDropinName is the name of the registries.conf.d drop-in rendered
+// from the mirror settings. It is sorted after other drop-ins so that the
+// settings take precedence.
+const MirrorsDropinName = "99-image-customization-mirrors.conf"
+
+var (
+	// locationRegexp matches a registry host (optionally with a port or a
+	// leading wildcard) followed by an optional repository path.
+	locationRegexp = regexp.MustCompile(`^(\*\.)?[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?(:[0-9]+)?(/[a-z0-9._/-]+)?$`)
+	// certsDirRegexp matches the name of a directory in certs.d.
+	certsDirRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?(:[0-9]+)?$`)
+)
+
+// Config is a containers-registries.conf(5) file in the V2 format. Keys that
+// are not part of the format are rejected, so that a misspelt setting is not
+// silently ignored on the host.
+type Config struct {
+	UnqualifiedSearchRegistries    []string          `toml:"unqualified-search-registries,omitempty"`
+	ShortNameMode                  string            `toml:"short-name-mode,omitempty"`
+	CredentialHelpers              []string          `toml:"credential-helpers,omitempty"`
+	AdditionalLayerStoreAuthHelper string            `toml:"additional-layer-store-auth-helper,omitempty"`
+	Registries                     []Registry        `toml:"registry,omitempty"`
+	Aliases                        map[string]string `toml:"aliases,omitempty"`
+}
+
+// Registry is a [[registry]] table.
+type Registry struct {
+	Prefix             string   `toml:"prefix,omitempty"`
+	Location           string   `toml:"location,omitempty"`
+	Insecure           bool     `toml:"insecure,omitempty"`
+	Blocked            bool     `toml:"blocked,omitempty"`
+	MirrorByDigestOnly bool     `toml:"mirror-by-digest-only,omitempty"`
+	PullFromMirror     string   `toml:"pull-from-mirror,omitempty"`
+	Mirrors            []Mirror `toml:"mirror,omitempty"`
+}
+
+// Mirror is a [[registry.mirror]] table.
+type Mirror struct {
+	Location       string `toml:"location"`
+	Insecure       bool   `toml:"insecure,omitempty"`
+	PullFromMirror string `toml:"pull-from-mirror,omitempty"`
+}
+
+// Parse parses and validates a registries.conf file or drop-in.
+func Parse(data []byte) (*Config, error) {
+	config := &Config{}
+	md, err := toml.Decode(string(data), config)
+	if err != nil {
+		return nil, err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown key %s", undecoded[0])
+	}
+
+	prefixes := map[string]bool{}
+	for _, registry := range config.Registries {
+		prefix := registry.Prefix
+		if prefix == "" {
+			prefix = registry.Location
+		}
+		if prefix == "" {
+			return nil, fmt.Errorf("registry has neither a prefix nor a location")
+		}
+		if prefixes[prefix] {
+			return nil, fmt.Errorf("registry %s is configured more than once", prefix)
+		}
+		prefixes[prefix] = true
+		if registry.Location != "" && strings.HasPrefix(registry.Location, "*.") {
+			return nil, fmt.Errorf("registry location %s must not contain a wildcard", registry.Location)
+		}
+		if strings.HasPrefix(prefix, "*.") && registry.Location != "" {
+			return nil, fmt.Errorf("registry %s with a wildcard prefix must not have a location", prefix)
+		}
+		for _, mirror := range registry.Mirrors {
+			if mirror.Location == "" {
+				return nil, fmt.Errorf("mirror of registry %s has no location", prefix)
+			}
+		}
+	}
+	return config, nil
+}
+
+// ValidateMirrors checks that the mirror settings can be rendered.
+func ValidateMirrors(mirrors []v1alpha1.RegistryMirror) error {
+	sources := map[string]bool{}
+	for _, mirror := range mirrors {
+		if !locationRegexp.MatchString(mirror.Source) {
+			return fmt.Errorf("invalid mirror source %q", mirror.Source)
+		}
+		if sources[mirror.Source] {
+			return fmt.Errorf("mirror source %s is configured more than once", mirror.Source)
+		}
+		sources[mirror.Source] = true
+		if len(mirror.Mirrors) == 0 {
+			return fmt.Errorf("mirror source %s has no mirrors", mirror.Source)
+		}
+		for _, location := range mirror.Mirrors {
+			if !locationRegexp.MatchString(location) || strings.HasPrefix(location, "*.") {
+				return fmt.Errorf("invalid mirror %q for %s", location, mirror.Source)
+			}
+		}
+	}
+	return nil
+}
+
+// MirrorsDropin returns a registries.conf.d drop-in configuring the mirrors.
+func MirrorsDropin(mirrors []v1alpha1.RegistryMirror) ([]byte, error) {
+	if err := ValidateMirrors(mirrors); err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	for _, mirror := range mirrors {
+		registry := Registry{
+			Prefix:             mirror.Source,
+			MirrorByDigestOnly: mirror.MirrorByDigestOnly,
+		}
+		if !strings.HasPrefix(mirror.Source, "*.") {
+			registry.Location = mirror.Source
+		}
+		for _, location := range mirror.Mirrors {
+			registry.Mirrors = append(registry.Mirrors, Mirror{Location: location})
+		}
+		config.Registries = append(config.Registries, registry)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := toml.NewEncoder(buf).Encode(config); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ValidateDropins checks the registries.conf.d drop-ins, indexed by file
+// name.
+func ValidateDropins(dropins map[string][]byte) error {
+	for name, data := range dropins {
+		if !strings.HasSuffix(name, ".conf") {
+			return fmt.Errorf("registries.conf.d drop-in %s must have the .conf suffix", name)
+		}
+		if name == MirrorsDropinName {
+			return fmt.Errorf("registries.conf.d drop-in %s is reserved for the mirror settings", name)
+		}
+		if _, err := Parse(data); err != nil {
+			return fmt.Errorf("invalid registries.conf.d drop-in %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ValidateCerts checks the files for certs.d, indexed by their path relative
+// to it: <registry>/<file>, where the registry has an optional port. CA
+// certificates (*.crt) must contain PEM-encoded certificates.
+func ValidateCerts(certs map[string][]byte) error {
+	for filePath, data := range certs {
+		dir, name := path.Split(filePath)
+		dir = strings.TrimSuffix(dir, "/")
+		if !certsDirRegexp.MatchString(dir) || name == "" {
+			return fmt.Errorf("registry certificate %s must be in a directory named after the registry", filePath)
+		}
+		switch path.Ext(name) {
+		case ".crt":
+			if !x509.NewCertPool().AppendCertsFromPEM(data) {
+				return fmt.Errorf("registry CA certificate %s contains no PEM-encoded certificates", filePath)
+			}
+		case ".cert", ".key":
+			if block, _ := pem.Decode(data); block == nil {
+				return fmt.Errorf("registry client certificate file %s is not PEM-encoded", filePath)
+			}
+		default:
+			return fmt.Errorf("registry certificate %s must be a .crt, .cert or .key file", filePath)
+		}
+	}
+	return nil
+}
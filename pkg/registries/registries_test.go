@@ -0,0 +1,165 @@
+package registries
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/openshift/image-customization-controller/api/v1alpha1"
+)
+
+func TestParse(t *testing.T) {
+	valid := `
+unqualified-search-registries = ["quay.io"]
+short-name-mode = "enforcing"
+credential-helpers = ["containers-auth.json"]
+
+[aliases]
+  "ironic-agent" = "quay.io/metal3-io/ironic-agent"
+
+[[registry]]
+  prefix = ""
+  location = "quay.io/openshift-release-dev/ocp-v4.0-art-dev"
+  mirror-by-digest-only = true
+
+  [[registry.mirror]]
+    location = "mirror.example.com:5000/ocp-v4.0-art-dev"
+    pull-from-mirror = "digest-only"
+
+[[registry]]
+  prefix = "*.example.org"
+  blocked = true
+`
+	config, err := Parse([]byte(valid))
+	assert.NoError(t, err)
+	assert.Len(t, config.Registries, 2)
+	assert.Equal(t, "mirror.example.com:5000/ocp-v4.0-art-dev", config.Registries[0].Mirrors[0].Location)
+
+	for name, data := range map[string]string{
+		"syntax":          "[[registry]\nlocation = \"quay.io\"\n",
+		"no location":     "[[registry]]\ninsecure = true\n",
+		"duplicate":       "[[registry]]\nlocation = \"quay.io\"\n[[registry]]\nprefix = \"quay.io\"\nlocation = \"mirror.example.com\"\n",
+		"wildcard":        "[[registry]]\nlocation = \"*.example.org\"\n",
+		"mirror location": "[[registry]]\nlocation = \"quay.io\"\n[[registry.mirror]]\ninsecure = true\n",
+		"type":            "[[registry]]\nlocation = 42\n",
+		"unknown key":     "[[registry]]\nlocation = \"quay.io\"\nmirror-by-digest = true\n",
+		"unknown table":   "[registries.search]\nregistries = [\"quay.io\"]\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMirrorsDropin(t *testing.T) {
+	data, err := MirrorsDropin([]v1alpha1.RegistryMirror{
+		{
+			Source:             "quay.io/openshift-release-dev",
+			Mirrors:            []string{"mirror.example.com:5000/ocp", "backup.example.com/ocp"},
+			MirrorByDigestOnly: true,
+		},
+		{
+			Source:  "*.example.org",
+			Mirrors: []string{"mirror.example.com:5000"},
+		},
+	})
+	assert.NoError(t, err)
+
+	config, err := Parse(data)
+	assert.NoError(t, err)
+	assert.Equal(t, []Registry{
+		{
+			Prefix:             "quay.io/openshift-release-dev",
+			Location:           "quay.io/openshift-release-dev",
+			MirrorByDigestOnly: true,
+			Mirrors: []Mirror{
+				{Location: "mirror.example.com:5000/ocp"},
+				{Location: "backup.example.com/ocp"},
+			},
+		},
+		{
+			Prefix:  "*.example.org",
+			Mirrors: []Mirror{{Location: "mirror.example.com:5000"}},
+		},
+	}, config.Registries)
+
+	for name, mirrors := range map[string][]v1alpha1.RegistryMirror{
+		"no mirrors":       {{Source: "quay.io"}},
+		"invalid source":   {{Source: "quay.io/Foo", Mirrors: []string{"mirror.example.com"}}},
+		"wildcard mirror":  {{Source: "quay.io", Mirrors: []string{"*.example.com"}}},
+		"duplicate source": {{Source: "quay.io", Mirrors: []string{"a.example.com"}}, {Source: "quay.io", Mirrors: []string{"b.example.com"}}},
+		"injection":        {{Source: "quay.io\"\n[[registry]]", Mirrors: []string{"mirror.example.com"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := MirrorsDropin(mirrors)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestValidateDropins(t *testing.T) {
+	assert.NoError(t, ValidateDropins(map[string][]byte{
+		"01-search.conf": []byte(`unqualified-search-registries = ["quay.io"]`),
+	}))
+	assert.Error(t, ValidateDropins(map[string][]byte{
+		"01-search.toml": []byte(`unqualified-search-registries = ["quay.io"]`),
+	}))
+	assert.Error(t, ValidateDropins(map[string][]byte{
+		MirrorsDropinName: []byte(`unqualified-search-registries = ["quay.io"]`),
+	}))
+	assert.Error(t, ValidateDropins(map[string][]byte{
+		"01-search.conf": []byte(`unqualified-search-registries = "quay.io`),
+	}))
+}
+
+func testCertificate(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "registry.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestValidateCerts(t *testing.T) {
+	cert := testCertificate(t)
+	key := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")})
+
+	assert.NoError(t, ValidateCerts(map[string][]byte{
+		"registry.example.com:5000/ca.crt":      cert,
+		"registry.example.com/client.cert":      cert,
+		"registry.example.com/client.key":       key,
+		"other.example.com/intermediate-ca.crt": cert,
+	}))
+
+	for name, certs := range map[string]map[string][]byte{
+		"not PEM":      {"registry.example.com/ca.crt": []byte("CA")},
+		"key as CA":    {"registry.example.com/ca.crt": key},
+		"no directory": {"ca.crt": cert},
+		"nested":       {"registry.example.com/sub/ca.crt": cert},
+		"extension":    {"registry.example.com/ca.pem": cert},
+		"bad key":      {"registry.example.com/client.key": []byte("key")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, ValidateCerts(certs))
+		})
+	}
+}
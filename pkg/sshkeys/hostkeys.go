@@ -0,0 +1,180 @@
+package sshkeys
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+)
+
+const rsaHostKeyBits = 3072
+
+// hostKey is an SSH host key pair, encoded in the formats that sshd reads.
+type hostKey struct {
+	// keyType is the name of the key type used in the file names, e.g.
+	// "ed25519".
+	keyType string
+	// private is the PEM-encoded private key.
+	private []byte
+	// public is the public key in the SSH wire format.
+	public []byte
+	// bits is the size of the key, as reported by ssh-keygen.
+	bits int
+}
+
+// fileName returns the name of the private key file in /etc/ssh.
+func (k *hostKey) fileName() string {
+	return fmt.Sprintf("ssh_host_%s_key", k.keyType)
+}
+
+// authorizedKey returns the public key in the format of a .pub file.
+func (k *hostKey) authorizedKey(comment string) []byte {
+	algorithm := readString(k.public)
+	return []byte(fmt.Sprintf("%s %s %s\n", algorithm, base64.StdEncoding.EncodeToString(k.public), comment))
+}
+
+// fingerprint returns the fingerprint of the key in the format displayed by
+// ssh-keygen -l and by ssh when connecting to an unknown host.
+func (k *hostKey) fingerprint() string {
+	digest := sha256.Sum256(k.public)
+	return fmt.Sprintf("%d SHA256:%s (%s)", k.bits,
+		base64.RawStdEncoding.EncodeToString(digest[:]), keyTypeLabels[k.keyType])
+}
+
+var keyTypeLabels = map[string]string{
+	"ed25519": "ED25519",
+	"ecdsa":   "ECDSA",
+	"rsa":     "RSA",
+}
+
+// generateHostKeys generates a host key of each type that sshd uses by
+// default.
+func generateHostKeys() ([]*hostKey, error) {
+	generators := []func() (*hostKey, error){
+		generateEd25519,
+		generateECDSA,
+		generateRSA,
+	}
+	keys := make([]*hostKey, 0, len(generators))
+	for _, generate := range generators {
+		key, err := generate()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func generateEd25519() (*hostKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	wire := wireFormat([]byte("ssh-ed25519"), public)
+
+	// sshd reads Ed25519 keys only in the OpenSSH private key format
+	check := make([]byte, 4)
+	if _, err := rand.Read(check); err != nil {
+		return nil, err
+	}
+	section := &bytes.Buffer{}
+	section.Write(check)
+	section.Write(check)
+	section.Write(wireFormat([]byte("ssh-ed25519"), public, private, nil))
+	for i := byte(1); section.Len()%8 != 0; i++ {
+		section.WriteByte(i)
+	}
+
+	body := &bytes.Buffer{}
+	body.WriteString("openssh-key-v1\x00")
+	body.Write(wireFormat([]byte("none"), []byte("none"), nil))
+	body.Write(uint32Bytes(1))
+	body.Write(wireFormat(wire, section.Bytes()))
+
+	return &hostKey{
+		keyType: "ed25519",
+		private: pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: body.Bytes()}),
+		public:  wire,
+		bits:    256,
+	}, nil
+}
+
+func generateECDSA() (*hostKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &hostKey{
+		keyType: "ecdsa",
+		private: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+		public: wireFormat([]byte("ecdsa-sha2-nistp256"), []byte("nistp256"),
+			elliptic.Marshal(elliptic.P256(), key.X, key.Y)),
+		bits: 256,
+	}, nil
+}
+
+func generateRSA() (*hostKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, rsaHostKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	return &hostKey{
+		keyType: "rsa",
+		private: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		public:  wireFormat([]byte("ssh-rsa"), mpint(big.NewInt(int64(key.E))), mpint(key.N)),
+		bits:    key.N.BitLen(),
+	}, nil
+}
+
+// wireFormat encodes each field as an SSH string, i.e. prefixed with its
+// length.
+func wireFormat(fields ...[]byte) []byte {
+	buf := &bytes.Buffer{}
+	for _, field := range fields {
+		buf.Write(uint32Bytes(len(field)))
+		buf.Write(field)
+	}
+	return buf.Bytes()
+}
+
+func uint32Bytes(n int) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(n))
+	return b
+}
+
+// mpint returns the SSH encoding of a positive integer, which has a leading
+// zero byte if the high bit would otherwise be set.
+func mpint(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+// readString returns the first SSH string in data.
+func readString(data []byte) string {
+	if len(data) < 4 {
+		return ""
+	}
+	length := binary.BigEndian.Uint32(data)
+	if uint32(len(data)-4) < length {
+		return ""
+	}
+	return string(data[4 : 4+length])
+}
@@ -0,0 +1,136 @@
+package sshkeys
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sshReader decodes the fields of the SSH wire format.
+type sshReader struct {
+	t    *testing.T
+	data []byte
+}
+
+func (r *sshReader) uint32() uint32 {
+	r.t.Helper()
+	if len(r.data) < 4 {
+		r.t.Fatalf("truncated uint32")
+	}
+	n := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return n
+}
+
+func (r *sshReader) string() []byte {
+	r.t.Helper()
+	n := r.uint32()
+	if uint32(len(r.data)) < n {
+		r.t.Fatalf("truncated string of length %d", n)
+	}
+	s := r.data[:n]
+	r.data = r.data[n:]
+	return s
+}
+
+func TestGenerateEd25519(t *testing.T) {
+	key, err := generateEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, rest := pem.Decode(key.private)
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" || len(rest) != 0 {
+		t.Fatalf("invalid PEM block %q", key.private)
+	}
+	magic := "openssh-key-v1\x00"
+	if !bytes.HasPrefix(block.Bytes, []byte(magic)) {
+		t.Fatalf("missing magic")
+	}
+	r := &sshReader{t: t, data: block.Bytes[len(magic):]}
+	if cipher, kdf, kdfOptions := r.string(), r.string(), r.string(); string(cipher) != "none" ||
+		string(kdf) != "none" || len(kdfOptions) != 0 {
+		t.Errorf("unexpected encryption %q %q %q", cipher, kdf, kdfOptions)
+	}
+	if n := r.uint32(); n != 1 {
+		t.Fatalf("unexpected number of keys %d", n)
+	}
+	publicBlob := r.string()
+	if !bytes.Equal(publicBlob, key.public) {
+		t.Errorf("public key does not match")
+	}
+	section := r.string()
+	if len(r.data) != 0 {
+		t.Errorf("trailing data after private section")
+	}
+
+	pub := &sshReader{t: t, data: publicBlob}
+	if keyType := pub.string(); string(keyType) != "ssh-ed25519" {
+		t.Errorf("unexpected public key type %q", keyType)
+	}
+	public := pub.string()
+	if len(public) != ed25519.PublicKeySize || len(pub.data) != 0 {
+		t.Errorf("invalid public key of length %d", len(public))
+	}
+
+	if len(section)%8 != 0 {
+		t.Errorf("private section of length %d is not padded", len(section))
+	}
+	priv := &sshReader{t: t, data: section}
+	if check1, check2 := priv.uint32(), priv.uint32(); check1 != check2 {
+		t.Errorf("check ints differ: %d %d", check1, check2)
+	}
+	if keyType := priv.string(); string(keyType) != "ssh-ed25519" {
+		t.Errorf("unexpected private key type %q", keyType)
+	}
+	if privPublic := priv.string(); !bytes.Equal(privPublic, public) {
+		t.Errorf("public key in private section does not match")
+	}
+	private := priv.string()
+	if len(private) != ed25519.PrivateKeySize {
+		t.Fatalf("invalid private key of length %d", len(private))
+	}
+	if !bytes.Equal(ed25519.PrivateKey(private).Public().(ed25519.PublicKey), public) {
+		t.Errorf("private key does not match public key")
+	}
+	if comment := priv.string(); len(comment) != 0 {
+		t.Errorf("unexpected comment %q", comment)
+	}
+	for i, b := range priv.data {
+		if b != byte(i+1) {
+			t.Errorf("invalid padding %v", priv.data)
+			break
+		}
+	}
+}
+
+func TestGenerateEd25519SSHKeygen(t *testing.T) {
+	sshKeygen, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		t.Skip("ssh-keygen not available")
+	}
+	key, err := generateEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "ssh_host_ed25519_key")
+	if err := os.WriteFile(keyFile, key.private, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(sshKeygen, "-y", "-f", keyFile).Output()
+	if err != nil {
+		t.Fatalf("ssh-keygen rejected the key: %v", err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 || fields[0] != "ssh-ed25519" || fields[1] != base64.StdEncoding.EncodeToString(key.public) {
+		t.Errorf("unexpected public key %q", out)
+	}
+}
@@ -0,0 +1,104 @@
+package sshkeys
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift/image-customization-controller/pkg/imagesecret"
+)
+
+const (
+	// NetworkDataKey is the key in the network data Secret containing public
+	// SSH keys (in authorized_keys format) that are authorized to log in to
+	// the ramdisk of that host only.
+	NetworkDataKey = "sshAuthorizedKeys"
+
+	// FingerprintsAnnotation on the host keys Secret lists the fingerprints
+	// of the host keys, one per line.
+	FingerprintsAnnotation = "imagecustomization.openshift.io/ssh-host-key-fingerprints"
+
+	hostKeyPrefix = "ssh_host_"
+)
+
+// AuthorizedKeys returns the public SSH keys that are authorized to log in to
+// the ramdisk of a particular host.
+func AuthorizedKeys(networkData map[string][]byte) string {
+	return strings.TrimSpace(string(networkData[NetworkDataKey]))
+}
+
+// SecretName returns the name of the Secret holding the SSH host keys for an
+// image.
+func SecretName(imageName string) string {
+	return imageName + "-ssh-host-keys"
+}
+
+// HostKeys provides the SSH host keys for each image.
+type HostKeys interface {
+	// HostKeys returns the contents of each of the host key files in
+	// /etc/ssh, indexed by file name.
+	HostKeys(ctx context.Context, image *metav1.ObjectMeta) (map[string][]byte, error)
+}
+
+type secretHostKeys struct {
+	client    client.Client
+	apiReader client.Reader
+}
+
+// NewHostKeys returns HostKeys that generates the keys for each image once,
+// and stores them in a Secret in the image's namespace along with their
+// public keys and fingerprints, so that they can be verified when connecting
+// to the ramdisk. The Secret is owned by the PreprovisioningImage, so that it
+// is deleted along with it.
+func NewHostKeys(c client.Client, apiReader client.Reader) HostKeys {
+	return &secretHostKeys{client: c, apiReader: apiReader}
+}
+
+func (h *secretHostKeys) HostKeys(ctx context.Context, image *metav1.ObjectMeta) (map[string][]byte, error) {
+	secret, err := imagesecret.GetOrCreate(ctx, h.client, h.apiReader, image, SecretName(image.Name),
+		func() (*corev1.Secret, error) { return hostKeysSecret(image) })
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string][]byte{}
+	for name, data := range secret.Data {
+		if strings.HasPrefix(name, hostKeyPrefix) {
+			keys[name] = data
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Secret %s/%s contains no SSH host keys", secret.Namespace, secret.Name)
+	}
+	return keys, nil
+}
+
+// hostKeysSecret returns a Secret containing newly generated host keys.
+func hostKeysSecret(image *metav1.ObjectMeta) (*corev1.Secret, error) {
+	hostKeys, err := generateHostKeys()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate SSH host keys: %w", err)
+	}
+
+	comment := fmt.Sprintf("%s/%s", image.Namespace, image.Name)
+	data := map[string][]byte{}
+	fingerprints := make([]string, 0, len(hostKeys))
+	for _, key := range hostKeys {
+		data[key.fileName()] = key.private
+		data[key.fileName()+".pub"] = key.authorizedKey(comment)
+		fingerprints = append(fingerprints, key.fingerprint())
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				FingerprintsAnnotation: strings.Join(fingerprints, "\n"),
+			},
+		},
+		Data: data,
+	}, nil
+}
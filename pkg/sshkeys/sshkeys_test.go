@@ -0,0 +1,131 @@
+package sshkeys
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type secretStore struct {
+	client.Client
+	secrets map[client.ObjectKey]*corev1.Secret
+}
+
+func (s *secretStore) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	secret, exists := s.secrets[key]
+	if !exists {
+		return k8serrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, key.Name)
+	}
+	secret.DeepCopyInto(obj.(*corev1.Secret))
+	return nil
+}
+
+func (s *secretStore) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	key := client.ObjectKeyFromObject(obj)
+	if _, exists := s.secrets[key]; exists {
+		return k8serrors.NewAlreadyExists(schema.GroupResource{Resource: "secrets"}, key.Name)
+	}
+	s.secrets[key] = obj.(*corev1.Secret).DeepCopy()
+	return nil
+}
+
+func TestFingerprint(t *testing.T) {
+	// Expected value from ssh-keygen -l
+	public, _ := base64.StdEncoding.DecodeString("AAAAC3NzaC1lZDI1NTE5AAAAIDddIIyI+jj1RMAfJpqasljUx0WbatnvMwBGR6Bkcrdx")
+	key := &hostKey{keyType: "ed25519", public: public, bits: 256}
+	expected := "256 SHA256:oX04W6Yr0qEmfR4NLSZZ/KcVxYyVosUz5dtf+psJFAs (ED25519)"
+	if fp := key.fingerprint(); fp != expected {
+		t.Errorf("unexpected fingerprint %q", fp)
+	}
+	if pub := string(key.authorizedKey("test/host-0")); !strings.HasPrefix(pub, "ssh-ed25519 AAAAC3") {
+		t.Errorf("unexpected public key %q", pub)
+	}
+}
+
+func TestHostKeys(t *testing.T) {
+	store := &secretStore{secrets: map[client.ObjectKey]*corev1.Secret{}}
+	hostKeys := NewHostKeys(store, store)
+	image := &metav1.ObjectMeta{Namespace: "test", Name: "host-0", UID: "0123"}
+
+	keys, err := hostKeys.HostKeys(context.Background(), image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"ssh_host_ed25519_key", "ssh_host_ed25519_key.pub",
+		"ssh_host_ecdsa_key", "ssh_host_ecdsa_key.pub",
+		"ssh_host_rsa_key", "ssh_host_rsa_key.pub",
+	} {
+		if len(keys[name]) == 0 {
+			t.Errorf("missing %s", name)
+		}
+	}
+	if len(keys) != 6 {
+		t.Errorf("unexpected keys %d", len(keys))
+	}
+
+	block, _ := pem.Decode(keys["ssh_host_ecdsa_key"])
+	if _, err := x509.ParseECPrivateKey(block.Bytes); err != nil {
+		t.Errorf("invalid ECDSA key: %v", err)
+	}
+	block, _ = pem.Decode(keys["ssh_host_rsa_key"])
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		t.Errorf("invalid RSA key: %v", err)
+	}
+	block, _ = pem.Decode(keys["ssh_host_ed25519_key"])
+	if block.Type != "OPENSSH PRIVATE KEY" || !strings.HasPrefix(string(block.Bytes), "openssh-key-v1\x00") {
+		t.Errorf("invalid Ed25519 key %q", block.Type)
+	}
+
+	secret := store.secrets[client.ObjectKey{Namespace: "test", Name: "host-0-ssh-host-keys"}]
+	if secret == nil {
+		t.Fatal("Secret not created")
+	}
+	fingerprints := strings.Split(secret.Annotations[FingerprintsAnnotation], "\n")
+	if len(fingerprints) != 3 || !strings.HasSuffix(fingerprints[0], "(ED25519)") {
+		t.Errorf("unexpected fingerprints %q", fingerprints)
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].UID != image.UID {
+		t.Errorf("unexpected owner references %v", secret.OwnerReferences)
+	}
+
+	again, err := hostKeys.HostKeys(context.Background(), image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again["ssh_host_ed25519_key"]) != string(keys["ssh_host_ed25519_key"]) {
+		t.Error("host keys changed")
+	}
+}
+
+func TestHostKeysNotOwned(t *testing.T) {
+	store := &secretStore{secrets: map[client.ObjectKey]*corev1.Secret{}}
+	hostKeys := NewHostKeys(store, store)
+	image := &metav1.ObjectMeta{Namespace: "test", Name: "host-0", UID: "0123"}
+
+	// Left over from a previous image with the same name
+	key := client.ObjectKey{Namespace: "test", Name: "host-0-ssh-host-keys"}
+	store.secrets[key] = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: key.Namespace,
+			Name:      key.Name,
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind: "PreprovisioningImage",
+				Name: "host-0",
+				UID:  "4567",
+			}},
+		},
+	}
+	if _, err := hostKeys.HostKeys(context.Background(), image); err == nil {
+		t.Error("expected error for Secret owned by another image")
+	}
+}